@@ -0,0 +1,134 @@
+// Package certs loads TLS certificates and CA bundles and reloads them when
+// their files change on disk, for the server and the client
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// CheckInterval is how often the files are checked for changes
+var CheckInterval = time.Second
+
+// Reloader keeps a certificate and a CA pool loaded from files, each file
+// is optional
+type Reloader struct {
+	certFile string
+	keyFile  string
+	caFile   string
+
+	mtx      sync.Mutex
+	checked  time.Time
+	modTimes [3]time.Time
+
+	cert *tls.Certificate
+	pool *x509.CertPool
+}
+
+// NewReloader loads the certificate and key files and the CA file, the
+// certificate requires both the certificate and the key file
+func NewReloader(certFile, keyFile, caFile string) (*Reloader, error) {
+	if (certFile == "") != (keyFile == "") {
+		return nil, errors.New("certificate and key files must be set together")
+	}
+	r := &Reloader{certFile: certFile, keyFile: keyFile, caFile: caFile}
+	modTimes, err := r.stat()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.load(modTimes); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// stat returns the modification times of the files
+func (r *Reloader) stat() ([3]time.Time, error) {
+	var modTimes [3]time.Time
+	for i, file := range []string{r.certFile, r.keyFile, r.caFile} {
+		if file == "" {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return modTimes, err
+		}
+		modTimes[i] = info.ModTime()
+	}
+	return modTimes, nil
+}
+
+// load loads the files and records their modification times
+func (r *Reloader) load(modTimes [3]time.Time) error {
+	var cert *tls.Certificate
+	if r.certFile != "" {
+		c, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+		if err != nil {
+			return err
+		}
+		cert = &c
+	}
+
+	var pool *x509.CertPool
+	if r.caFile != "" {
+		pem, err := os.ReadFile(r.caFile)
+		if err != nil {
+			return err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates in CA file %s", r.caFile)
+		}
+	}
+
+	r.cert, r.pool, r.modTimes = cert, pool, modTimes
+	return nil
+}
+
+// reload reloads the files if they changed since they were loaded, the
+// loaded files are kept if the changed files are invalid, e.g. while they are written
+func (r *Reloader) reload() {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if time.Since(r.checked) < CheckInterval {
+		return
+	}
+	r.checked = time.Now()
+
+	modTimes, err := r.stat()
+	if err != nil {
+		logrus.Error("Error checking certificate files: ", err)
+		return
+	}
+	if modTimes == r.modTimes {
+		return
+	}
+	if err := r.load(modTimes); err != nil {
+		logrus.Error("Error reloading certificate files: ", err)
+		return
+	}
+	logrus.Info("Reloaded certificate files")
+}
+
+// Certificate returns the certificate, reloaded if its files changed
+func (r *Reloader) Certificate() *tls.Certificate {
+	r.reload()
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.cert
+}
+
+// CertPool returns the CA pool, reloaded if its file changed
+func (r *Reloader) CertPool() *x509.CertPool {
+	r.reload()
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.pool
+}
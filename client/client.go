@@ -1,12 +1,16 @@
 package client
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	. "fs-store/types"
 	"io"
+	"mime"
+	"net/http"
 	"net/url"
+	"path/filepath"
 	"strconv"
 	"strings"
 
@@ -30,7 +34,15 @@ func NewFSClientConfig(rawAddress string, verbose bool) (*FSClientConfig, error)
 		return nil, err
 	}
 
-	client := resty.New().OnAfterResponse(func(c *resty.Client, r *resty.Response) error {
+	client := resty.New().SetPreRequestHook(func(c *resty.Client, r *http.Request) error {
+		// resty streams io.Reader bodies without a length, use the header value if set
+		if r.ContentLength == 0 && r.Body != nil && r.Body != http.NoBody {
+			if size, err := strconv.ParseInt(r.Header.Get("Content-Length"), 10, 64); err == nil {
+				r.ContentLength = size
+			}
+		}
+		return nil
+	}).OnAfterResponse(func(c *resty.Client, r *resty.Response) error {
 		if verbose {
 			fmt.Println(r.StatusCode(), string(r.Body()))
 		}
@@ -45,6 +57,11 @@ func NewFSClientConfig(rawAddress string, verbose bool) (*FSClientConfig, error)
 	}, nil
 }
 
+// SetToken sets the API key sent as a bearer token with every request
+func (conf *FSClientConfig) SetToken(token string) {
+	conf.Client.SetAuthToken(token)
+}
+
 // DeleteFile deletes a file
 func (conf *FSClientConfig) DeleteFile(fileName string) error {
 	genResponse := &GenericResponse{}
@@ -65,15 +82,69 @@ func (conf *FSClientConfig) DeleteFile(fileName string) error {
 	return nil
 }
 
+// DeleteDirectory deletes every file in a virtual directory and returns the deleted names
+func (conf *FSClientConfig) DeleteDirectory(dir string) ([]string, error) {
+	deleteResponse := &DeleteResponse{}
+	resp, err := conf.Client.R().
+		SetResult(deleteResponse).
+		SetQueryParam("prefix", dir).
+		Delete("/files")
+
+	if err != nil {
+		return nil, err
+	} else if resp.IsError() {
+		return nil, responseError(resp)
+	}
+	return deleteResponse.Deleted, nil
+}
+
+// DownloadFile downloads a file and writes its content to w
+func (conf *FSClientConfig) DownloadFile(fileName string, w io.Writer) error {
+	resp, err := conf.Client.R().
+		SetDoNotParseResponse(true).
+		SetPathParam("name", fileName).
+		Get("/files/{name}")
+
+	if err != nil {
+		return err
+	}
+	body := resp.RawBody()
+	defer body.Close()
+
+	if resp.IsError() {
+		genResponse := &GenericResponse{}
+		err := json.NewDecoder(body).Decode(genResponse)
+		if err != nil {
+			return errors.New("unknown error")
+		}
+		return errors.New(genResponse.Message)
+	}
+
+	_, err = io.Copy(w, body)
+	return err
+}
+
+// UploadFile uploads a file by streaming the content of r in the request body,
+// content of unknown size is sent with chunked transfer encoding
 func (conf *FSClientConfig) UploadFile(fileName string, r io.Reader, overwrite bool) error {
-	genResponse := &GenericResponse{}
+	size, err := readerSize(r)
+	if err != nil {
+		return err
+	}
+
 	req := conf.Client.R()
+	if size >= 0 {
+		req.SetHeader("Content-Length", strconv.FormatInt(size, 10))
+	}
 
+	genResponse := &GenericResponse{}
 	resp, err := req.
 		SetQueryParam("overwrite", strconv.FormatBool(overwrite)).
-		SetMultipartField("file", fileName, "application/octet-stream", r).
+		SetPathParam("name", fileName).
+		SetHeader("Content-Type", contentType(fileName)).
+		SetBody(r).
 		SetResult(genResponse).
-		Post("/files")
+		Put("/files/{name}")
 
 	if err != nil {
 		return err
@@ -87,11 +158,113 @@ func (conf *FSClientConfig) UploadFile(fileName string, r io.Reader, overwrite b
 	return nil
 }
 
+// contentType returns the content type for a file name based on its extension
+func contentType(fileName string) string {
+	if ct := mime.TypeByExtension(filepath.Ext(fileName)); ct != "" {
+		return ct
+	}
+	return "application/octet-stream"
+}
+
+// readerSize returns the remaining size of a reader, -1 if the reader can't
+// report its size or seek
+func readerSize(r io.Reader) (int64, error) {
+	switch v := r.(type) {
+	case interface{ Len() int }:
+		return int64(v.Len()), nil
+	case io.Seeker:
+		cur, err := v.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return 0, err
+		}
+		end, err := v.Seek(0, io.SeekEnd)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := v.Seek(cur, io.SeekStart); err != nil {
+			return 0, err
+		}
+		return end - cur, nil
+	}
+	return -1, nil
+}
+
+// ListOptions are the options for listing files
+type ListOptions struct {
+	// Limit is the page size, the server limit is used if not set
+	Limit  int
+	Prefix string
+	// Sort is one of name, size or createdAt
+	Sort string
+	// Order is asc or desc
+	Order string
+	// Delimiter groups names into common prefixes, it requires sorting by name
+	Delimiter string
+}
+
+// ListFiles lists all files on the server
 func (conf *FSClientConfig) ListFiles() ([]FileResponse, error) {
-	var files []FileResponse
-	resp, err := conf.Client.R().
-		SetResult(&files).
-		Get("/files")
+	return conf.ListAllFiles(ListOptions{})
+}
+
+// ListAllFiles lists all files matching the options by requesting every page
+func (conf *FSClientConfig) ListAllFiles(opts ListOptions) ([]FileResponse, error) {
+	files := make([]FileResponse, 0)
+	cursor := ""
+	for {
+		page, err := conf.ListFilesPage(opts, cursor)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, page.Files...)
+
+		if page.NextCursor == "" {
+			return files, nil
+		}
+		cursor = page.NextCursor
+	}
+}
+
+// ListDirectory lists the files and subdirectories of a virtual directory,
+// subdirectories are returned as prefixes ending with /
+func (conf *FSClientConfig) ListDirectory(dir string) ([]string, []FileResponse, error) {
+	opts := ListOptions{Prefix: dir, Delimiter: "/"}
+	prefixes := make([]string, 0)
+	files := make([]FileResponse, 0)
+	cursor := ""
+	for {
+		page, err := conf.ListFilesPage(opts, cursor)
+		if err != nil {
+			return nil, nil, err
+		}
+		prefixes = append(prefixes, page.CommonPrefixes...)
+		files = append(files, page.Files...)
+
+		if page.NextCursor == "" {
+			return prefixes, files, nil
+		}
+		cursor = page.NextCursor
+	}
+}
+
+// ListFilesPage lists the page of files after the cursor
+func (conf *FSClientConfig) ListFilesPage(opts ListOptions, cursor string) (*FileListResponse, error) {
+	req := conf.Client.R()
+	if opts.Limit > 0 {
+		req.SetQueryParam("limit", strconv.Itoa(opts.Limit))
+	}
+	for key, value := range map[string]string{
+		"prefix":    opts.Prefix,
+		"sort":      opts.Sort,
+		"order":     opts.Order,
+		"delimiter": opts.Delimiter,
+		"cursor":    cursor,
+	} {
+		if value != "" {
+			req.SetQueryParam(key, value)
+		}
+	}
+	resp, err := req.Get("/files")
 
 	if err != nil {
 		return nil, err
@@ -103,6 +276,82 @@ func (conf *FSClientConfig) ListFiles() ([]FileResponse, error) {
 		}
 		return nil, errors.New(genResponse.Message)
 	}
-	return files, nil
 
+	// older servers respond with a single list of files
+	page := &FileListResponse{}
+	body := bytes.TrimSpace(resp.Body())
+	if len(body) > 0 && body[0] == '[' {
+		err = json.Unmarshal(body, &page.Files)
+	} else {
+		err = json.Unmarshal(body, page)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// Fsck checks the files on the server, quarantining bad files if set
+func (conf *FSClientConfig) Fsck(quarantine bool) (*FsckReport, error) {
+	report := &FsckReport{}
+	resp, err := conf.Client.R().
+		SetQueryParam("quarantine", strconv.FormatBool(quarantine)).
+		SetResult(report).
+		Post("/admin/fsck")
+
+	if err != nil {
+		return nil, err
+	} else if resp.IsError() {
+		return nil, responseError(resp)
+	}
+	return report, nil
+}
+
+// SignURL asks the server to sign a URL and returns it with the server address
+func (conf *FSClientConfig) SignURL(req SignRequest) (*SignResponse, error) {
+	signResponse := &SignResponse{}
+	resp, err := conf.Client.R().
+		SetBody(req).
+		SetResult(signResponse).
+		Post("/sign")
+
+	if err != nil {
+		return nil, err
+	} else if resp.IsError() {
+		return nil, responseError(resp)
+	}
+	signResponse.URL = conf.Client.BaseURL + signResponse.URL
+	return signResponse, nil
+}
+
+// Info returns the version and limits of the server
+func (conf *FSClientConfig) Info() (*InfoResponse, error) {
+	info := &InfoResponse{}
+	resp, err := conf.Client.R().
+		SetResult(info).
+		Get("/info")
+
+	if err != nil {
+		return nil, err
+	} else if resp.IsError() {
+		return nil, responseError(resp)
+	}
+	return info, nil
+}
+
+// Ready returns the readiness checks of the server, a server which isn't
+// ready returns its checks without an error
+func (conf *FSClientConfig) Ready() (*ReadinessResponse, error) {
+	ready := &ReadinessResponse{}
+	resp, err := conf.Client.R().
+		SetResult(ready).
+		SetError(ready).
+		Get("/readyz")
+
+	if err != nil {
+		return nil, err
+	} else if resp.StatusCode() != 200 && resp.StatusCode() != 503 {
+		return nil, responseError(resp)
+	}
+	return ready, nil
 }
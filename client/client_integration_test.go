@@ -2,9 +2,12 @@
 package client_test
 
 import (
+	"bytes"
 	"fs-store/client"
 	. "fs-store/types"
+	"io"
 	"net/http"
+	"strings"
 	"testing"
 	"time"
 
@@ -117,3 +120,243 @@ func TestIntegration_ListFiles(t *testing.T) {
 
 	httpmock.DeactivateAndReset()
 }
+
+// TestIntegration_DownloadFile tests the DownloadFile functionality
+func TestIntegration_DownloadFile(t *testing.T) {
+	domain := "http://domain"
+	fileName := "text.txt"
+	content := "file content"
+
+	conf, err := client.NewFSClientConfig(domain, false)
+	assert.NoError(t, err, "No error expected")
+	httpmock.ActivateNonDefault(conf.Client.GetClient())
+
+	httpmock.RegisterResponder("GET", conf.Client.BaseURL+"/files/"+fileName,
+		httpmock.NewStringResponder(http.StatusOK, content))
+
+	buf := &bytes.Buffer{}
+	err = conf.DownloadFile(fileName, buf)
+	assert.NoError(t, err, "No error expected")
+	assert.Equal(t, content, buf.String(), "Expected content to be %s, got %s", content, buf.String())
+
+	assert.Equal(t, 1, httpmock.GetTotalCallCount(),
+		"expected %d calls", 1)
+
+	httpmock.DeactivateAndReset()
+}
+
+// TestIntegration_DownloadFileNotFound tests the DownloadFile error handling
+func TestIntegration_DownloadFileNotFound(t *testing.T) {
+	domain := "http://domain"
+	fileName := "text.txt"
+
+	conf, err := client.NewFSClientConfig(domain, false)
+	assert.NoError(t, err, "No error expected")
+	httpmock.ActivateNonDefault(conf.Client.GetClient())
+
+	httpmock.RegisterResponder("GET", conf.Client.BaseURL+"/files/"+fileName,
+		func(req *http.Request) (*http.Response, error) {
+			return httpmock.NewJsonResponse(http.StatusNotFound, GenericResponse{
+				Success: false, Message: "File doesn't exist",
+			})
+		},
+	)
+
+	buf := &bytes.Buffer{}
+	err = conf.DownloadFile(fileName, buf)
+	assert.EqualError(t, err, "File doesn't exist", "Expected error from server")
+	assert.Equal(t, 0, buf.Len(), "Expected no content to be written")
+
+	httpmock.DeactivateAndReset()
+}
+
+// TestIntegration_UploadFileStream tests that UploadFile streams the content
+func TestIntegration_UploadFileStream(t *testing.T) {
+	domain := "http://domain"
+	fileName := "text.txt"
+	content := "file content"
+
+	conf, err := client.NewFSClientConfig(domain, false)
+	assert.NoError(t, err, "No error expected")
+	httpmock.ActivateNonDefault(conf.Client.GetClient())
+
+	httpmock.RegisterResponder("PUT", conf.Client.BaseURL+"/files/"+fileName+"?overwrite=true",
+		func(req *http.Request) (*http.Response, error) {
+			body, err := io.ReadAll(req.Body)
+			assert.NoError(t, err, "No error expected")
+			assert.Equal(t, content, string(body), "Expected body to be %s, got %s", content, string(body))
+			assert.Equal(t, int64(len(content)), req.ContentLength, "Expected content length to be set")
+			return httpmock.NewJsonResponse(http.StatusOK, GenericResponse{
+				Success: true, Message: "File uploaded",
+			})
+		},
+	)
+
+	err = conf.UploadFile(fileName, strings.NewReader(content), true)
+	assert.NoError(t, err, "No error expected")
+
+	assert.Equal(t, 1, httpmock.GetTotalCallCount(),
+		"expected %d calls", 1)
+
+	httpmock.DeactivateAndReset()
+}
+
+// TestIntegration_UploadFileUnknownSize tests that content of unknown size is streamed without a length
+func TestIntegration_UploadFileUnknownSize(t *testing.T) {
+	domain := "http://domain"
+	fileName := "text.txt"
+	content := "file content"
+
+	conf, err := client.NewFSClientConfig(domain, false)
+	assert.NoError(t, err, "No error expected")
+	httpmock.ActivateNonDefault(conf.Client.GetClient())
+
+	httpmock.RegisterResponder("PUT", conf.Client.BaseURL+"/files/"+fileName+"?overwrite=false",
+		func(req *http.Request) (*http.Response, error) {
+			body, err := io.ReadAll(req.Body)
+			assert.NoError(t, err, "No error expected")
+			assert.Equal(t, content, string(body), "Expected body to be %s, got %s", content, string(body))
+			// a zero length with a body is sent with chunked transfer encoding
+			assert.Equal(t, int64(0), req.ContentLength, "Expected content length to be unknown")
+			assert.Empty(t, req.Header.Get("Content-Length"), "Expected no content length header")
+			return httpmock.NewJsonResponse(http.StatusOK, GenericResponse{
+				Success: true, Message: "File uploaded",
+			})
+		},
+	)
+
+	// a reader which can't report its size or seek, like stdin
+	err = conf.UploadFile(fileName, io.MultiReader(strings.NewReader(content)), false)
+	assert.NoError(t, err, "No error expected")
+
+	assert.Equal(t, 1, httpmock.GetTotalCallCount(),
+		"expected %d calls", 1)
+
+	httpmock.DeactivateAndReset()
+}
+
+// TestIntegration_UploadMissingChunks tests that only missing chunks are uploaded
+func TestIntegration_UploadMissingChunks(t *testing.T) {
+	domain := "http://domain"
+	content := "0123456789"
+	uploadID := "00112233445566778899aabbccddeeff"
+
+	conf, err := client.NewFSClientConfig(domain, false)
+	assert.NoError(t, err, "No error expected")
+	httpmock.ActivateNonDefault(conf.Client.GetClient())
+
+	uploaded := map[string]string{}
+	httpmock.RegisterResponder("PUT", `=~^`+conf.Client.BaseURL+`/uploads/`+uploadID+`/chunks/\d+$`,
+		func(req *http.Request) (*http.Response, error) {
+			body, err := io.ReadAll(req.Body)
+			assert.NoError(t, err, "No error expected")
+			uploaded[req.URL.Path] = string(body)
+			return httpmock.NewJsonResponse(http.StatusOK, UploadSessionResponse{UploadID: uploadID})
+		},
+	)
+
+	session := &UploadSessionResponse{
+		UploadID:  uploadID,
+		FileSize:  int64(len(content)),
+		ChunkSize: 4,
+		Received:  []ByteRange{{Start: 4, End: 8}},
+	}
+	err = conf.UploadMissingChunks(session, strings.NewReader(content))
+	assert.NoError(t, err, "No error expected")
+
+	assert.Equal(t, map[string]string{
+		"/uploads/" + uploadID + "/chunks/0": "0123",
+		"/uploads/" + uploadID + "/chunks/2": "89",
+	}, uploaded, "Expected only missing chunks to be uploaded")
+
+	httpmock.DeactivateAndReset()
+}
+
+// TestIntegration_ListFilesPages tests that ListFiles requests every page
+func TestIntegration_ListFilesPages(t *testing.T) {
+	domain := "http://domain"
+
+	conf, err := client.NewFSClientConfig(domain, false)
+	assert.NoError(t, err, "No error expected")
+	httpmock.ActivateNonDefault(conf.Client.GetClient())
+
+	httpmock.RegisterResponder("GET", conf.Client.BaseURL+"/files",
+		func(req *http.Request) (*http.Response, error) {
+			if req.URL.Query().Get("cursor") == "" {
+				return httpmock.NewJsonResponse(http.StatusOK, FileListResponse{
+					Files:      []FileResponse{{FileName: "file1.txt"}, {FileName: "file2.txt"}},
+					NextCursor: "next",
+				})
+			}
+			assert.Equal(t, "next", req.URL.Query().Get("cursor"), "Expected cursor of previous page")
+			return httpmock.NewJsonResponse(http.StatusOK, FileListResponse{
+				Files: []FileResponse{{FileName: "file3.txt"}},
+			})
+		},
+	)
+
+	files, err := conf.ListFiles()
+	assert.NoError(t, err, "No error expected")
+	if assert.Len(t, files, 3, "Expected files of every page") {
+		assert.Equal(t, "file3.txt", files[2].FileName, "Expected file name to be file3.txt, got %s", files[2].FileName)
+	}
+
+	assert.Equal(t, 2, httpmock.GetTotalCallCount(),
+		"expected %d calls", 2)
+
+	httpmock.DeactivateAndReset()
+}
+
+// TestIntegration_ListDirectory tests that ListDirectory lists with the delimiter
+func TestIntegration_ListDirectory(t *testing.T) {
+	domain := "http://domain"
+
+	conf, err := client.NewFSClientConfig(domain, false)
+	assert.NoError(t, err, "No error expected")
+	httpmock.ActivateNonDefault(conf.Client.GetClient())
+
+	httpmock.RegisterResponder("GET", conf.Client.BaseURL+"/files",
+		func(req *http.Request) (*http.Response, error) {
+			assert.Equal(t, "team/", req.URL.Query().Get("prefix"), "Expected directory as prefix")
+			assert.Equal(t, "/", req.URL.Query().Get("delimiter"), "Expected / as delimiter")
+			return httpmock.NewJsonResponse(http.StatusOK, FileListResponse{
+				Files:          []FileResponse{{FileName: "team/build.log"}},
+				CommonPrefixes: []string{"team/a/"},
+			})
+		},
+	)
+
+	dirs, files, err := conf.ListDirectory("team/")
+	assert.NoError(t, err, "No error expected")
+	assert.Equal(t, []string{"team/a/"}, dirs, "Expected subdirectories")
+	if assert.Len(t, files, 1, "Expected files of the directory") {
+		assert.Equal(t, "team/build.log", files[0].FileName)
+	}
+
+	httpmock.DeactivateAndReset()
+}
+
+// TestIntegration_DeleteDirectory tests the DeleteDirectory functionality
+func TestIntegration_DeleteDirectory(t *testing.T) {
+	domain := "http://domain"
+
+	conf, err := client.NewFSClientConfig(domain, false)
+	assert.NoError(t, err, "No error expected")
+	httpmock.ActivateNonDefault(conf.Client.GetClient())
+
+	httpmock.RegisterResponder("DELETE", conf.Client.BaseURL+"/files",
+		func(req *http.Request) (*http.Response, error) {
+			assert.Equal(t, "team/", req.URL.Query().Get("prefix"), "Expected directory as prefix")
+			return httpmock.NewJsonResponse(http.StatusOK, DeleteResponse{
+				Success: true,
+				Deleted: []string{"team/a.txt", "team/b/c.txt"},
+			})
+		},
+	)
+
+	deleted, err := conf.DeleteDirectory("team/")
+	assert.NoError(t, err, "No error expected")
+	assert.Equal(t, []string{"team/a.txt", "team/b/c.txt"}, deleted, "Expected deleted file names")
+
+	httpmock.DeactivateAndReset()
+}
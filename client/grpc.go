@@ -0,0 +1,230 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"fs-store/rpc"
+	. "fs-store/types"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// grpcChunkSize is the size of the content chunks streamed to the server
+const grpcChunkSize = 64 * 1024
+
+// FileClient is implemented by the REST and the gRPC client
+type FileClient interface {
+	UploadFile(fileName string, r io.Reader, overwrite bool) error
+	DownloadFile(fileName string, w io.Writer) error
+	DeleteFile(fileName string) error
+	DeleteDirectory(dir string) ([]string, error)
+	ListAllFiles(opts ListOptions) ([]FileResponse, error)
+	ListDirectory(dir string) ([]string, []FileResponse, error)
+}
+
+var (
+	_ FileClient = (*FSClientConfig)(nil)
+	_ FileClient = (*GRPCClientConfig)(nil)
+)
+
+// GRPCClientConfig is the configuration for the gRPC client
+type GRPCClientConfig struct {
+	Conn    *grpc.ClientConn
+	Client  rpc.FileStoreClient
+	Verbose bool
+}
+
+// NewGRPCClientConfig creates a new gRPC client configuration for the address of
+// the gRPC server, the connection is insecure unless the options set credentials
+func NewGRPCClientConfig(address string, verbose bool, opts ...grpc.DialOption) (*GRPCClientConfig, error) {
+	opts = append([]grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}, opts...)
+	conn, err := grpc.Dial(address, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &GRPCClientConfig{
+		Conn:    conn,
+		Client:  rpc.NewFileStoreClient(conn),
+		Verbose: verbose,
+	}, nil
+}
+
+// tokenCredentials sends the API key as a bearer token with every call
+type tokenCredentials string
+
+func (t tokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(t)}, nil
+}
+
+func (t tokenCredentials) RequireTransportSecurity() bool {
+	return false
+}
+
+// WithToken is the dial option for sending the API key as a bearer token
+func WithToken(token string) grpc.DialOption {
+	return grpc.WithPerRPCCredentials(tokenCredentials(token))
+}
+
+// Close closes the connection to the server
+func (conf *GRPCClientConfig) Close() error {
+	return conf.Conn.Close()
+}
+
+// grpcError returns the error with the message of a gRPC status
+func (conf *GRPCClientConfig) grpcError(err error) error {
+	if err == nil {
+		return nil
+	}
+	s, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	if conf.Verbose {
+		fmt.Println(s.Code(), s.Message())
+	}
+	return errors.New(s.Message())
+}
+
+// fileResponse converts the file message to the file information of the REST API
+func fileResponse(info *rpc.FileInfo) FileResponse {
+	return FileResponse{
+		FileName:    info.FileName,
+		FileSize:    info.FileSize,
+		CreatedAt:   info.CreatedAt.AsTime(),
+		ModifiedAt:  info.ModifiedAt.AsTime(),
+		ContentType: info.ContentType,
+		Metadata:    info.Metadata,
+	}
+}
+
+// UploadFile uploads a file by streaming the content of r in chunks, the size
+// is sent as -1 if it's unknown
+func (conf *GRPCClientConfig) UploadFile(fileName string, r io.Reader, overwrite bool) error {
+	size, err := readerSize(r)
+	if err != nil {
+		return err
+	}
+
+	stream, err := conf.Client.Upload(context.Background())
+	if err != nil {
+		return conf.grpcError(err)
+	}
+	err = stream.Send(&rpc.UploadRequest{Data: &rpc.UploadRequest_Header{Header: &rpc.UploadHeader{
+		FileName:    fileName,
+		FileSize:    size,
+		Overwrite:   overwrite,
+		ContentType: contentType(fileName),
+	}}})
+
+	buf := make([]byte, grpcChunkSize)
+	for err == nil {
+		var n int
+		n, err = io.ReadFull(r, buf)
+		if n > 0 {
+			if sendErr := stream.Send(&rpc.UploadRequest{Data: &rpc.UploadRequest_Chunk{Chunk: buf[:n]}}); sendErr != nil {
+				err = sendErr
+			}
+		}
+	}
+	if err != io.EOF && err != io.ErrUnexpectedEOF {
+		// the server ended the stream, its status is returned by CloseAndRecv
+		if _, recvErr := stream.CloseAndRecv(); recvErr != nil {
+			return conf.grpcError(recvErr)
+		}
+		return err
+	}
+
+	_, err = stream.CloseAndRecv()
+	return conf.grpcError(err)
+}
+
+// DownloadFile downloads a file and writes its content to w
+func (conf *GRPCClientConfig) DownloadFile(fileName string, w io.Writer) error {
+	stream, err := conf.Client.Download(context.Background(), &rpc.DownloadRequest{FileName: fileName})
+	if err != nil {
+		return conf.grpcError(err)
+	}
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return conf.grpcError(err)
+		}
+		if _, err := w.Write(resp.GetChunk()); err != nil {
+			return err
+		}
+	}
+}
+
+// StatFile returns the information of a file
+func (conf *GRPCClientConfig) StatFile(fileName string) (*FileResponse, error) {
+	info, err := conf.Client.Stat(context.Background(), &rpc.StatRequest{FileName: fileName})
+	if err != nil {
+		return nil, conf.grpcError(err)
+	}
+	file := fileResponse(info)
+	return &file, nil
+}
+
+// DeleteFile deletes a file
+func (conf *GRPCClientConfig) DeleteFile(fileName string) error {
+	_, err := conf.Client.Delete(context.Background(), &rpc.DeleteRequest{FileName: fileName})
+	return conf.grpcError(err)
+}
+
+// DeleteDirectory deletes every file in a virtual directory and returns the deleted names
+func (conf *GRPCClientConfig) DeleteDirectory(dir string) ([]string, error) {
+	resp, err := conf.Client.Delete(context.Background(), &rpc.DeleteRequest{Prefix: dir})
+	if err != nil {
+		return nil, conf.grpcError(err)
+	}
+	return resp.Deleted, nil
+}
+
+// ListAllFiles lists all files matching the options, the limit is ignored as
+// the server streams every file
+func (conf *GRPCClientConfig) ListAllFiles(opts ListOptions) ([]FileResponse, error) {
+	_, files, err := conf.list(opts)
+	return files, err
+}
+
+// ListDirectory lists the files and subdirectories of a virtual directory,
+// subdirectories are returned as prefixes ending with /
+func (conf *GRPCClientConfig) ListDirectory(dir string) ([]string, []FileResponse, error) {
+	return conf.list(ListOptions{Prefix: dir, Delimiter: "/"})
+}
+
+// list receives the common prefixes and files of the list stream
+func (conf *GRPCClientConfig) list(opts ListOptions) ([]string, []FileResponse, error) {
+	stream, err := conf.Client.List(context.Background(), &rpc.ListRequest{
+		Prefix:    opts.Prefix,
+		Sort:      opts.Sort,
+		Order:     opts.Order,
+		Delimiter: opts.Delimiter,
+	})
+	if err != nil {
+		return nil, nil, conf.grpcError(err)
+	}
+
+	prefixes := make([]string, 0)
+	files := make([]FileResponse, 0)
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			return prefixes, files, nil
+		} else if err != nil {
+			return nil, nil, conf.grpcError(err)
+		}
+		if file := resp.GetFile(); file != nil {
+			files = append(files, fileResponse(file))
+		} else {
+			prefixes = append(prefixes, resp.GetCommonPrefix())
+		}
+	}
+}
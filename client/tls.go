@@ -0,0 +1,83 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+
+	"fs-store/certs"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// TLSOptions are the options for connecting to a server over TLS, the
+// files are reloaded when they change
+type TLSOptions struct {
+	// CAFile verifies the server with the CAs instead of the system CAs if set
+	CAFile string
+
+	// CertFile and KeyFile are the client certificate for servers requiring one
+	CertFile string
+	KeyFile  string
+
+	// Insecure skips verifying the server certificate
+	Insecure bool
+}
+
+// Config creates the tls config for the options
+func (opts TLSOptions) Config() (*tls.Config, error) {
+	reloader, err := certs.NewReloader(opts.CertFile, opts.KeyFile, opts.CAFile)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: opts.Insecure,
+	}
+	if opts.CertFile != "" {
+		config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return reloader.Certificate(), nil
+		}
+	}
+	if opts.CAFile != "" && !opts.Insecure {
+		// the server is verified in VerifyConnection so the reloaded CAs are used
+		config.InsecureSkipVerify = true
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			if len(state.PeerCertificates) == 0 {
+				return errors.New("server sent no certificate")
+			}
+			verifyOpts := x509.VerifyOptions{
+				Roots:         reloader.CertPool(),
+				DNSName:       state.ServerName,
+				Intermediates: x509.NewCertPool(),
+			}
+			for _, cert := range state.PeerCertificates[1:] {
+				verifyOpts.Intermediates.AddCert(cert)
+			}
+			_, err := state.PeerCertificates[0].Verify(verifyOpts)
+			return err
+		}
+	}
+	return config, nil
+}
+
+// SetTLS sets the options for connecting to a https server
+func (conf *FSClientConfig) SetTLS(opts TLSOptions) error {
+	config, err := opts.Config()
+	if err != nil {
+		return err
+	}
+	conf.Client.SetTLSClientConfig(config)
+	return nil
+}
+
+// WithTLS is the dial option for connecting to a gRPC server over TLS
+func WithTLS(opts TLSOptions) (grpc.DialOption, error) {
+	config, err := opts.Config()
+	if err != nil {
+		return nil, err
+	}
+	return grpc.WithTransportCredentials(credentials.NewTLS(config)), nil
+}
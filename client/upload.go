@@ -0,0 +1,139 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	. "fs-store/types"
+	"io"
+	"strconv"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// CreateUploadSession creates an upload session for uploading a file in chunks
+func (conf *FSClientConfig) CreateUploadSession(fileName string, size, chunkSize int64, overwrite bool) (*UploadSessionResponse, error) {
+	session := &UploadSessionResponse{}
+	resp, err := conf.Client.R().
+		SetBody(UploadSessionRequest{
+			FileName:  fileName,
+			FileSize:  size,
+			ChunkSize: chunkSize,
+			Overwrite: overwrite,
+
+			ContentType: contentType(fileName),
+		}).
+		SetResult(session).
+		Post("/uploads")
+
+	if err != nil {
+		return nil, err
+	} else if resp.IsError() {
+		return nil, responseError(resp)
+	}
+	return session, nil
+}
+
+// GetUploadSession returns an upload session including the received ranges
+func (conf *FSClientConfig) GetUploadSession(uploadID string) (*UploadSessionResponse, error) {
+	session := &UploadSessionResponse{}
+	resp, err := conf.Client.R().
+		SetPathParam("id", uploadID).
+		SetResult(session).
+		Get("/uploads/{id}")
+
+	if err != nil {
+		return nil, err
+	} else if resp.IsError() {
+		return nil, responseError(resp)
+	}
+	return session, nil
+}
+
+// UploadChunk uploads a numbered chunk of size bytes for an upload session
+func (conf *FSClientConfig) UploadChunk(uploadID string, number int64, r io.Reader, size int64) (*UploadSessionResponse, error) {
+	session := &UploadSessionResponse{}
+	resp, err := conf.Client.R().
+		SetPathParam("id", uploadID).
+		SetPathParam("number", strconv.FormatInt(number, 10)).
+		SetHeader("Content-Type", "application/octet-stream").
+		SetHeader("Content-Length", strconv.FormatInt(size, 10)).
+		SetBody(r).
+		SetResult(session).
+		Put("/uploads/{id}/chunks/{number}")
+
+	if err != nil {
+		return nil, err
+	} else if resp.IsError() {
+		return nil, responseError(resp)
+	}
+	return session, nil
+}
+
+// CommitUploadSession creates the file on the server from a complete upload session
+func (conf *FSClientConfig) CommitUploadSession(uploadID string) error {
+	resp, err := conf.Client.R().
+		SetPathParam("id", uploadID).
+		Post("/uploads/{id}/commit")
+
+	if err != nil {
+		return err
+	} else if resp.IsError() {
+		return responseError(resp)
+	}
+	return nil
+}
+
+// AbortUploadSession removes an upload session and the chunks uploaded so far
+func (conf *FSClientConfig) AbortUploadSession(uploadID string) error {
+	resp, err := conf.Client.R().
+		SetPathParam("id", uploadID).
+		Delete("/uploads/{id}")
+
+	if err != nil {
+		return err
+	} else if resp.IsError() {
+		return responseError(resp)
+	}
+	return nil
+}
+
+// UploadMissingChunks uploads the chunks of a session that the server hasn't received yet
+func (conf *FSClientConfig) UploadMissingChunks(session *UploadSessionResponse, r io.ReaderAt) error {
+	for offset := int64(0); offset < session.FileSize; offset += session.ChunkSize {
+		end := offset + session.ChunkSize
+		if end > session.FileSize {
+			end = session.FileSize
+		}
+		if rangeReceived(session.Received, offset, end) {
+			continue
+		}
+
+		number := offset / session.ChunkSize
+		_, err := conf.UploadChunk(session.UploadID, number,
+			io.NewSectionReader(r, offset, end-offset), end-offset)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rangeReceived checks whether the bytes from start to end are in the received ranges
+func rangeReceived(received []ByteRange, start, end int64) bool {
+	for _, r := range received {
+		if r.Start <= start && end <= r.End {
+			return true
+		}
+	}
+	return false
+}
+
+// responseError returns the error message of an error response
+func responseError(resp *resty.Response) error {
+	genResponse := &GenericResponse{}
+	err := json.Unmarshal(resp.Body(), genResponse)
+	if err != nil || genResponse.Message == "" {
+		return errors.New("unknown error")
+	}
+	return errors.New(genResponse.Message)
+}
@@ -0,0 +1,209 @@
+package cmd
+
+import (
+	"fmt"
+	"fs-store/server"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// adminCmd groups the commands for administering a server
+var adminCmd = &cobra.Command{
+	Use:   "admin",
+	Short: "administers the FS-Store server",
+}
+
+// adminKeyCmd groups the commands for managing API keys
+var adminKeyCmd = &cobra.Command{
+	Use:   "key",
+	Short: "manages the API keys in a keys file, restart the server to apply changes",
+}
+
+// adminKeyAddCmd represents the admin key add command
+var adminKeyAddCmd = &cobra.Command{
+	Use:   "add [name]",
+	Short: "adds an API key and prints its token, the token can't be shown again",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		keysFile := cmd.Flag("keys-file").Value.String()
+		scopeNames, err := cmd.Flags().GetStringSlice("scope")
+		if err != nil {
+			return err
+		}
+		prefixes, err := cmd.Flags().GetStringSlice("prefix")
+		if err != nil {
+			return err
+		}
+
+		scopes := make([]server.Scope, 0, len(scopeNames))
+		for _, scope := range scopeNames {
+			scopes = append(scopes, server.Scope(scope))
+		}
+		token, key, err := server.GenerateAPIKey(args[0], scopes, prefixes)
+		if err != nil {
+			return err
+		}
+		if err := server.AddAPIKey(keysFile, key); err != nil {
+			return err
+		}
+		fmt.Println(token)
+		return nil
+	},
+}
+
+// adminKeyListCmd represents the admin key list command
+var adminKeyListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "lists the API keys",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		keys, err := server.ReadAPIKeys(cmd.Flag("keys-file").Value.String())
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			scopes := make([]string, 0, len(key.Scopes))
+			for _, scope := range key.Scopes {
+				scopes = append(scopes, string(scope))
+			}
+			prefixes := "*"
+			if len(key.Prefixes) > 0 {
+				prefixes = strings.Join(key.Prefixes, ",")
+			}
+			fmt.Printf("%s\t%s\t%s\t%s\n", key.Name, strings.Join(scopes, ","), prefixes,
+				key.CreatedAt.Format("2006-01-02 15:04:05"))
+		}
+		return nil
+	},
+}
+
+// adminKeyRemoveCmd represents the admin key remove command
+var adminKeyRemoveCmd = &cobra.Command{
+	Use:   "remove [name]",
+	Short: "removes an API key",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return server.RemoveAPIKey(cmd.Flag("keys-file").Value.String(), args[0])
+	},
+}
+
+// adminACLCmd groups the commands for inspecting an ACL policy
+var adminACLCmd = &cobra.Command{
+	Use:   "acl",
+	Short: "inspects the effective permissions of an ACL policy file",
+}
+
+// findAPIKey returns the key with the name from the keys file, nil if there
+// is no keys file or key
+func findAPIKey(keysFile, name string) (*server.APIKey, error) {
+	if keysFile == "" {
+		return nil, nil
+	}
+	keys, err := server.ReadAPIKeys(keysFile)
+	if err != nil {
+		return nil, err
+	}
+	for i := range keys {
+		if keys[i].Name == name {
+			return &keys[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", server.ErrAPIKeyNotFound, name)
+}
+
+// adminACLShowCmd represents the admin acl show command
+var adminACLShowCmd = &cobra.Command{
+	Use:   "show [user]",
+	Short: "shows the groups and the actions by prefix the policy grants a user",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		policy, err := server.LoadACLPolicy(cmd.Flag("acl-file").Value.String())
+		if err != nil {
+			return err
+		}
+		key, err := findAPIKey(cmd.Flag("keys-file").Value.String(), args[0])
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("user: %s\n", args[0])
+		fmt.Printf("groups: %s\n", strings.Join(policy.GroupsOf(args[0]), ","))
+		if key != nil {
+			scopes := make([]string, 0, len(key.Scopes))
+			for _, scope := range key.Scopes {
+				scopes = append(scopes, string(scope))
+				if scope == server.ScopeAdmin {
+					fmt.Println("the key has the admin scope and bypasses the policy")
+				}
+			}
+			prefixes := "*"
+			if len(key.Prefixes) > 0 {
+				prefixes = strings.Join(key.Prefixes, ",")
+			}
+			fmt.Printf("key scopes: %s\tkey prefixes: %s\n", strings.Join(scopes, ","), prefixes)
+		}
+		for _, permission := range policy.Effective(args[0]) {
+			actions := make([]string, 0, len(permission.Actions))
+			for _, action := range permission.Actions {
+				actions = append(actions, string(action))
+			}
+			prefix := permission.Prefix
+			if prefix == "" {
+				prefix = "*"
+			}
+			fmt.Printf("%s\t%s\n", prefix, strings.Join(actions, ","))
+		}
+		return nil
+	},
+}
+
+// adminACLCheckCmd represents the admin acl check command
+var adminACLCheckCmd = &cobra.Command{
+	Use:   "check [user] [read|write|delete] [name]",
+	Short: "checks if a user may act on a file name, also by the scopes and prefixes of its key with --keys-file",
+	Args:  cobra.ExactArgs(3),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		policy, err := server.LoadACLPolicy(cmd.Flag("acl-file").Value.String())
+		if err != nil {
+			return err
+		}
+		key, err := findAPIKey(cmd.Flag("keys-file").Value.String(), args[0])
+		if err != nil {
+			return err
+		}
+
+		user, action, name := args[0], server.Scope(args[1]), args[2]
+		allowed := policy.Allows(user, action, name)
+		if key != nil {
+			allowed = policy.KeyAllows(key, action, name)
+		}
+		if !allowed {
+			return fmt.Errorf("denied: %s can't %s %s", user, action, name)
+		}
+		fmt.Printf("allowed: %s can %s %s\n", user, action, name)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(adminCmd)
+	adminCmd.AddCommand(adminKeyCmd)
+	adminKeyCmd.AddCommand(adminKeyAddCmd, adminKeyListCmd, adminKeyRemoveCmd)
+	adminCmd.AddCommand(adminACLCmd)
+	adminACLCmd.AddCommand(adminACLShowCmd, adminACLCheckCmd)
+
+	// Keys File
+	adminKeyCmd.PersistentFlags().StringP("keys-file", "k", "./keys.json", "keys file of the server")
+
+	// Scopes
+	adminKeyAddCmd.Flags().StringSliceP("scope", "s", []string{"read"}, "scopes of the key: read, write, delete or admin")
+
+	// Name Prefixes
+	adminKeyAddCmd.Flags().StringSliceP("prefix", "p", nil, "restricts the key to file names with the prefixes")
+
+	// ACL Policy File
+	adminACLCmd.PersistentFlags().StringP("acl-file", "a", "./acl.json", "ACL policy file of the server")
+
+	// Keys File of the ACL commands
+	adminACLCmd.PersistentFlags().StringP("keys-file", "k", "", "keys file of the server, to include the scopes and prefixes of the user's key")
+}
@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"fs-store/server"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// auditCmd groups the commands for reading the audit log
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "verifies and queries the audit log of a server",
+}
+
+// auditVerifyCmd represents the audit verify command
+var auditVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "verifies the hash chain of the audit log including its rotated files",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		count, err := server.VerifyAuditLog(cmd.Flag("audit-log").Value.String())
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Verified: %d entries\n", count)
+		return nil
+	},
+}
+
+// parseAuditTime parses a time as RFC 3339 or as a duration before now
+func parseAuditTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %s, must be RFC 3339 or a duration", value)
+	}
+	return t, nil
+}
+
+// auditQueryCmd represents the audit query command
+var auditQueryCmd = &cobra.Command{
+	Use:   "query",
+	Short: "prints the entries of the audit log matching the filters",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		since, err := parseAuditTime(cmd.Flag("since").Value.String())
+		if err != nil {
+			return err
+		}
+		until, err := parseAuditTime(cmd.Flag("until").Value.String())
+		if err != nil {
+			return err
+		}
+		jsonOutput, err := cmd.Flags().GetBool("json")
+		if err != nil {
+			return err
+		}
+
+		entries, err := server.QueryAuditLog(cmd.Flag("audit-log").Value.String(), server.AuditQuery{
+			Identity: cmd.Flag("identity").Value.String(),
+			Action:   server.AuditAction(cmd.Flag("action").Value.String()),
+			Prefix:   cmd.Flag("prefix").Value.String(),
+			Since:    since,
+			Until:    until,
+		})
+		if err != nil {
+			return err
+		}
+
+		if jsonOutput {
+			encoder := json.NewEncoder(os.Stdout)
+			for _, entry := range entries {
+				if err := encoder.Encode(entry); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		for _, entry := range entries {
+			fmt.Printf("%s\t%s\t%s\t%s\t%d\t%s\n", entry.Time.Format(time.RFC3339), entry.Identity,
+				entry.Action, entry.FileName, entry.Size, entry.Result)
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(auditCmd)
+	auditCmd.AddCommand(auditVerifyCmd, auditQueryCmd)
+
+	// Audit Log
+	auditCmd.PersistentFlags().StringP("audit-log", "a", "./audit.log", "audit log of the server")
+
+	// Filters
+	auditQueryCmd.Flags().String("identity", "", "only entries of the identity")
+	auditQueryCmd.Flags().String("action", "", "only entries of the action: upload, overwrite or delete")
+	auditQueryCmd.Flags().String("prefix", "", "only entries of file names with the prefix")
+	auditQueryCmd.Flags().String("since", "", "only entries at or after the time, RFC 3339 or a duration such as 24h")
+	auditQueryCmd.Flags().String("until", "", "only entries before the time, RFC 3339 or a duration such as 1h")
+
+	// Json
+	auditQueryCmd.Flags().Bool("json", false, "print the entries as json lines")
+}
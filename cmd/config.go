@@ -0,0 +1,169 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix is the prefix of the environment variables of the server settings
+const envPrefix = "FS_STORE_"
+
+// envListSeparator separates the values of repeatable settings in an environment variable
+const envListSeparator = ";"
+
+// settingSources records where the settings which aren't defaults came from
+type settingSources map[string]string
+
+// envName returns the environment variable of a setting, e.g. FS_STORE_MAX_MB for max-mb
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// isListFlag checks if a flag can be repeated
+func isListFlag(f *pflag.Flag) bool {
+	return strings.HasSuffix(f.Value.Type(), "Array") || strings.HasSuffix(f.Value.Type(), "Slice")
+}
+
+// applySettings sets the flags which weren't given on the command line from
+// their FS_STORE_* environment variable or else from the config file, the
+// config file is set by --config or FS_STORE_CONFIG
+func applySettings(flags *pflag.FlagSet) (settingSources, error) {
+	sources := settingSources{}
+	flags.Visit(func(f *pflag.Flag) {
+		sources[f.Name] = "flag --" + f.Name
+	})
+
+	configFile := flags.Lookup("config").Value.String()
+	if value, ok := os.LookupEnv(envName("config")); ok && !flags.Changed("config") {
+		configFile = value
+	}
+	settings, err := readConfigFile(flags, configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	flags.VisitAll(func(f *pflag.Flag) {
+		if err != nil || f.Changed || f.Name == "config" || f.Name == "help" {
+			return
+		}
+		var values []string
+		if value, ok := os.LookupEnv(envName(f.Name)); ok {
+			sources[f.Name] = envName(f.Name)
+			values = []string{value}
+			if isListFlag(f) {
+				values = nil
+				for _, v := range strings.Split(value, envListSeparator) {
+					if v != "" {
+						values = append(values, v)
+					}
+				}
+			}
+		} else if value, ok := settings[f.Name]; ok {
+			sources[f.Name] = "config file " + configFile
+			if values, err = settingValues(value); err != nil {
+				err = fmt.Errorf("invalid %s from %s: %w", f.Name, sources[f.Name], err)
+				return
+			}
+			if len(values) > 1 && !isListFlag(f) {
+				err = fmt.Errorf("invalid %s from %s: only a single value is allowed", f.Name, sources[f.Name])
+				return
+			}
+		} else {
+			return
+		}
+
+		for _, value := range values {
+			if setErr := flags.Set(f.Name, value); setErr != nil {
+				err = invalidSetting(sources, f.Name, value, setErr.Error())
+				return
+			}
+		}
+	})
+	return sources, err
+}
+
+// readConfigFile reads the settings of a YAML config file, the keys are the
+// flag names and repeatable flags take lists
+func readConfigFile(flags *pflag.FlagSet, configFile string) (map[string]interface{}, error) {
+	settings := map[string]interface{}{}
+	if configFile == "" {
+		return settings, nil
+	}
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file: %w", err)
+	}
+	if err := yaml.Unmarshal(data, &settings); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", configFile, err)
+	}
+	for name := range settings {
+		if name == "config" || name == "help" || flags.Lookup(name) == nil {
+			return nil, fmt.Errorf("unknown setting %q in config file %s", name, configFile)
+		}
+	}
+	return settings, nil
+}
+
+// settingValues returns the values of a setting from the config file
+func settingValues(value interface{}) ([]string, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, fmt.Errorf("missing value")
+	case map[string]interface{}:
+		return nil, fmt.Errorf("must be a value or a list of values")
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			switch item.(type) {
+			case nil, map[string]interface{}, []interface{}:
+				return nil, fmt.Errorf("lists may only contain values")
+			}
+			values = append(values, fmt.Sprint(item))
+		}
+		return values, nil
+	default:
+		return []string{fmt.Sprint(v)}, nil
+	}
+}
+
+// invalidSetting returns the error of an invalid setting with where its value came from
+func invalidSetting(sources settingSources, name, value, reason string) error {
+	source, ok := sources[name]
+	if !ok {
+		source = "default"
+	}
+	return fmt.Errorf("invalid %s %q from %s: %s", name, value, source, reason)
+}
+
+// validateServerSettings checks the ranges of the server settings
+func validateServerSettings(flags *pflag.FlagSet, sources settingSources) error {
+	level := flags.Lookup("log-level").Value.String()
+	if _, err := logrus.ParseLevel(level); err != nil {
+		return invalidSetting(sources, "log-level", level, "must be one of panic, fatal, error, warn, info, debug or trace")
+	}
+	if port, _ := flags.GetInt("port"); port < 1 || port > 65535 {
+		return invalidSetting(sources, "port", fmt.Sprint(port), "must be between 1 and 65535")
+	}
+	if maxMB, _ := flags.GetInt64("max-mb"); maxMB <= 0 {
+		return invalidSetting(sources, "max-mb", fmt.Sprint(maxMB), "must be greater than 0")
+	}
+	if maxListSize, _ := flags.GetInt("max-list-size"); maxListSize <= 0 {
+		return invalidSetting(sources, "max-list-size", fmt.Sprint(maxListSize), "must be greater than 0")
+	}
+	for _, name := range []string{"min-free-mb", "audit-max-mb"} {
+		if value, _ := flags.GetInt64(name); value < 0 {
+			return invalidSetting(sources, name, fmt.Sprint(value), "can't be negative")
+		}
+	}
+	for _, name := range []string{"drain-timeout", "read-timeout", "write-timeout", "idle-timeout", "upload-ttl"} {
+		if value, _ := flags.GetDuration(name); value < 0 {
+			return invalidSetting(sources, name, value.String(), "can't be negative")
+		}
+	}
+	return nil
+}
@@ -3,7 +3,7 @@ package cmd
 import (
 	"errors"
 	"fmt"
-	"fs-store/client"
+	"strings"
 
 	"github.com/spf13/cobra"
 )
@@ -12,6 +12,8 @@ import (
 var deleteFileCmd = &cobra.Command{
 	Use:   "delete [file] [?file2] ...",
 	Short: "delete a file from the server",
+	Long: "delete a file from the server, a directory ending with / and every file in it " +
+		"is deleted with --recursive",
 	Args: func(cmd *cobra.Command, paths []string) error {
 		// Find duplicate strings in paths
 		var seen = make(map[string]struct{})
@@ -27,18 +29,32 @@ var deleteFileCmd = &cobra.Command{
 	},
 	RunE: func(cmd *cobra.Command, paths []string) error {
 		// Configure the client
-		serverUrl := cmd.Flag("url").Value.String()
-		verbose, err := cmd.Flags().GetBool("verbose")
+		client, err := newClient(cmd)
 		if err != nil {
 			return err
 		}
-		client, err := client.NewFSClientConfig(serverUrl, verbose)
+
+		recursive, err := cmd.Flags().GetBool("recursive")
 		if err != nil {
 			return err
 		}
 
-		// Upload the files specified in the paths (args)
+		// Delete the files specified in the paths (args)
 		for _, path := range paths {
+			if strings.HasSuffix(path, "/") {
+				if !recursive {
+					return errors.New("'" + path + "' is a directory, use --recursive to delete it")
+				}
+				fmt.Println("Deleting directory: '" + path + "' from " + client.Client.BaseURL)
+
+				deleted, err := client.DeleteDirectory(path)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("Deleted %d files\n", len(deleted))
+				continue
+			}
+
 			fmt.Println("Deleting file: '" + path + "' from " + client.Client.BaseURL)
 
 			if err := client.DeleteFile(path); err != nil {
@@ -52,4 +68,7 @@ var deleteFileCmd = &cobra.Command{
 func init() {
 	rootCmd.AddCommand(deleteFileCmd)
 	setupCommonClientFlags(deleteFileCmd)
+
+	// Recursive
+	deleteFileCmd.Flags().BoolP("recursive", "r", false, "delete directories ending with / and every file in them")
 }
@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// downloadFileCmd represents the downloadFile command
+var downloadFileCmd = &cobra.Command{
+	Use:   "download [file]",
+	Short: "download a file from the server to a local path or stdout",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Configure the client
+		client, err := newClient(cmd)
+		if err != nil {
+			return err
+		}
+
+		fileName := args[0]
+		output := cmd.Flag("output").Value.String()
+
+		// Write to stdout when no output path is specified
+		if output == "" || output == "-" {
+			return client.DownloadFile(fileName, os.Stdout)
+		}
+
+		fmt.Println("Downloading file: '" + fileName + "' from " + client.Client.BaseURL)
+		file, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+
+		if err := client.DownloadFile(fileName, file); err != nil {
+			file.Close()
+			os.Remove(output)
+			return err
+		}
+		return file.Close()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(downloadFileCmd)
+	setupCommonClientFlags(downloadFileCmd)
+
+	// Output
+	downloadFileCmd.Flags().StringP("output", "o", "", "local path to write the file to (default stdout)")
+}
@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"fs-store/server"
+	. "fs-store/types"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// fsckCmd represents the fsck command
+var fsckCmd = &cobra.Command{
+	Use:   "fsck",
+	Short: "checks the integrity of the stored files",
+	Long: "checks the integrity of the stored files, the data directory is checked " +
+		"directly unless --url is set, in which case the running server checks its files",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		quarantine, err := cmd.Flags().GetBool("quarantine")
+		if err != nil {
+			return err
+		}
+		jsonOutput, err := cmd.Flags().GetBool("json")
+		if err != nil {
+			return err
+		}
+
+		var report *FsckReport
+		if cmd.Flags().Changed("url") {
+			client, err := newClient(cmd)
+			if err != nil {
+				return err
+			}
+			report, err = client.Fsck(quarantine)
+			if err != nil {
+				return err
+			}
+		} else {
+			dataDir := cmd.Flag("data-dir").Value.String()
+			report, err = server.Fsck(dataDir, server.FsckOptions{Quarantine: quarantine})
+			if err != nil {
+				return err
+			}
+		}
+
+		if jsonOutput {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(report); err != nil {
+				return err
+			}
+		} else {
+			for _, issue := range report.Issues {
+				status := ""
+				if issue.Quarantined {
+					status = " (quarantined to " + issue.QuarantinePath + ")"
+				}
+				fmt.Printf("%s '%s': %s%s\n", issue.Path, issue.FileName, issue.Problem, status)
+			}
+			fmt.Printf("Checked: %d, Problems: %d\n", report.Checked, len(report.Issues))
+		}
+
+		if len(report.Issues) > 0 {
+			return fmt.Errorf("found %d files with problems", len(report.Issues))
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(fsckCmd)
+	setupCommonClientFlags(fsckCmd)
+
+	// Data Directory
+	fsckCmd.Flags().StringP("data-dir", "d", "./", "data directory to check when not using --url")
+
+	// Quarantine
+	fsckCmd.Flags().Bool("quarantine", false, "move bad files into the lost+found directory with a json report")
+
+	// Json
+	fsckCmd.Flags().Bool("json", false, "print the report as json")
+}
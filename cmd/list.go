@@ -6,25 +6,58 @@ import (
 	"fs-store/client"
 	"strings"
 
+	. "fs-store/types"
+
 	"github.com/spf13/cobra"
 )
 
 // listFilesCmd represents the listFile command
 var listFilesCmd = &cobra.Command{
-	Use:   "list",
+	Use:   "list [?directory/]",
 	Short: "list files on the server",
+	Long: "list files on the server, names containing / are shown as directories when a " +
+		"directory is given, use --recursive to list every file in it",
+	Args: cobra.MaximumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		serverUrl := cmd.Flag("url").Value.String()
-		verbose, err := cmd.Flags().GetBool("verbose")
+		pageSize, err := cmd.Flags().GetInt("page-size")
 		if err != nil {
 			return err
 		}
-		client, err := client.NewFSClientConfig(serverUrl, verbose)
+		opts := client.ListOptions{
+			Limit:  pageSize,
+			Prefix: cmd.Flag("prefix").Value.String(),
+			Sort:   cmd.Flag("sort").Value.String(),
+			Order:  cmd.Flag("order").Value.String(),
+		}
+		recursive, err := cmd.Flags().GetBool("recursive")
 		if err != nil {
 			return err
 		}
 
-		files, err := client.ListFiles()
+		// a directory is listed like a path, files in subdirectories are grouped
+		if len(args) == 1 {
+			opts.Prefix = args[0]
+			if opts.Prefix != "" && !strings.HasSuffix(opts.Prefix, "/") {
+				opts.Prefix += "/"
+			}
+			if !recursive {
+				opts.Delimiter = "/"
+				opts.Sort = "name"
+			}
+		}
+
+		client, err := newClient(cmd)
+		if err != nil {
+			return err
+		}
+
+		var dirs []string
+		var files []FileResponse
+		if opts.Delimiter != "" {
+			dirs, files, err = client.ListDirectory(opts.Prefix)
+		} else {
+			files, err = client.ListAllFiles(opts)
+		}
 		if err != nil {
 			return err
 		} else if files == nil {
@@ -33,9 +66,9 @@ var listFilesCmd = &cobra.Command{
 
 		// Print the files
 		fmt.Print("Listing Files: ")
-		if len(files) != 0 {
-			// write file names
-			var fileNames []string
+		if len(dirs)+len(files) != 0 {
+			// write directory and file names
+			fileNames := dirs
 			for _, file := range files {
 				fileNames = append(fileNames, file.FileName)
 			}
@@ -50,4 +83,11 @@ var listFilesCmd = &cobra.Command{
 func init() {
 	rootCmd.AddCommand(listFilesCmd)
 	setupCommonClientFlags(listFilesCmd)
+
+	// Filtering and Sorting
+	listFilesCmd.Flags().BoolP("recursive", "r", false, "list every file in the directory instead of its subdirectories")
+	listFilesCmd.Flags().String("prefix", "", "only list files starting with the prefix")
+	listFilesCmd.Flags().String("sort", "name", "sort by name, size or createdAt")
+	listFilesCmd.Flags().String("order", "asc", "sort order, asc or desc")
+	listFilesCmd.Flags().Int("page-size", 0, "number of files requested per page (default server limit)")
 }
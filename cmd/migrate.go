@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"fmt"
+	"fs-store/server"
+
+	"github.com/spf13/cobra"
+)
+
+// migrateCmd represents the migrate command
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "rewrites the files of a data directory in another format version or layout",
+	Long: "rewrites the files of a data directory in another format version, or moves them " +
+		"into another layout with --layout, the server must be stopped while migrating, an " +
+		"interrupted migration can be run again",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dataDir := cmd.Flag("data-dir").Value.String()
+		dryRun, err := cmd.Flags().GetBool("dry-run")
+		if err != nil {
+			return err
+		}
+		targetVersion, err := cmd.Flags().GetUint8("to")
+		if err != nil {
+			return err
+		}
+		if !server.FSVersion(targetVersion).Supported() {
+			return fmt.Errorf("unsupported format version: %d", targetVersion)
+		}
+
+		if dryRun {
+			fmt.Println("Dry run, no files will be changed")
+		}
+
+		if layout := cmd.Flag("layout").Value.String(); layout != "" {
+			if !server.Layout(layout).Supported() {
+				return fmt.Errorf("unsupported layout: %s", layout)
+			}
+			result, err := server.MigrateLayout(dataDir, server.Layout(layout), server.MigrateOptions{
+				DryRun: dryRun,
+				Progress: func(p server.MigrateProgress) {
+					switch p.Status {
+					case server.MigrateStatusFailed:
+						fmt.Printf("[%d/%d] failed %s: %s\n", p.Done, p.Total, p.Path, p.Err)
+					case server.MigrateStatusMigrated:
+						fmt.Printf("[%d/%d] moved '%s'\n", p.Done, p.Total, p.FileName)
+					default:
+						fmt.Printf("[%d/%d] skipped '%s'\n", p.Done, p.Total, p.FileName)
+					}
+				},
+			})
+			if result != nil {
+				fmt.Printf("Moved: %d, Skipped: %d, Failed: %d\n",
+					result.Migrated, result.Skipped, result.Failed)
+			}
+			// the format version is only migrated when requested together with the layout
+			if err != nil || !cmd.Flags().Changed("to") {
+				return err
+			}
+		}
+
+		result, err := server.Migrate(dataDir, server.FSVersion(targetVersion), server.MigrateOptions{
+			DryRun: dryRun,
+			Progress: func(p server.MigrateProgress) {
+				switch p.Status {
+				case server.MigrateStatusFailed:
+					fmt.Printf("[%d/%d] failed %s: %s\n", p.Done, p.Total, p.Path, p.Err)
+				case server.MigrateStatusMigrated:
+					fmt.Printf("[%d/%d] migrated '%s' (v%d -> v%d)\n", p.Done, p.Total,
+						p.FileName, p.FromVersion, targetVersion)
+				default:
+					fmt.Printf("[%d/%d] skipped '%s'\n", p.Done, p.Total, p.FileName)
+				}
+			},
+		})
+		if result != nil {
+			fmt.Printf("Migrated: %d, Skipped: %d, Failed: %d\n",
+				result.Migrated, result.Skipped, result.Failed)
+		}
+		return err
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(migrateCmd)
+
+	// Data Directory
+	migrateCmd.Flags().StringP("data-dir", "d", "./", "data directory to migrate")
+
+	// Target Version
+	migrateCmd.Flags().Uint8("to", uint8(server.DefaultVersion), "format version to migrate to")
+
+	// Dry Run
+	migrateCmd.Flags().Bool("dry-run", false, "report files to migrate without changing them")
+
+	// Target Layout
+	migrateCmd.Flags().String("layout", "", "layout to move the files to, flat or sharded")
+}
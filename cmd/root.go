@@ -1,6 +1,8 @@
 package cmd
 
 import (
+	"fs-store/client"
+	"fs-store/server"
 	"os"
 
 	"github.com/spf13/cobra"
@@ -13,7 +15,14 @@ var rootCmd = &cobra.Command{
 	SilenceUsage: true,
 }
 
-func Execute() {
+// buildInfo is the version and revision of the binary
+var buildInfo server.BuildInfo
+
+// Execute runs the command of the arguments with the build version and revision
+func Execute(version, revision string) {
+	buildInfo = server.BuildInfo{Version: version, Revision: revision}
+	rootCmd.Version = version + " (" + revision + ")"
+
 	err := rootCmd.Execute()
 	if err != nil {
 		os.Exit(1)
@@ -27,4 +36,51 @@ func setupCommonClientFlags(cmd *cobra.Command) {
 
 	// Verbose
 	cmd.Flags().BoolP("verbose", "v", false, "verbose output")
+
+	// API Key
+	cmd.Flags().StringP("token", "t", "", "API key sent as a bearer token (default $FS_STORE_TOKEN)")
+
+	// TLS
+	cmd.Flags().String("ca", "", "CA file for verifying an https server instead of the system CAs")
+	cmd.Flags().String("cert", "", "client certificate file for servers requiring client certificates")
+	cmd.Flags().String("key", "", "key file of the client certificate")
+	cmd.Flags().Bool("insecure", false, "skip verifying the certificate of an https server")
+}
+
+// newClient creates a client from the common client flags
+func newClient(cmd *cobra.Command) (*client.FSClientConfig, error) {
+	serverUrl := cmd.Flag("url").Value.String()
+	verbose, err := cmd.Flags().GetBool("verbose")
+	if err != nil {
+		return nil, err
+	}
+	conf, err := client.NewFSClientConfig(serverUrl, verbose)
+	if err != nil {
+		return nil, err
+	}
+
+	token := cmd.Flag("token").Value.String()
+	if token == "" {
+		token = os.Getenv("FS_STORE_TOKEN")
+	}
+	if token != "" {
+		conf.SetToken(token)
+	}
+
+	insecure, err := cmd.Flags().GetBool("insecure")
+	if err != nil {
+		return nil, err
+	}
+	tlsOpts := client.TLSOptions{
+		CAFile:   cmd.Flag("ca").Value.String(),
+		CertFile: cmd.Flag("cert").Value.String(),
+		KeyFile:  cmd.Flag("key").Value.String(),
+		Insecure: insecure,
+	}
+	if tlsOpts != (client.TLSOptions{}) {
+		if err := conf.SetTLS(tlsOpts); err != nil {
+			return nil, err
+		}
+	}
+	return conf, nil
 }
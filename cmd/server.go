@@ -1,10 +1,18 @@
 package cmd
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"fs-store/server"
+	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
@@ -12,7 +20,18 @@ import (
 var startServerCmd = &cobra.Command{
 	Use:   "server",
 	Short: "starts the FS-Store server",
+	Long: "starts the FS-Store server, every flag can also be set by its FS_STORE_* environment variable " +
+		"(e.g. FS_STORE_MAX_MB for --max-mb, repeated values separated by ;) or by its name in the YAML " +
+		"file of --config. Flags take precedence over environment variables, which take precedence over the config file",
 	RunE: func(cmd *cobra.Command, args []string) error {
+		sources, err := applySettings(cmd.Flags())
+		if err != nil {
+			return err
+		}
+		if err := validateServerSettings(cmd.Flags(), sources); err != nil {
+			return err
+		}
+
 		host := cmd.Flag("host").Value.String()
 		port := cmd.Flag("port").Value.String()
 		dataDir := cmd.Flag("data-dir").Value.String()
@@ -23,18 +42,186 @@ var startServerCmd = &cobra.Command{
 		if err != nil {
 			return errors.New("max-mb must be an integer")
 		}
-		server, err := server.NewServerConfig(host+":"+port,
-			dataDir, 1024*1024*maxFileSizeMB, logLevel)
+		formatVersion, err := cmd.Flags().GetUint8("format-version")
 		if err != nil {
 			return err
 		}
-		return server.StartServer()
+		if !server.FSVersion(formatVersion).Supported() {
+			return fmt.Errorf("unsupported format version: %d", formatVersion)
+		}
+
+		var backend server.Backend
+		switch cmd.Flag("backend").Value.String() {
+		case "disk":
+			// the layout is recorded in a new data directory, existing ones are migrated
+			if layout := cmd.Flag("layout").Value.String(); layout != "" {
+				if err := server.InitLayout(dataDir, server.Layout(layout)); err != nil {
+					return err
+				}
+			}
+			if backend, err = server.NewDiskBackend(dataDir); err != nil {
+				return err
+			}
+		case "memory":
+			backend = server.NewMemoryBackend()
+		default:
+			return fmt.Errorf("unknown backend: %s", cmd.Flag("backend").Value.String())
+		}
+
+		sc, err := server.NewServerConfigWithBackend(host+":"+port,
+			dataDir, backend, 1024*1024*maxFileSizeMB, logLevel)
+		if err != nil {
+			return err
+		}
+		sc.Version = server.FSVersion(formatVersion)
+		sc.Build = buildInfo
+
+		if sc.MaxListSize, err = cmd.Flags().GetInt("max-list-size"); err != nil {
+			return err
+		}
+		if sc.ReadTimeout, err = cmd.Flags().GetDuration("read-timeout"); err != nil {
+			return err
+		}
+		if sc.WriteTimeout, err = cmd.Flags().GetDuration("write-timeout"); err != nil {
+			return err
+		}
+		if sc.IdleTimeout, err = cmd.Flags().GetDuration("idle-timeout"); err != nil {
+			return err
+		}
+		if sc.UploadTTL, err = cmd.Flags().GetDuration("upload-ttl"); err != nil {
+			return err
+		}
+
+		minFreeMB, err := cmd.Flags().GetInt64("min-free-mb")
+		if err != nil {
+			return err
+		}
+		sc.MinFreeSpace = 1024 * 1024 * minFreeMB
+
+		sc.GRPCAddress = cmd.Flag("grpc-address").Value.String()
+		sc.MetricsAddress = cmd.Flag("metrics-address").Value.String()
+
+		if keysFile := cmd.Flag("keys-file").Value.String(); keysFile != "" {
+			if sc.APIKeys, err = server.LoadAPIKeys(keysFile); err != nil {
+				return err
+			}
+		}
+
+		if aclFile := cmd.Flag("acl-file").Value.String(); aclFile != "" {
+			if sc.APIKeys == nil {
+				return errors.New("keys-file is required with acl-file")
+			}
+			if sc.ACL, err = server.LoadACLPolicy(aclFile); err != nil {
+				return err
+			}
+		}
+
+		if auditLog := cmd.Flag("audit-log").Value.String(); auditLog != "" {
+			auditMaxMB, err := cmd.Flags().GetInt64("audit-max-mb")
+			if err != nil {
+				return err
+			}
+			if sc.Audit, err = server.OpenAuditLog(auditLog, 1024*1024*auditMaxMB); err != nil {
+				return err
+			}
+			defer sc.Audit.Close()
+		}
+
+		if tlsCert := cmd.Flag("tls-cert").Value.String(); tlsCert != "" {
+			mappings, err := cmd.Flags().GetStringArray("client-identity")
+			if err != nil {
+				return err
+			}
+			identities, err := server.ParseIdentities(mappings)
+			if err != nil {
+				return err
+			}
+			sc.TLS, err = server.NewTLSConfig(tlsCert, cmd.Flag("tls-key").Value.String(),
+				cmd.Flag("client-ca").Value.String(), identities)
+			if err != nil {
+				return err
+			}
+		} else if cmd.Flags().Changed("tls-key") || cmd.Flags().Changed("client-ca") {
+			return errors.New("tls-cert is required with tls-key and client-ca")
+		}
+
+		signSecrets, err := cmd.Flags().GetStringArray("sign-secret")
+		if err != nil {
+			return err
+		}
+		if len(signSecrets) > 0 {
+			if sc.SignSecrets, err = server.NewSignSecrets(signSecrets); err != nil {
+				return err
+			}
+		}
+
+		if webdavPath := cmd.Flag("webdav-path").Value.String(); webdavPath != "" {
+			if !strings.HasPrefix(webdavPath, "/") || webdavPath == "/" {
+				return errors.New("webdav-path must start with / and can't be the root")
+			}
+			sc.WebDAVPath = webdavPath
+		}
+
+		if s3Address := cmd.Flag("s3-address").Value.String(); s3Address != "" {
+			keys, err := cmd.Flags().GetStringArray("s3-key")
+			if err != nil {
+				return err
+			}
+			if len(keys) == 0 {
+				return errors.New("s3-key is required with s3-address")
+			}
+			credentials := map[string]string{}
+			identities := map[string]string{}
+			for _, key := range keys {
+				parts := strings.SplitN(key, ":", 3)
+				if len(parts) < 2 || parts[0] == "" || parts[1] == "" || (len(parts) == 3 && parts[2] == "") {
+					return fmt.Errorf("s3-key must be ACCESS_KEY:SECRET_KEY[:KEY_NAME]: %s", key)
+				}
+				credentials[parts[0]] = parts[1]
+				if len(parts) == 3 {
+					identities[parts[0]] = parts[2]
+				}
+			}
+			sc.S3 = server.NewS3Config(s3Address, cmd.Flag("s3-region").Value.String(), credentials)
+			sc.S3.Identities = identities
+		}
+
+		drainTimeout, err := cmd.Flags().GetDuration("drain-timeout")
+		if err != nil {
+			return err
+		}
+
+		// drain active requests on SIGINT and SIGTERM
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(signals)
+
+		errs := make(chan error, 1)
+		go func() {
+			errs <- sc.StartServer()
+		}()
+		select {
+		case err := <-errs:
+			return err
+		case sig := <-signals:
+			logrus.Infof("Received %s, draining for up to %s", sig, drainTimeout)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+		defer cancel()
+		if err := sc.Shutdown(ctx); err != nil {
+			logrus.Warn("Requests aborted at shutdown: ", err)
+		}
+		return <-errs
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(startServerCmd)
 
+	// Config File
+	startServerCmd.Flags().StringP("config", "c", "", "YAML config file setting flags by their names, e.g. max-mb: 512 (default $FS_STORE_CONFIG)")
+
 	// Log Level
 	startServerCmd.Flags().StringP("log-level", "l", "info", "log level")
 
@@ -50,4 +237,65 @@ func init() {
 	// Max File Size in MB
 	startServerCmd.Flags().Int64P("max-mb", "m", 1024, "max file size in MB")
 
+	// Max List Size
+	startServerCmd.Flags().Int("max-list-size", server.DefaultMaxListSize, "max number of files of a list page")
+
+	// HTTP Timeouts
+	startServerCmd.Flags().Duration("read-timeout", 0, "time for reading a request including its body, no timeout when 0")
+	startServerCmd.Flags().Duration("write-timeout", 0, "time for writing a response after reading the request, no timeout when 0")
+	startServerCmd.Flags().Duration("idle-timeout", 0, "time idle keep-alive connections are kept open, the read timeout when 0")
+
+	// Upload Expiry
+	startServerCmd.Flags().Duration("upload-ttl", server.DefaultUploadTTL, "time upload sessions and S3 multipart uploads are kept without activity, never removed when 0")
+
+	// File Store Format Version
+	startServerCmd.Flags().Uint8("format-version", uint8(server.DefaultVersion), "format version new files are written in")
+
+	// Readiness
+	startServerCmd.Flags().Int64("min-free-mb", 100, "free space in MB the data directory needs for /readyz to report ready")
+
+	// Storage Backend
+	startServerCmd.Flags().String("backend", "disk", "storage backend, disk or memory (files are lost on exit, uploads are staged in the data directory)")
+
+	// Data Directory Layout
+	startServerCmd.Flags().String("layout", "", "layout of a new data directory, flat or sharded (default layout of the data directory)")
+
+	// API Keys
+	startServerCmd.Flags().String("keys-file", "", "keys file of the API keys requests must authenticate with, no authentication when empty")
+
+	// ACL Policy
+	startServerCmd.Flags().String("acl-file", "", "ACL policy file granting API keys actions on file name prefixes, requires keys-file")
+
+	// Audit Log
+	startServerCmd.Flags().String("audit-log", "", "audit log recording every upload, overwrite and delete, disabled when empty")
+	startServerCmd.Flags().Int64("audit-max-mb", 100, "size in MB the audit log is rotated at, never rotated when 0")
+
+	// TLS
+	startServerCmd.Flags().String("tls-cert", "", "certificate file for serving every API over TLS, reloaded when it changes")
+	startServerCmd.Flags().String("tls-key", "", "key file of the TLS certificate")
+	startServerCmd.Flags().String("client-ca", "", "CA file clients must present a certificate signed by, enables mutual TLS")
+	startServerCmd.Flags().StringArray("client-identity", nil, "maps a client certificate subject or common name to the API key "+
+		"it authenticates as, as SUBJECT=NAME, can be repeated (default the common name)")
+
+	// Signed URLs
+	startServerCmd.Flags().StringArray("sign-secret", nil, "secret for signed URLs as ID:SECRET, can be repeated to rotate secrets, "+
+		"the first signs new URLs and the others keep verifying URLs signed before the rotation")
+
+	// gRPC
+	startServerCmd.Flags().String("grpc-address", "", "address for the gRPC API, e.g. 127.0.0.1:9090, disabled when empty")
+
+	// WebDAV
+	startServerCmd.Flags().String("webdav-path", "", "path to serve the files over WebDAV at, e.g. /dav, disabled when empty")
+
+	// Shutdown
+	startServerCmd.Flags().Duration("drain-timeout", 30*time.Second, "time active requests get to finish on SIGINT or SIGTERM before they are aborted")
+
+	// Metrics
+	startServerCmd.Flags().String("metrics-address", "", "address for a separate metrics listener without authentication, e.g. 127.0.0.1:9100, served at /metrics of the server when empty")
+
+	// S3 Frontend
+	startServerCmd.Flags().String("s3-address", "", "address for the S3-compatible API, disabled when empty")
+	startServerCmd.Flags().StringArray("s3-key", nil, "S3 credentials as ACCESS_KEY:SECRET_KEY[:KEY_NAME], can be repeated, "+
+		"with keys-file the access key authenticates as the API key KEY_NAME (default the access key)")
+	startServerCmd.Flags().String("s3-region", "us-east-1", "region S3 requests are signed for")
 }
@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	. "fs-store/types"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// signCmd represents the sign command
+var signCmd = &cobra.Command{
+	Use:   "sign [get|put|list] [name]",
+	Short: "creates an expiring URL for downloading, uploading or listing files without an API key",
+	Long: "creates an expiring URL signed by the server, get downloads and put uploads the file " +
+		"with the name while list lists the files under the name as a prefix",
+	Args: cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		expires, err := cmd.Flags().GetDuration("expires")
+		if err != nil {
+			return err
+		}
+		maxSize, err := cmd.Flags().GetInt64("max-size")
+		if err != nil {
+			return err
+		}
+		overwrite, err := cmd.Flags().GetBool("overwrite")
+		if err != nil {
+			return err
+		}
+		if expires < time.Second {
+			return errors.New("expires must be at least 1s")
+		}
+
+		name := ""
+		if len(args) > 1 {
+			name = args[1]
+		}
+		req := SignRequest{ExpiresIn: int64(expires / time.Second)}
+		switch strings.ToLower(args[0]) {
+		case "get":
+			if name == "" {
+				return errors.New("file name is required")
+			}
+			req.Method, req.FileName = "GET", name
+		case "put":
+			if name == "" {
+				return errors.New("file name is required")
+			}
+			req.Method, req.FileName = "PUT", name
+			req.MaxSize, req.Overwrite = maxSize, overwrite
+		case "list":
+			req.Method, req.Prefix = "GET", name
+		default:
+			return fmt.Errorf("unknown sign operation: %s, must be get, put or list", args[0])
+		}
+
+		client, err := newClient(cmd)
+		if err != nil {
+			return err
+		}
+		resp, err := client.SignURL(req)
+		if err != nil {
+			return err
+		}
+		fmt.Println(resp.URL)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(signCmd)
+	setupCommonClientFlags(signCmd)
+
+	// Expiry
+	signCmd.Flags().DurationP("expires", "e", time.Hour, "time the URL is valid for, at most 168h")
+
+	// Max Size
+	signCmd.Flags().Int64("max-size", 0, "max size in bytes of the file uploaded with a put URL, no limit when 0")
+
+	// Overwrite
+	signCmd.Flags().Bool("overwrite", false, "allow a put URL to overwrite an existing file")
+}
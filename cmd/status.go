@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	. "fs-store/types"
+
+	"github.com/spf13/cobra"
+)
+
+// statusCmd represents the status command
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "shows the version, limits and readiness of the server",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		jsonOutput, err := cmd.Flags().GetBool("json")
+		if err != nil {
+			return err
+		}
+
+		client, err := newClient(cmd)
+		if err != nil {
+			return err
+		}
+		info, err := client.Info()
+		if err != nil {
+			return err
+		}
+		ready, err := client.Ready()
+		if err != nil {
+			return err
+		}
+
+		if jsonOutput {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			err := encoder.Encode(struct {
+				*InfoResponse
+				Ready  bool             `json:"ready"`
+				Checks []ReadinessCheck `json:"checks"`
+			}{info, ready.Success, ready.Checks})
+			if err != nil {
+				return err
+			}
+		} else {
+			fmt.Printf("Version: %s (%s)\n", info.Version, info.Revision)
+			fmt.Printf("Max File Size: %d bytes\n", info.MaxFileSize)
+			fmt.Printf("Max List Size: %d\n", info.MaxListSize)
+			fmt.Printf("Format Versions: %v (writing %d)\n", info.FormatVersions, info.FormatVersion)
+			fmt.Printf("Ready: %t\n", ready.Success)
+			for _, check := range ready.Checks {
+				status := "ok"
+				if !check.OK {
+					status = "failed: " + check.Message
+				}
+				fmt.Printf("  %s: %s\n", check.Name, status)
+			}
+		}
+
+		if !ready.Success {
+			return errors.New("server not ready")
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+	setupCommonClientFlags(statusCmd)
+
+	// JSON Output
+	statusCmd.Flags().Bool("json", false, "print the status as json")
+}
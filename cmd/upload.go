@@ -1,10 +1,14 @@
 package cmd
 
 import (
+	"crypto/md5"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"fs-store/client"
+	. "fs-store/types"
 	"os"
+	"path/filepath"
 
 	"github.com/spf13/cobra"
 )
@@ -38,12 +42,7 @@ var uploadFileCmd = &cobra.Command{
 	},
 	RunE: func(cmd *cobra.Command, paths []string) error {
 		// Configure the client
-		serverUrl := cmd.Flag("url").Value.String()
-		verbose, err := cmd.Flags().GetBool("verbose")
-		if err != nil {
-			return err
-		}
-		client, err := client.NewFSClientConfig(serverUrl, verbose)
+		client, err := newClient(cmd)
 		if err != nil {
 			return err
 		}
@@ -56,6 +55,15 @@ var uploadFileCmd = &cobra.Command{
 			return err
 		}
 
+		chunkSizeMB, err := cmd.Flags().GetInt64("chunk-mb")
+		if err != nil {
+			return err
+		}
+		retries, err := cmd.Flags().GetInt("retries")
+		if err != nil {
+			return err
+		}
+
 		fmt.Println()
 		// Upload the files specified in the paths (args)
 		for _, path := range paths {
@@ -66,7 +74,12 @@ var uploadFileCmd = &cobra.Command{
 			}
 			defer file.Close()
 
-			if err := client.UploadFile(file.Name(), file, overwrite); err != nil {
+			if chunkSizeMB > 0 {
+				err = uploadChunked(client, file, 1024*1024*chunkSizeMB, retries, overwrite)
+			} else {
+				err = client.UploadFile(file.Name(), file, overwrite)
+			}
+			if err != nil {
 				return err
 			}
 
@@ -76,10 +89,91 @@ var uploadFileCmd = &cobra.Command{
 	},
 }
 
+// uploadChunked uploads a file using an upload session, the session id is kept in
+// the user cache directory so a failed upload is resumed by running the command again
+func uploadChunked(client *client.FSClientConfig, file *os.File, chunkSize int64, retries int, overwrite bool) error {
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	statePath, err := uploadStatePath(client.Client.BaseURL, file, info)
+	if err != nil {
+		return err
+	}
+
+	// Resume the previous session if it's still valid
+	var session *UploadSessionResponse
+	if uploadID, err := os.ReadFile(statePath); err == nil {
+		session, err = client.GetUploadSession(string(uploadID))
+		if err != nil || session.FileName != file.Name() || session.FileSize != info.Size() {
+			session = nil
+		} else {
+			fmt.Println("Resuming upload session: " + session.UploadID)
+		}
+	}
+
+	if session == nil {
+		session, err = client.CreateUploadSession(file.Name(), info.Size(), chunkSize, overwrite)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(statePath), 0700); err != nil {
+			return err
+		}
+		if err := os.WriteFile(statePath, []byte(session.UploadID), 0600); err != nil {
+			return err
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		err = client.UploadMissingChunks(session, file)
+		if err == nil {
+			break
+		}
+		if attempt >= retries {
+			fmt.Println("Upload failed, run the command again to resume the upload")
+			return err
+		}
+
+		fmt.Println("Retrying upload: " + err.Error())
+		if session, err = client.GetUploadSession(session.UploadID); err != nil {
+			return err
+		}
+	}
+
+	if err := client.CommitUploadSession(session.UploadID); err != nil {
+		return err
+	}
+	return os.Remove(statePath)
+}
+
+// uploadStatePath returns the path of the file storing the upload session of a file
+func uploadStatePath(serverUrl string, file *os.File, info os.FileInfo) (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	absPath, err := filepath.Abs(file.Name())
+	if err != nil {
+		return "", err
+	}
+
+	// A changed file gets a new state path, so stale sessions are never resumed
+	key := fmt.Sprintf("%s\n%s\n%d\n%d", serverUrl, absPath,
+		info.Size(), info.ModTime().UnixNano())
+	sum := md5.Sum([]byte(key))
+	return filepath.Join(cacheDir, "fs-store", "uploads", hex.EncodeToString(sum[:])), nil
+}
+
 func init() {
 	rootCmd.AddCommand(uploadFileCmd)
 	setupCommonClientFlags(uploadFileCmd)
 
 	// Overwrite
 	uploadFileCmd.Flags().BoolP("overwrite", "o", false, "overwrite existing file")
+
+	// Chunked Upload
+	uploadFileCmd.Flags().Int64P("chunk-mb", "c", 0, "upload in resumable chunks of this size in MB (0 disables)")
+	uploadFileCmd.Flags().IntP("retries", "r", 3, "retries for failed chunked uploads")
 }
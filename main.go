@@ -2,6 +2,12 @@ package main
 
 import "fs-store/cmd"
 
+// Version and Revision are set by the Makefile with ldflags
+var (
+	Version  = "dev"
+	Revision = "unknown"
+)
+
 func main() {
-	cmd.Execute()
+	cmd.Execute(Version, Revision)
 }
@@ -0,0 +1,348 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             v3.19.4
+// source: fsstore.proto
+
+package rpc
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// FileStoreClient is the client API for FileStore service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type FileStoreClient interface {
+	// List streams the files and common prefixes sorted by the options
+	List(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (FileStore_ListClient, error)
+	// Upload stores a file from a header followed by chunks of its content
+	Upload(ctx context.Context, opts ...grpc.CallOption) (FileStore_UploadClient, error)
+	// Download streams the information of a file followed by chunks of its content
+	Download(ctx context.Context, in *DownloadRequest, opts ...grpc.CallOption) (FileStore_DownloadClient, error)
+	// Delete deletes a file, or every file under a prefix ending with /
+	Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error)
+	// Stat returns the information of a file
+	Stat(ctx context.Context, in *StatRequest, opts ...grpc.CallOption) (*FileInfo, error)
+}
+
+type fileStoreClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewFileStoreClient(cc grpc.ClientConnInterface) FileStoreClient {
+	return &fileStoreClient{cc}
+}
+
+func (c *fileStoreClient) List(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (FileStore_ListClient, error) {
+	stream, err := c.cc.NewStream(ctx, &FileStore_ServiceDesc.Streams[0], "/fsstore.v1.FileStore/List", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &fileStoreListClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type FileStore_ListClient interface {
+	Recv() (*ListResponse, error)
+	grpc.ClientStream
+}
+
+type fileStoreListClient struct {
+	grpc.ClientStream
+}
+
+func (x *fileStoreListClient) Recv() (*ListResponse, error) {
+	m := new(ListResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *fileStoreClient) Upload(ctx context.Context, opts ...grpc.CallOption) (FileStore_UploadClient, error) {
+	stream, err := c.cc.NewStream(ctx, &FileStore_ServiceDesc.Streams[1], "/fsstore.v1.FileStore/Upload", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &fileStoreUploadClient{stream}
+	return x, nil
+}
+
+type FileStore_UploadClient interface {
+	Send(*UploadRequest) error
+	CloseAndRecv() (*FileInfo, error)
+	grpc.ClientStream
+}
+
+type fileStoreUploadClient struct {
+	grpc.ClientStream
+}
+
+func (x *fileStoreUploadClient) Send(m *UploadRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *fileStoreUploadClient) CloseAndRecv() (*FileInfo, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(FileInfo)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *fileStoreClient) Download(ctx context.Context, in *DownloadRequest, opts ...grpc.CallOption) (FileStore_DownloadClient, error) {
+	stream, err := c.cc.NewStream(ctx, &FileStore_ServiceDesc.Streams[2], "/fsstore.v1.FileStore/Download", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &fileStoreDownloadClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type FileStore_DownloadClient interface {
+	Recv() (*DownloadResponse, error)
+	grpc.ClientStream
+}
+
+type fileStoreDownloadClient struct {
+	grpc.ClientStream
+}
+
+func (x *fileStoreDownloadClient) Recv() (*DownloadResponse, error) {
+	m := new(DownloadResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *fileStoreClient) Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error) {
+	out := new(DeleteResponse)
+	err := c.cc.Invoke(ctx, "/fsstore.v1.FileStore/Delete", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileStoreClient) Stat(ctx context.Context, in *StatRequest, opts ...grpc.CallOption) (*FileInfo, error) {
+	out := new(FileInfo)
+	err := c.cc.Invoke(ctx, "/fsstore.v1.FileStore/Stat", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// FileStoreServer is the server API for FileStore service.
+// All implementations must embed UnimplementedFileStoreServer
+// for forward compatibility
+type FileStoreServer interface {
+	// List streams the files and common prefixes sorted by the options
+	List(*ListRequest, FileStore_ListServer) error
+	// Upload stores a file from a header followed by chunks of its content
+	Upload(FileStore_UploadServer) error
+	// Download streams the information of a file followed by chunks of its content
+	Download(*DownloadRequest, FileStore_DownloadServer) error
+	// Delete deletes a file, or every file under a prefix ending with /
+	Delete(context.Context, *DeleteRequest) (*DeleteResponse, error)
+	// Stat returns the information of a file
+	Stat(context.Context, *StatRequest) (*FileInfo, error)
+	mustEmbedUnimplementedFileStoreServer()
+}
+
+// UnimplementedFileStoreServer must be embedded to have forward compatible implementations.
+type UnimplementedFileStoreServer struct {
+}
+
+func (UnimplementedFileStoreServer) List(*ListRequest, FileStore_ListServer) error {
+	return status.Errorf(codes.Unimplemented, "method List not implemented")
+}
+func (UnimplementedFileStoreServer) Upload(FileStore_UploadServer) error {
+	return status.Errorf(codes.Unimplemented, "method Upload not implemented")
+}
+func (UnimplementedFileStoreServer) Download(*DownloadRequest, FileStore_DownloadServer) error {
+	return status.Errorf(codes.Unimplemented, "method Download not implemented")
+}
+func (UnimplementedFileStoreServer) Delete(context.Context, *DeleteRequest) (*DeleteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Delete not implemented")
+}
+func (UnimplementedFileStoreServer) Stat(context.Context, *StatRequest) (*FileInfo, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Stat not implemented")
+}
+func (UnimplementedFileStoreServer) mustEmbedUnimplementedFileStoreServer() {}
+
+// UnsafeFileStoreServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to FileStoreServer will
+// result in compilation errors.
+type UnsafeFileStoreServer interface {
+	mustEmbedUnimplementedFileStoreServer()
+}
+
+func RegisterFileStoreServer(s grpc.ServiceRegistrar, srv FileStoreServer) {
+	s.RegisterService(&FileStore_ServiceDesc, srv)
+}
+
+func _FileStore_List_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ListRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(FileStoreServer).List(m, &fileStoreListServer{stream})
+}
+
+type FileStore_ListServer interface {
+	Send(*ListResponse) error
+	grpc.ServerStream
+}
+
+type fileStoreListServer struct {
+	grpc.ServerStream
+}
+
+func (x *fileStoreListServer) Send(m *ListResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _FileStore_Upload_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(FileStoreServer).Upload(&fileStoreUploadServer{stream})
+}
+
+type FileStore_UploadServer interface {
+	SendAndClose(*FileInfo) error
+	Recv() (*UploadRequest, error)
+	grpc.ServerStream
+}
+
+type fileStoreUploadServer struct {
+	grpc.ServerStream
+}
+
+func (x *fileStoreUploadServer) SendAndClose(m *FileInfo) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *fileStoreUploadServer) Recv() (*UploadRequest, error) {
+	m := new(UploadRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _FileStore_Download_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(DownloadRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(FileStoreServer).Download(m, &fileStoreDownloadServer{stream})
+}
+
+type FileStore_DownloadServer interface {
+	Send(*DownloadResponse) error
+	grpc.ServerStream
+}
+
+type fileStoreDownloadServer struct {
+	grpc.ServerStream
+}
+
+func (x *fileStoreDownloadServer) Send(m *DownloadResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _FileStore_Delete_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileStoreServer).Delete(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/fsstore.v1.FileStore/Delete",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileStoreServer).Delete(ctx, req.(*DeleteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileStore_Stat_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StatRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileStoreServer).Stat(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/fsstore.v1.FileStore/Stat",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileStoreServer).Stat(ctx, req.(*StatRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// FileStore_ServiceDesc is the grpc.ServiceDesc for FileStore service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var FileStore_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "fsstore.v1.FileStore",
+	HandlerType: (*FileStoreServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Delete",
+			Handler:    _FileStore_Delete_Handler,
+		},
+		{
+			MethodName: "Stat",
+			Handler:    _FileStore_Stat_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "List",
+			Handler:       _FileStore_List_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Upload",
+			Handler:       _FileStore_Upload_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "Download",
+			Handler:       _FileStore_Download_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "fsstore.proto",
+}
@@ -0,0 +1,204 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// aclEveryone is the ACL subject matching every user
+const aclEveryone = "*"
+
+// ErrInvalidACL is returned for an invalid ACL policy file
+var ErrInvalidACL = errors.New("invalid acl policy")
+
+// ACLRule grants the actions on the names with the prefix to its subjects,
+// subjects are user:<name>, group:<name> or * for every user
+type ACLRule struct {
+	Subjects []string `json:"subjects"`
+	Prefix   string   `json:"prefix"`
+	Actions  []Scope  `json:"actions"`
+}
+
+// ACLPolicy is the ACL policy file, users are the names of API keys and a
+// user can only act on names granted by a rule
+type ACLPolicy struct {
+	Groups map[string][]string `json:"groups,omitempty"`
+	Rules  []ACLRule           `json:"rules"`
+}
+
+// ACLPermission is an effective permission of a user on the names with the prefix
+type ACLPermission struct {
+	Prefix  string  `json:"prefix"`
+	Actions []Scope `json:"actions"`
+}
+
+// LoadACLPolicy loads and validates an ACL policy file
+func LoadACLPolicy(policyFile string) (*ACLPolicy, error) {
+	b, err := os.ReadFile(policyFile)
+	if err != nil {
+		return nil, err
+	}
+	policy := &ACLPolicy{}
+	if err := json.Unmarshal(b, policy); err != nil {
+		return nil, fmt.Errorf("%w %s: %v", ErrInvalidACL, policyFile, err)
+	}
+	if err := policy.validate(); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// validate checks the subjects and actions of the rules
+func (p *ACLPolicy) validate() error {
+	for i, rule := range p.Rules {
+		if len(rule.Subjects) == 0 || len(rule.Actions) == 0 {
+			return fmt.Errorf("%w: rule %d needs subjects and actions", ErrInvalidACL, i)
+		}
+		for _, subject := range rule.Subjects {
+			switch {
+			case subject == aclEveryone, strings.HasPrefix(subject, "user:") && len(subject) > 5:
+			case strings.HasPrefix(subject, "group:"):
+				if _, ok := p.Groups[strings.TrimPrefix(subject, "group:")]; !ok {
+					return fmt.Errorf("%w: rule %d has unknown group %s", ErrInvalidACL, i, subject)
+				}
+			default:
+				return fmt.Errorf("%w: rule %d has invalid subject %s", ErrInvalidACL, i, subject)
+			}
+		}
+		for _, action := range rule.Actions {
+			if action != ScopeRead && action != ScopeWrite && action != ScopeDelete {
+				return fmt.Errorf("%w: rule %d has invalid action %s, must be read, write or delete", ErrInvalidACL, i, action)
+			}
+		}
+	}
+	return nil
+}
+
+// matches checks if a rule applies to the user
+func (p *ACLPolicy) matches(rule ACLRule, user string) bool {
+	for _, subject := range rule.Subjects {
+		if subject == aclEveryone || subject == "user:"+user {
+			return true
+		}
+		if strings.HasPrefix(subject, "group:") {
+			for _, member := range p.Groups[strings.TrimPrefix(subject, "group:")] {
+				if member == user {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// grants checks if a rule grants the action
+func (rule ACLRule) grants(action Scope) bool {
+	for _, a := range rule.Actions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// Allows checks if a rule grants the user the action on the name, a
+// prefix such as a deleted directory is checked like a name
+func (p *ACLPolicy) Allows(user string, action Scope, name string) bool {
+	for _, rule := range p.Rules {
+		if strings.HasPrefix(name, rule.Prefix) && rule.grants(action) && p.matches(rule, user) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsUnder checks if a rule grants the user the action on any name with
+// the prefix, such as a common prefix of a listing
+func (p *ACLPolicy) allowsUnder(user string, action Scope, prefix string) bool {
+	for _, rule := range p.Rules {
+		if (strings.HasPrefix(prefix, rule.Prefix) || strings.HasPrefix(rule.Prefix, prefix)) &&
+			rule.grants(action) && p.matches(rule, user) {
+			return true
+		}
+	}
+	return false
+}
+
+// Effective returns the actions the rules grant the user by prefix, sorted by prefix
+func (p *ACLPolicy) Effective(user string) []ACLPermission {
+	actions := map[string]map[Scope]bool{}
+	for _, rule := range p.Rules {
+		if !p.matches(rule, user) {
+			continue
+		}
+		if actions[rule.Prefix] == nil {
+			actions[rule.Prefix] = map[Scope]bool{}
+		}
+		for _, action := range rule.Actions {
+			actions[rule.Prefix][action] = true
+		}
+	}
+
+	permissions := make([]ACLPermission, 0, len(actions))
+	for prefix, granted := range actions {
+		permission := ACLPermission{Prefix: prefix}
+		for _, action := range []Scope{ScopeRead, ScopeWrite, ScopeDelete} {
+			if granted[action] {
+				permission.Actions = append(permission.Actions, action)
+			}
+		}
+		permissions = append(permissions, permission)
+	}
+	sort.Slice(permissions, func(i, j int) bool {
+		return permissions[i].Prefix < permissions[j].Prefix
+	})
+	return permissions
+}
+
+// GroupsOf returns the groups of the user
+func (p *ACLPolicy) GroupsOf(user string) []string {
+	groups := []string{}
+	for group, members := range p.Groups {
+		for _, member := range members {
+			if member == user {
+				groups = append(groups, group)
+				break
+			}
+		}
+	}
+	sort.Strings(groups)
+	return groups
+}
+
+// KeyAllows checks if the key allows the action on the name by its scopes,
+// prefixes and the policy, keys with the admin scope bypass the policy and
+// a nil policy grants every name
+func (p *ACLPolicy) KeyAllows(key *APIKey, action Scope, name string) bool {
+	if !key.hasScope(action) || !key.allowsName(name) {
+		return false
+	}
+	return p == nil || key.hasScope(ScopeAdmin) || p.Allows(key.Name, action, name)
+}
+
+// keyAllows checks if the key allows the action on the name with the ACL policy of the server
+func (sc *ServerConfig) keyAllows(key *APIKey, action Scope, name string) bool {
+	return sc.ACL.KeyAllows(key, action, name)
+}
+
+// listFilter returns the filter of the files and common prefixes the key can
+// read for listing, nil if the key can read every name
+func (sc *ServerConfig) listFilter(key *APIKey) func(string, bool) bool {
+	if sc.ACL == nil || key.hasScope(ScopeAdmin) {
+		return nil
+	}
+	return func(name string, commonPrefix bool) bool {
+		if commonPrefix {
+			return sc.ACL.allowsUnder(key.Name, ScopeRead, name)
+		}
+		return sc.ACL.Allows(key.Name, ScopeRead, name)
+	}
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"fs-store/client"
+	. "fs-store/types"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testACLPolicy gives each team its own prefix and everyone read access to public/
+const testACLPolicy = `{
+  "groups": {"team-a": ["alice"], "team-b": ["bob"]},
+  "rules": [
+    {"subjects": ["group:team-a"], "prefix": "a/", "actions": ["read", "write", "delete"]},
+    {"subjects": ["group:team-b"], "prefix": "b/", "actions": ["read", "write"]},
+    {"subjects": ["*"], "prefix": "public/", "actions": ["read"]},
+    {"subjects": ["user:bob"], "prefix": "public/", "actions": ["write"]}
+  ]
+}`
+
+// getACLServerConfig returns a server config with a key per team and the test policy
+func getACLServerConfig(t *testing.T) (*ServerConfig, map[string]string) {
+	sc, tokens := getAuthServerConfig(t)
+	keysFile := filepath.Join(sc.DataDir, "keys.json")
+	all := []Scope{ScopeRead, ScopeWrite, ScopeDelete}
+	tokens["alice"] = addTestKey(t, keysFile, "alice", all)
+	tokens["bob"] = addTestKey(t, keysFile, "bob", all)
+
+	aclFile := filepath.Join(sc.DataDir, "acl.json")
+	assert.NoError(t, os.WriteFile(aclFile, []byte(testACLPolicy), 0644))
+	var err error
+	if sc.APIKeys, err = LoadAPIKeys(keysFile); !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	if sc.ACL, err = LoadACLPolicy(aclFile); !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return sc, tokens
+}
+
+// Test_ACLPolicy tests validating and evaluating a policy
+func Test_ACLPolicy(t *testing.T) {
+	dir := "../.testdata/.tmp/"
+	assert.NoError(t, os.MkdirAll(dir, 0755))
+	defer os.RemoveAll(dir)
+
+	for _, invalid := range []string{
+		`{"rules": [{"subjects": ["group:missing"], "prefix": "a/", "actions": ["read"]}]}`,
+		`{"rules": [{"subjects": ["alice"], "prefix": "a/", "actions": ["read"]}]}`,
+		`{"rules": [{"subjects": ["*"], "prefix": "a/", "actions": ["admin"]}]}`,
+		`{"rules": [{"subjects": ["*"], "prefix": "a/"}]}`,
+	} {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, "acl.json"), []byte(invalid), 0644))
+		_, err := LoadACLPolicy(filepath.Join(dir, "acl.json"))
+		assert.ErrorIs(t, err, ErrInvalidACL, invalid)
+	}
+
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "acl.json"), []byte(testACLPolicy), 0644))
+	policy, err := LoadACLPolicy(filepath.Join(dir, "acl.json"))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.True(t, policy.Allows("alice", ScopeDelete, "a/x.txt"))
+	assert.False(t, policy.Allows("alice", ScopeRead, "b/x.txt"), "Read of another team's prefix")
+	assert.False(t, policy.Allows("bob", ScopeDelete, "b/x.txt"), "Action not granted by the rule")
+	assert.True(t, policy.Allows("carol", ScopeRead, "public/x.txt"), "Rule for everyone")
+	assert.False(t, policy.Allows("carol", ScopeRead, "a"), "Name shorter than the prefix")
+
+	assert.Equal(t, []string{"team-b"}, policy.GroupsOf("bob"))
+	assert.Equal(t, []ACLPermission{
+		{Prefix: "b/", Actions: []Scope{ScopeRead, ScopeWrite}},
+		{Prefix: "public/", Actions: []Scope{ScopeRead, ScopeWrite}},
+	}, policy.Effective("bob"))
+
+	admin := &APIKey{Name: "alice", Scopes: []Scope{ScopeAdmin}}
+	assert.True(t, policy.KeyAllows(admin, ScopeDelete, "b/x.txt"), "Admin key not bypassing the policy")
+	reader := &APIKey{Name: "alice", Scopes: []Scope{ScopeRead}}
+	assert.False(t, policy.KeyAllows(reader, ScopeWrite, "a/x.txt"), "Action without the scope of the key")
+}
+
+// Test_ACL_Routes tests that teams can't read, write or delete each other's files
+func Test_ACL_Routes(t *testing.T) {
+	sc, tokens := getACLServerConfig(t)
+	defer os.RemoveAll(sc.DataDir)
+
+	request := func(token, method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		return doRequest(sc, req)
+	}
+
+	assert.Equal(t, 200, request(tokens["alice"], "PUT", "/files/a/1.txt", "data").Code)
+	assert.Equal(t, 200, request(tokens["bob"], "PUT", "/files/b/1.txt", "data").Code)
+	assert.Equal(t, 200, request(tokens["bob"], "PUT", "/files/public/1.txt", "data").Code)
+	assert.Equal(t, 403, request(tokens["alice"], "PUT", "/files/b/2.txt", "data").Code, "Write to another team's prefix")
+	assert.Equal(t, 403, request(tokens["alice"], "GET", "/files/b/1.txt", "").Code, "Read of another team's file")
+	assert.Equal(t, 200, request(tokens["alice"], "GET", "/files/public/1.txt", "").Code)
+	assert.Equal(t, 403, request(tokens["alice"], "DELETE", "/files?filename=b/1.txt", "").Code, "Delete of another team's file")
+	assert.Equal(t, 403, request(tokens["alice"], "DELETE", "/files?prefix=b/", "").Code, "Delete of another team's prefix")
+	assert.Equal(t, 403, request(tokens["bob"], "DELETE", "/files?filename=b/1.txt", "").Code, "Delete not granted")
+
+	body := &bytes.Buffer{}
+	form := multipart.NewWriter(body)
+	part, _ := form.CreateFormFile("file", "b/form.txt")
+	part.Write([]byte("data"))
+	form.Close()
+	req := httptest.NewRequest("POST", "/files", body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+tokens["alice"])
+	assert.Equal(t, 403, doRequest(sc, req).Code, "Form upload to another team's prefix")
+
+	// listings only include the readable files and directories
+	list := func(token, target string) *FileListResponse {
+		rec := request(token, "GET", target, "")
+		assert.Equal(t, 200, rec.Code)
+		page := &FileListResponse{}
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), page))
+		return page
+	}
+	page := list(tokens["alice"], "/files?limit=10")
+	if assert.Len(t, page.Files, 2) {
+		assert.Equal(t, "a/1.txt", page.Files[0].FileName)
+		assert.Equal(t, "public/1.txt", page.Files[1].FileName)
+	}
+	assert.Equal(t, []string{"b/", "public/"}, list(tokens["bob"], "/files?delimiter=/").CommonPrefixes)
+	assert.Len(t, list(tokens["admin"], "/files").Files, 3, "Admin key filtered by the policy")
+
+	// pages are filled with readable files, the unreadable ones don't take their place
+	page = list(tokens["alice"], "/files?limit=1")
+	if assert.Len(t, page.Files, 1) && assert.NotEmpty(t, page.NextCursor) {
+		assert.Equal(t, "a/1.txt", page.Files[0].FileName)
+		page = list(tokens["alice"], "/files?limit=1&cursor="+page.NextCursor)
+		if assert.Len(t, page.Files, 1, "Page of unreadable files") {
+			assert.Equal(t, "public/1.txt", page.Files[0].FileName)
+		}
+		assert.Empty(t, page.NextCursor, "Cursor after the last readable file")
+	}
+
+	// WebDAV listings only include the readable files and directories
+	sc.WebDAVPath = "/dav"
+	req = davRequest("PROPFIND", "/dav/", "", "Depth", "1")
+	req.Header.Set("Authorization", "Bearer "+tokens["alice"])
+	rec := doRequest(sc, req)
+	assert.Equal(t, 207, rec.Code)
+	assert.Contains(t, rec.Body.String(), "<D:href>/dav/a/</D:href>")
+	assert.Contains(t, rec.Body.String(), "<D:href>/dav/public/</D:href>")
+	assert.NotContains(t, rec.Body.String(), "/dav/b/", "Directory of another team listed")
+	req = davRequest("PROPFIND", "/dav/b/", "", "Depth", "1")
+	req.Header.Set("Authorization", "Bearer "+tokens["alice"])
+	assert.NotContains(t, doRequest(sc, req).Body.String(), "1.txt", "File of another team listed")
+	req = davRequest("PROPFIND", "/dav/b/1.txt", "", "Depth", "0")
+	req.Header.Set("Authorization", "Bearer "+tokens["alice"])
+	assert.Equal(t, 403, doRequest(sc, req).Code, "Properties of another team's file")
+	req = davRequest("PROPFIND", "/dav/a/", "", "Depth", "1")
+	req.Header.Set("Authorization", "Bearer "+tokens["admin"])
+	assert.Contains(t, doRequest(sc, req).Body.String(), "<D:href>/dav/a/1.txt</D:href>", "Admin key filtered by the policy")
+
+	// the gRPC API filters listings too
+	conf := getGRPCClient(t, sc, client.WithToken(tokens["bob"]))
+	files, err := conf.ListAllFiles(client.ListOptions{})
+	if assert.NoError(t, err) && assert.Len(t, files, 2) {
+		assert.Equal(t, "b/1.txt", files[0].FileName)
+	}
+	assert.EqualError(t, conf.DownloadFile("a/1.txt", &bytes.Buffer{}), "Permission denied")
+}
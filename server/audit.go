@@ -0,0 +1,406 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/metadata"
+)
+
+// AuditAction is a mutating operation recorded in the audit log
+type AuditAction string
+
+const (
+	AuditUpload    AuditAction = "upload"
+	AuditOverwrite AuditAction = "overwrite"
+	AuditDelete    AuditAction = "delete"
+)
+
+// auditSuccess is the result of successful operations
+const auditSuccess = "success"
+
+// auditAnonymous is the identity of unauthenticated requests
+const auditAnonymous = "anonymous"
+
+// ErrAuditChain is returned when the hash chain of the audit log is broken
+var ErrAuditChain = errors.New("audit log hash chain broken")
+
+// errAuditClosed is returned when appending to a closed audit log
+var errAuditClosed = errors.New("audit log closed")
+
+// AuditEntry is a line of the audit log, the hash covers the entry and the
+// hash of the previous entry so changed, removed or reordered entries are detected
+type AuditEntry struct {
+	Time      time.Time   `json:"time"`
+	Identity  string      `json:"identity"`
+	Action    AuditAction `json:"action"`
+	FileName  string      `json:"fileName"`
+	Size      int64       `json:"size,omitempty"`
+	SHA256    string      `json:"sha256,omitempty"`
+	Result    string      `json:"result"`
+	RequestID string      `json:"requestId,omitempty"`
+	PrevHash  string      `json:"prevHash"`
+	Hash      string      `json:"hash,omitempty"`
+}
+
+// computeHash returns the hash of the entry without its own hash
+func (entry AuditEntry) computeHash() (string, error) {
+	entry.Hash = ""
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// auditActor is who performed an operation and the request it was performed in
+type auditActor struct {
+	Identity  string
+	RequestID string
+}
+
+// AuditLog appends entries to a JSON lines file, the file is rotated to a
+// file with the time as suffix once it reaches the max size
+type AuditLog struct {
+	path    string
+	maxSize int64
+
+	mtx      sync.Mutex
+	file     *os.File
+	size     int64
+	lastHash string
+}
+
+// OpenAuditLog opens the audit log at the path, continuing the hash chain of
+// the existing entries, a max size of 0 never rotates the file
+func OpenAuditLog(path string, maxSize int64) (*AuditLog, error) {
+	log := &AuditLog{path: path, maxSize: maxSize}
+	files, err := AuditFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	// the last entry may be in a rotated file if the current file is empty
+	for i := len(files) - 1; i >= 0 && log.lastHash == ""; i-- {
+		if log.lastHash, err = lastAuditHash(files[i]); err != nil {
+			return nil, err
+		}
+	}
+	if err := log.open(); err != nil {
+		return nil, err
+	}
+	return log, nil
+}
+
+// open opens the current file for appending
+func (log *AuditLog) open() error {
+	file, err := os.OpenFile(log.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	log.file, log.size = file, info.Size()
+	return nil
+}
+
+// lastAuditHash returns the hash of the last entry of a file
+func lastAuditHash(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	last := ""
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			last = line
+		}
+	}
+	if err := scanner.Err(); err != nil || last == "" {
+		return "", err
+	}
+	entry := AuditEntry{}
+	if err := json.Unmarshal([]byte(last), &entry); err != nil {
+		return "", fmt.Errorf("invalid audit log entry in %s: %w", path, err)
+	}
+	return entry.Hash, nil
+}
+
+// AuditFiles returns the rotated files of the audit log from the oldest and
+// the current file last, if they exist
+func AuditFiles(path string) ([]string, error) {
+	rotated, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(rotated)
+	if _, err := os.Stat(path); err == nil {
+		rotated = append(rotated, path)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	return rotated, nil
+}
+
+// rotate moves the current file aside and opens a new one, the chain
+// continues in the new file
+func (log *AuditLog) rotate() error {
+	if err := log.file.Close(); err != nil {
+		return err
+	}
+	rotated := log.path + "." + time.Now().UTC().Format("20060102T150405.000000000")
+	if err := os.Rename(log.path, rotated); err != nil {
+		return err
+	}
+	logrus.Info("Rotated audit log to ", rotated)
+	return log.open()
+}
+
+// append chains the entry to the previous entry and writes it
+func (log *AuditLog) append(entry AuditEntry) error {
+	log.mtx.Lock()
+	defer log.mtx.Unlock()
+
+	if log.file == nil {
+		return errAuditClosed
+	}
+	if log.maxSize > 0 && log.size >= log.maxSize {
+		if err := log.rotate(); err != nil {
+			return err
+		}
+	}
+
+	entry.Time = entry.Time.UTC()
+	entry.PrevHash = log.lastHash
+	var err error
+	if entry.Hash, err = entry.computeHash(); err != nil {
+		return err
+	}
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	n, err := log.file.Write(append(b, '\n'))
+	log.size += int64(n)
+	if err != nil {
+		return err
+	}
+	log.lastHash = entry.Hash
+	return log.file.Sync()
+}
+
+// Close closes the current file, entries can't be appended afterwards
+func (log *AuditLog) Close() error {
+	log.mtx.Lock()
+	defer log.mtx.Unlock()
+	if log.file == nil {
+		return nil
+	}
+	err := log.file.Close()
+	log.file = nil
+	return err
+}
+
+// record appends an entry for an operation, failing to write the audit log
+// is logged as the operation itself already happened
+func (sc *ServerConfig) record(actor auditActor, action AuditAction, fileName string, size int64, sum []byte, err error) {
+	if sc.Audit == nil {
+		return
+	}
+	entry := AuditEntry{
+		Time:      time.Now(),
+		Identity:  actor.Identity,
+		Action:    action,
+		FileName:  fileName,
+		Size:      size,
+		Result:    auditSuccess,
+		RequestID: actor.RequestID,
+	}
+	if entry.Identity == "" {
+		entry.Identity = auditAnonymous
+	}
+	if len(sum) > 0 {
+		entry.SHA256 = hex.EncodeToString(sum)
+	}
+	if err != nil {
+		entry.Result = err.Error()
+	}
+	if err := sc.Audit.append(entry); err != nil {
+		logrus.Error("Error writing audit log ", err)
+	}
+}
+
+// hashReader hashes the content read from a reader
+type hashReader struct {
+	io.Reader
+	hash hash.Hash
+}
+
+func (r *hashReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.hash.Write(p[:n])
+	return n, err
+}
+
+// echoActor returns the actor of a request, by its API key, signed URL,
+// client certificate or S3 access key
+func (sc *ServerConfig) echoActor(c echo.Context) auditActor {
+	actor := auditActor{RequestID: c.Response().Header().Get(echo.HeaderXRequestID)}
+	if actor.RequestID == "" {
+		actor.RequestID = c.Response().Header().Get("X-Amz-Request-Id")
+	}
+
+	if key, ok := c.Get(apiKeyContextKey).(*APIKey); ok {
+		actor.Identity = key.Name
+	} else if signed, _ := c.Get(signedContextKey).(bool); signed {
+		actor.Identity = "signed:" + c.QueryParam("keyId")
+	} else if accessKey, ok := c.Get(s3AccessKeyContextKey).(string); ok {
+		actor.Identity = "s3:" + accessKey
+	} else {
+		actor.Identity = sc.TLS.identity(c.Request().TLS)
+	}
+	return actor
+}
+
+// auditActorKey is the context key of the actor of WebDAV requests
+type auditActorKey struct{}
+
+// contextActor returns the actor stored in a request context
+func contextActor(ctx context.Context) auditActor {
+	actor, _ := ctx.Value(auditActorKey{}).(auditActor)
+	return actor
+}
+
+// grpcActor returns the actor of a gRPC call by its API key and the request
+// id of its metadata, a request id is generated if the call has none
+func grpcActor(ctx context.Context) auditActor {
+	actor := auditActor{}
+	if key, ok := ctx.Value(grpcAPIKey{}).(*APIKey); ok {
+		actor.Identity = key.Name
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get("x-request-id")) > 0 {
+		actor.RequestID = md.Get("x-request-id")[0]
+	} else {
+		b := make([]byte, 16)
+		rand.Read(b)
+		actor.RequestID = hex.EncodeToString(b)
+	}
+	return actor
+}
+
+// AuditQuery filters the entries of the audit log, empty fields match every entry
+type AuditQuery struct {
+	Identity string
+	Action   AuditAction
+	Prefix   string
+	Since    time.Time
+	Until    time.Time
+}
+
+// matches checks if the entry matches the query
+func (q AuditQuery) matches(entry AuditEntry) bool {
+	return (q.Identity == "" || entry.Identity == q.Identity) &&
+		(q.Action == "" || entry.Action == q.Action) &&
+		strings.HasPrefix(entry.FileName, q.Prefix) &&
+		(q.Since.IsZero() || !entry.Time.Before(q.Since)) &&
+		(q.Until.IsZero() || entry.Time.Before(q.Until))
+}
+
+// readAuditLog reads the entries of every file of the audit log in order,
+// calling fn with the file and line of each entry
+func readAuditLog(path string, fn func(file string, line int, entry AuditEntry) error) error {
+	files, err := AuditFiles(path)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no audit log at %s", path)
+	}
+	for _, name := range files {
+		if err := readAuditFile(name, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readAuditFile reads the entries of a single audit log file
+func readAuditFile(name string, fn func(file string, line int, entry AuditEntry) error) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		entry := AuditEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return fmt.Errorf("%w: %s:%d: invalid entry: %v", ErrAuditChain, name, line, err)
+		}
+		if err := fn(name, line, entry); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// VerifyAuditLog verifies the hash chain across every file of the audit log
+// and returns the number of entries
+func VerifyAuditLog(path string) (int, error) {
+	count, prevHash := 0, ""
+	err := readAuditLog(path, func(file string, line int, entry AuditEntry) error {
+		hash, err := entry.computeHash()
+		if err != nil {
+			return err
+		}
+		if hash != entry.Hash {
+			return fmt.Errorf("%w: %s:%d: entry was modified", ErrAuditChain, file, line)
+		}
+		if entry.PrevHash != prevHash {
+			return fmt.Errorf("%w: %s:%d: previous entry is missing or was modified", ErrAuditChain, file, line)
+		}
+		count, prevHash = count+1, entry.Hash
+		return nil
+	})
+	return count, err
+}
+
+// QueryAuditLog returns the entries of the audit log matching the query
+func QueryAuditLog(path string, query AuditQuery) ([]AuditEntry, error) {
+	entries := []AuditEntry{}
+	err := readAuditLog(path, func(file string, line int, entry AuditEntry) error {
+		if query.matches(entry) {
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	return entries, err
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test_AuditLog tests recording uploads, overwrites and deletes with their identity
+func Test_AuditLog(t *testing.T) {
+	sc, tokens := getAuthServerConfig(t)
+	defer os.RemoveAll(sc.DataDir)
+	auditFile := filepath.Join(sc.DataDir, "audit.log")
+	var err error
+	if sc.Audit, err = OpenAuditLog(auditFile, 0); !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	request := func(method, target, body string) {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+tokens["builds"])
+		doRequest(sc, req)
+	}
+	request("PUT", "/files/builds/a.txt", "data")
+	request("PUT", "/files/builds/a.txt", "more")
+	request("PUT", "/files/builds/a.txt?overwrite=true", "more")
+	request("DELETE", "/files?filename=builds/a.txt", "")
+	request("DELETE", "/files?filename=builds/a.txt", "")
+	assert.NoError(t, sc.Audit.Close())
+
+	entries, err := QueryAuditLog(auditFile, AuditQuery{})
+	if assert.NoError(t, err) && assert.Len(t, entries, 4, "Missing file isn't a mutation") {
+		sum := sha256.Sum256([]byte("data"))
+		assert.Equal(t, "builds", entries[0].Identity)
+		assert.Equal(t, AuditUpload, entries[0].Action)
+		assert.Equal(t, "builds/a.txt", entries[0].FileName)
+		assert.Equal(t, int64(4), entries[0].Size)
+		assert.Equal(t, hex.EncodeToString(sum[:]), entries[0].SHA256)
+		assert.Equal(t, auditSuccess, entries[0].Result)
+		assert.NotEmpty(t, entries[0].RequestID)
+
+		assert.Equal(t, ErrFileAlreadyExists.Error(), entries[1].Result)
+		assert.Empty(t, entries[1].SHA256, "Hash of content which wasn't stored")
+		assert.Equal(t, AuditOverwrite, entries[2].Action)
+		assert.Equal(t, AuditDelete, entries[3].Action)
+		assert.Equal(t, entries[2].Hash, entries[3].PrevHash, "Entries not chained")
+	}
+
+	entries, err = QueryAuditLog(auditFile, AuditQuery{Action: AuditDelete})
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	count, err := VerifyAuditLog(auditFile)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, count)
+}
+
+// Test_AuditLog_Chain tests rotation and detecting changed and removed entries
+func Test_AuditLog_Chain(t *testing.T) {
+	dir := "../.testdata/.tmp/"
+	assert.NoError(t, os.MkdirAll(dir, 0755))
+	defer os.RemoveAll(dir)
+	auditFile := filepath.Join(dir, "audit.log")
+
+	sc := &ServerConfig{}
+	var err error
+	if sc.Audit, err = OpenAuditLog(auditFile, 500); !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	for i := 0; i < 5; i++ {
+		sc.record(auditActor{Identity: "ci"}, AuditUpload, "a.txt", 4, nil, nil)
+	}
+	assert.NoError(t, sc.Audit.Close())
+
+	// the chain continues after reopening the log
+	if sc.Audit, err = OpenAuditLog(auditFile, 500); !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	sc.record(auditActor{}, AuditDelete, "a.txt", 0, nil, nil)
+	assert.NoError(t, sc.Audit.Close())
+
+	files, err := AuditFiles(auditFile)
+	assert.NoError(t, err)
+	assert.Greater(t, len(files), 1, "Audit log not rotated")
+	count, err := VerifyAuditLog(auditFile)
+	assert.NoError(t, err)
+	assert.Equal(t, 6, count)
+
+	entries, err := QueryAuditLog(auditFile, AuditQuery{Identity: auditAnonymous})
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	// changing an entry breaks its hash
+	data, _ := os.ReadFile(files[0])
+	assert.NoError(t, os.WriteFile(files[0], []byte(strings.Replace(string(data), `"identity":"ci"`, `"identity":"xx"`, 1)), 0600))
+	_, err = VerifyAuditLog(auditFile)
+	assert.ErrorIs(t, err, ErrAuditChain, "Changed entry not detected")
+
+	// removing an entry breaks the chain
+	lines := strings.SplitAfter(string(data), "\n")
+	assert.NoError(t, os.WriteFile(files[0], []byte(strings.Join(lines[1:], "")), 0600))
+	_, err = VerifyAuditLog(auditFile)
+	assert.ErrorIs(t, err, ErrAuditChain, "Removed entry not detected")
+}
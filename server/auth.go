@@ -0,0 +1,410 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "fs-store/types"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Scope is a permission granted to an API key
+type Scope string
+
+const (
+	ScopeRead   Scope = "read"
+	ScopeWrite  Scope = "write"
+	ScopeDelete Scope = "delete"
+	// ScopeAdmin grants every other scope and the admin routes
+	ScopeAdmin Scope = "admin"
+)
+
+// apiKeyTokenPrefix is the prefix of generated tokens
+const apiKeyTokenPrefix = "fss_"
+
+// apiKeyContextKey is the echo context key of the authenticated API key
+const apiKeyContextKey = "apiKey"
+
+var (
+	// ErrInvalidScope is returned for an unknown scope
+	ErrInvalidScope = errors.New("invalid scope")
+
+	// ErrAPIKeyExists is returned when adding a key with the name of an existing key
+	ErrAPIKeyExists = errors.New("api key already exists")
+
+	// ErrAPIKeyNotFound is returned when removing a key which doesn't exist
+	ErrAPIKeyNotFound = errors.New("api key not found")
+
+	// errMissingAPIKey is returned when a request has no bearer token
+	errMissingAPIKey = errors.New("missing api key")
+
+	// errInvalidAPIKey is returned when the bearer token matches no key
+	errInvalidAPIKey = errors.New("invalid api key")
+)
+
+// Supported checks if the scope is known
+func (s Scope) Supported() bool {
+	switch s {
+	case ScopeRead, ScopeWrite, ScopeDelete, ScopeAdmin:
+		return true
+	}
+	return false
+}
+
+// APIKey is an API key in the keys file, only the sha256 hash of the token is stored
+type APIKey struct {
+	Name      string    `json:"name"`
+	Hash      string    `json:"hash"`
+	Scopes    []Scope   `json:"scopes"`
+	Prefixes  []string  `json:"prefixes,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// hasScope checks if the key was granted the scope
+func (k *APIKey) hasScope(scope Scope) bool {
+	for _, s := range k.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsName checks if the name is within the prefixes of the key, keys
+// without prefixes allow every name
+func (k *APIKey) allowsName(name string) bool {
+	if len(k.Prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range k.Prefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKeys are the API keys loaded from a keys file by the hash of their token
+type APIKeys struct {
+	keys map[string]*APIKey
+}
+
+// hashToken returns the hash of a token as stored in the keys file
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// GenerateAPIKey generates a token and its API key, the token is only returned once
+func GenerateAPIKey(name string, scopes []Scope, prefixes []string) (string, *APIKey, error) {
+	if name == "" {
+		return "", nil, errors.New("api key name is required")
+	}
+	if len(scopes) == 0 {
+		return "", nil, ErrInvalidScope
+	}
+	for _, scope := range scopes {
+		if !scope.Supported() {
+			return "", nil, fmt.Errorf("%w: %s", ErrInvalidScope, scope)
+		}
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+	token := apiKeyTokenPrefix + hex.EncodeToString(b)
+	return token, &APIKey{
+		Name:      name,
+		Hash:      hashToken(token),
+		Scopes:    scopes,
+		Prefixes:  prefixes,
+		CreatedAt: time.Now().UTC(),
+	}, nil
+}
+
+// ReadAPIKeys reads the keys of a keys file, a missing file has no keys
+func ReadAPIKeys(keysFile string) ([]APIKey, error) {
+	b, err := os.ReadFile(keysFile)
+	if os.IsNotExist(err) {
+		return []APIKey{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	keys := []APIKey{}
+	if err := json.Unmarshal(b, &keys); err != nil {
+		return nil, fmt.Errorf("invalid keys file %s: %w", keysFile, err)
+	}
+	for _, key := range keys {
+		for _, scope := range key.Scopes {
+			if !scope.Supported() {
+				return nil, fmt.Errorf("%w: %s of api key %s", ErrInvalidScope, scope, key.Name)
+			}
+		}
+	}
+	return keys, nil
+}
+
+// writeAPIKeys writes the keys file atomically
+func writeAPIKeys(keysFile string, keys []APIKey) error {
+	b, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(filepath.Dir(keysFile), tempFilePrefix+"*")
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(b, '\n'))
+	if err == nil {
+		err = file.Sync()
+	}
+	file.Close()
+	if err == nil {
+		err = os.Rename(file.Name(), keysFile)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+	}
+	return err
+}
+
+// AddAPIKey adds a key to the keys file, creating the file if it doesn't exist
+func AddAPIKey(keysFile string, key *APIKey) error {
+	keys, err := ReadAPIKeys(keysFile)
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if k.Name == key.Name {
+			return ErrAPIKeyExists
+		}
+	}
+	return writeAPIKeys(keysFile, append(keys, *key))
+}
+
+// RemoveAPIKey removes the key with the name from the keys file
+func RemoveAPIKey(keysFile, name string) error {
+	keys, err := ReadAPIKeys(keysFile)
+	if err != nil {
+		return err
+	}
+	for i, k := range keys {
+		if k.Name == name {
+			return writeAPIKeys(keysFile, append(keys[:i], keys[i+1:]...))
+		}
+	}
+	return ErrAPIKeyNotFound
+}
+
+// LoadAPIKeys loads the keys of a keys file for authenticating requests
+func LoadAPIKeys(keysFile string) (*APIKeys, error) {
+	if _, err := os.Stat(keysFile); err != nil {
+		return nil, err
+	}
+	keys, err := ReadAPIKeys(keysFile)
+	if err != nil {
+		return nil, err
+	}
+
+	apiKeys := &APIKeys{keys: make(map[string]*APIKey, len(keys))}
+	for i := range keys {
+		apiKeys.keys[keys[i].Hash] = &keys[i]
+	}
+	return apiKeys, nil
+}
+
+// authenticate returns the key of a bearer token from an authorization value
+func (keys *APIKeys) authenticate(authorization string) (*APIKey, error) {
+	parts := strings.SplitN(authorization, " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") || parts[1] == "" {
+		return nil, errMissingAPIKey
+	}
+	key, ok := keys.keys[hashToken(strings.TrimSpace(parts[1]))]
+	if !ok {
+		return nil, errInvalidAPIKey
+	}
+	return key, nil
+}
+
+// byName returns the key with the name, nil if there is none
+func (keys *APIKeys) byName(name string) *APIKey {
+	for _, key := range keys.keys {
+		if key.Name == name {
+			return key
+		}
+	}
+	return nil
+}
+
+// authenticate returns the key of the bearer token, or the key named by the
+// identity of the client certificate if the request has no token
+func (sc *ServerConfig) authenticate(authorization string, state *tls.ConnectionState) (*APIKey, error) {
+	key, err := sc.APIKeys.authenticate(authorization)
+	if err != errMissingAPIKey {
+		return key, err
+	}
+	if identity := sc.TLS.identity(state); identity != "" {
+		if key := sc.APIKeys.byName(identity); key != nil {
+			return key, nil
+		}
+		return nil, errInvalidAPIKey
+	}
+	return nil, err
+}
+
+// routeAccess is the scope a request requires and the file names it accesses
+type routeAccess struct {
+	scope Scope
+	// names are empty if the route checks the file name of the body itself
+	names []string
+	// list routes filter the names they return by the ACL policy
+	list bool
+	// destination is the name a WebDAV MOVE or COPY writes, it requires the
+	// write scope in addition to the scope of the names
+	destination string
+}
+
+// routeAccess returns the access a request requires by its route
+func (sc *ServerConfig) routeAccess(c echo.Context) routeAccess {
+	req := c.Request()
+	if sc.WebDAVPath != "" {
+		prefix := path.Clean("/" + sc.WebDAVPath)
+		if req.URL.Path == prefix || strings.HasPrefix(req.URL.Path, prefix+"/") {
+			names := []string{davName(strings.TrimPrefix(req.URL.Path, prefix))}
+			switch req.Method {
+			case "GET", "HEAD", "OPTIONS":
+				return routeAccess{scope: ScopeRead, names: names}
+			case "PROPFIND":
+				// directories are listings, the ACL policy filters their entries
+				_, isFile := sc.index.get(names[0])
+				return routeAccess{scope: ScopeRead, names: names, list: !isFile}
+			case "DELETE":
+				return routeAccess{scope: ScopeDelete, names: names}
+			case "MOVE", "COPY":
+				// MOVE deletes the source and COPY reads it, both write the destination
+				access := routeAccess{scope: ScopeRead, names: names}
+				if req.Method == "MOVE" {
+					access.scope = ScopeDelete
+				}
+				if dest, err := url.Parse(req.Header.Get("Destination")); err == nil && dest.Path != "" {
+					access.destination = davName(strings.TrimPrefix(dest.Path, prefix))
+				}
+				return access
+			default:
+				return routeAccess{scope: ScopeWrite, names: names}
+			}
+		}
+	}
+
+	switch route := c.Path(); {
+	case route == "/files" && req.Method == "GET":
+		return routeAccess{scope: ScopeRead, names: []string{c.QueryParam("prefix")}, list: true}
+	case route == "/files" && req.Method == "POST":
+		return routeAccess{scope: ScopeWrite}
+	case route == "/files" && req.Method == "DELETE":
+		name := c.QueryParam("filename")
+		if c.QueryParams().Has("prefix") {
+			name = c.QueryParam("prefix")
+		}
+		return routeAccess{scope: ScopeDelete, names: []string{name}}
+	case route == "/files/*":
+		name, _ := fileNameParam(c)
+		if req.Method == "PUT" {
+			return routeAccess{scope: ScopeWrite, names: []string{name}}
+		}
+		return routeAccess{scope: ScopeRead, names: []string{name}}
+	case strings.HasPrefix(route, "/uploads"):
+		return routeAccess{scope: ScopeWrite}
+	case route == "/sign":
+		// the route checks the scope for the method and the name it signs
+		return routeAccess{scope: ScopeRead}
+	case route == "/info":
+		return routeAccess{scope: ScopeRead}
+	}
+	return routeAccess{scope: ScopeAdmin}
+}
+
+// authMiddleware authenticates requests with a bearer API key and checks the
+// scope and name prefixes of the key for the route
+func (sc *ServerConfig) authMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			// signed URLs are granted by their signature
+			if signed, _ := c.Get(signedContextKey).(bool); signed {
+				return next(c)
+			}
+
+			// probes are served to orchestrators without a key
+			if route := c.Path(); route == "/healthz" || route == "/readyz" {
+				return next(c)
+			}
+
+			key, err := sc.authenticate(c.Request().Header.Get(echo.HeaderAuthorization), c.Request().TLS)
+			if err == errMissingAPIKey {
+				c.Response().Header().Set(echo.HeaderWWWAuthenticate, "Bearer")
+				return c.JSON(401, GenericResponse{
+					Success: false,
+					Message: "API key required",
+				})
+			}
+
+			if err != nil {
+				c.Response().Header().Set(echo.HeaderWWWAuthenticate, "Bearer")
+				return c.JSON(401, GenericResponse{
+					Success: false,
+					Message: "Invalid API key",
+				})
+			}
+
+			access := sc.routeAccess(c)
+			allowed := key.hasScope(access.scope)
+			for _, name := range access.names {
+				if access.list {
+					allowed = allowed && key.allowsName(name)
+				} else {
+					allowed = allowed && sc.keyAllows(key, access.scope, name)
+				}
+			}
+			if access.destination != "" {
+				allowed = allowed && sc.keyAllows(key, ScopeWrite, access.destination)
+			}
+			if !allowed {
+				return c.JSON(403, GenericResponse{
+					Success: false,
+					Message: "Permission denied",
+				})
+			}
+
+			c.Set(apiKeyContextKey, key)
+			return next(c)
+		}
+	}
+}
+
+// allows checks if the API key of the request allows the action on the file
+// name, every action is allowed without authentication
+func (sc *ServerConfig) allows(c echo.Context, action Scope, name string) bool {
+	key, ok := c.Get(apiKeyContextKey).(*APIKey)
+	return !ok || sc.keyAllows(key, action, name)
+}
+
+// hasScope checks if the API key of the request was granted the scope,
+// every scope is granted without authentication
+func hasScope(c echo.Context, scope Scope) bool {
+	key, ok := c.Get(apiKeyContextKey).(*APIKey)
+	return !ok || key.hasScope(scope)
+}
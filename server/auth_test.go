@@ -0,0 +1,184 @@
+package server
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"fs-store/client"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// addTestKey adds a key to the keys file and returns its token
+func addTestKey(t *testing.T, keysFile, name string, scopes []Scope, prefixes ...string) string {
+	token, key, err := GenerateAPIKey(name, scopes, prefixes)
+	if !assert.NoError(t, err, "Error generating key") || !assert.NoError(t, AddAPIKey(keysFile, key)) {
+		t.FailNow()
+	}
+	return token
+}
+
+// getAuthServerConfig returns a server config requiring the keys of the keys file
+func getAuthServerConfig(t *testing.T) (*ServerConfig, map[string]string) {
+	sc := getServerConfig(t)
+	keysFile := filepath.Join(sc.DataDir, "keys.json")
+	tokens := map[string]string{
+		"admin":  addTestKey(t, keysFile, "admin", []Scope{ScopeAdmin}),
+		"reader": addTestKey(t, keysFile, "reader", []Scope{ScopeRead}),
+		"builds": addTestKey(t, keysFile, "builds", []Scope{ScopeRead, ScopeWrite, ScopeDelete}, "builds/"),
+	}
+
+	var err error
+	sc.APIKeys, err = LoadAPIKeys(keysFile)
+	if !assert.NoError(t, err, "Error loading keys") {
+		t.FailNow()
+	}
+	return sc, tokens
+}
+
+// Test_APIKeys_File tests adding, reading and removing keys in a keys file
+func Test_APIKeys_File(t *testing.T) {
+	dir := "../.testdata/.tmp/"
+	assert.NoError(t, os.MkdirAll(dir, 0755))
+	defer os.RemoveAll(dir)
+	keysFile := filepath.Join(dir, "keys.json")
+
+	token := addTestKey(t, keysFile, "ci", []Scope{ScopeRead, ScopeWrite}, "builds/")
+	_, key, err := GenerateAPIKey("ci", []Scope{ScopeRead}, nil)
+	assert.NoError(t, err)
+	assert.ErrorIs(t, AddAPIKey(keysFile, key), ErrAPIKeyExists, "Key with an existing name was added")
+	_, _, err = GenerateAPIKey("other", []Scope{"root"}, nil)
+	assert.ErrorIs(t, err, ErrInvalidScope, "Key with an unknown scope was generated")
+
+	// only the hash of the token is stored
+	data, err := os.ReadFile(keysFile)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), token, "Token stored in the keys file")
+
+	keys, err := ReadAPIKeys(keysFile)
+	if assert.NoError(t, err) && assert.Len(t, keys, 1) {
+		assert.Equal(t, "ci", keys[0].Name)
+		assert.Equal(t, []string{"builds/"}, keys[0].Prefixes)
+	}
+
+	assert.NoError(t, RemoveAPIKey(keysFile, "ci"), "Error removing key")
+	assert.ErrorIs(t, RemoveAPIKey(keysFile, "ci"), ErrAPIKeyNotFound)
+	keys, err = ReadAPIKeys(keysFile)
+	assert.NoError(t, err)
+	assert.Empty(t, keys, "Key not removed")
+}
+
+// Test_AuthMiddleware tests the scopes and name prefixes of keys on the routes
+func Test_AuthMiddleware(t *testing.T) {
+	sc, tokens := getAuthServerConfig(t)
+	defer os.RemoveAll(sc.DataDir)
+	sc.WebDAVPath = "/dav"
+
+	request := func(token, method, target, body string) int {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		if strings.HasPrefix(body, "{") {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		return doRequest(sc, req).Code
+	}
+
+	assert.Equal(t, 401, request("", "GET", "/files", ""), "Request without a key was accepted")
+	assert.Equal(t, 401, request("fss_invalid", "GET", "/files", ""), "Request with an invalid key was accepted")
+
+	assert.Equal(t, 200, request(tokens["admin"], "PUT", "/files/a.txt", "data"))
+	assert.Equal(t, 200, request(tokens["reader"], "GET", "/files/a.txt", ""))
+	assert.Equal(t, 403, request(tokens["reader"], "PUT", "/files/b.txt", "data"), "Write without the write scope")
+	assert.Equal(t, 403, request(tokens["reader"], "DELETE", "/files?filename=a.txt", ""), "Delete without the delete scope")
+	assert.Equal(t, 403, request(tokens["reader"], "POST", "/admin/fsck", ""), "Admin route without the admin scope")
+	assert.Equal(t, 200, request(tokens["admin"], "POST", "/admin/fsck", ""))
+
+	// keys with prefixes only access names with the prefixes
+	assert.Equal(t, 200, request(tokens["builds"], "PUT", "/files/builds/a.txt", "data"))
+	assert.Equal(t, 403, request(tokens["builds"], "PUT", "/files/other/a.txt", "data"), "Write outside the prefix")
+	assert.Equal(t, 403, request(tokens["builds"], "GET", "/files/a.txt", ""), "Read outside the prefix")
+	assert.Equal(t, 403, request(tokens["builds"], "GET", "/files", ""), "List outside the prefix")
+	assert.Equal(t, 200, request(tokens["builds"], "GET", "/files?prefix=builds/", ""))
+	assert.Equal(t, 403, request(tokens["builds"], "DELETE", "/files?prefix=other/", ""), "Delete outside the prefix")
+	assert.Equal(t, 403, request(tokens["builds"], "POST", "/uploads",
+		`{"fileName":"other/a.txt","fileSize":4,"chunkSize":4}`), "Upload session outside the prefix")
+
+	body := &bytes.Buffer{}
+	form := multipart.NewWriter(body)
+	part, _ := form.CreateFormFile("file", "other/a.txt")
+	part.Write([]byte("data"))
+	form.Close()
+	req := httptest.NewRequest("POST", "/files", body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+tokens["builds"])
+	assert.Equal(t, 403, doRequest(sc, req).Code, "Form upload outside the prefix")
+
+	// WebDAV requests are authenticated too, including the destination of a move
+	assert.Equal(t, 401, request("", "PROPFIND", "/dav/", ""))
+	assert.Equal(t, 201, request(tokens["builds"], "PUT", "/dav/builds/b.txt", "data"))
+	req = httptest.NewRequest("MOVE", "/dav/builds/b.txt", nil)
+	req.Header.Set("Authorization", "Bearer "+tokens["builds"])
+	req.Header.Set("Destination", "/dav/other/b.txt")
+	assert.Equal(t, 403, doRequest(sc, req).Code, "Move outside the prefix")
+}
+
+// Test_GRPC_Auth tests the scopes and name prefixes of keys on the gRPC API
+func Test_GRPC_Auth(t *testing.T) {
+	sc, tokens := getAuthServerConfig(t)
+	defer os.RemoveAll(sc.DataDir)
+
+	conf := getGRPCClient(t, sc)
+	_, err := conf.StatFile("a.txt")
+	assert.EqualError(t, err, "API key required", "Call without a key was accepted")
+
+	conf = getGRPCClient(t, sc, client.WithToken(tokens["reader"]))
+	err = conf.UploadFile("a.txt", strings.NewReader("data"), false)
+	assert.EqualError(t, err, "Permission denied", "Upload without the write scope")
+
+	conf = getGRPCClient(t, sc, client.WithToken(tokens["builds"]))
+	assert.NoError(t, conf.UploadFile("builds/a.txt", strings.NewReader("data"), false))
+	assert.EqualError(t, conf.UploadFile("other/a.txt", strings.NewReader("data"), false), "Permission denied")
+	_, err = conf.ListAllFiles(client.ListOptions{})
+	assert.EqualError(t, err, "Permission denied", "List outside the prefix")
+	_, err = conf.ListAllFiles(client.ListOptions{Prefix: "builds/"})
+	assert.NoError(t, err)
+}
+
+// Test_AuthMiddleware_WebDAVMove tests that MOVE requires deleting the source
+// and COPY reading it, besides writing the destination
+func Test_AuthMiddleware_WebDAVMove(t *testing.T) {
+	sc, tokens := getAuthServerConfig(t)
+	defer os.RemoveAll(sc.DataDir)
+	sc.WebDAVPath = "/dav"
+	tokens["writer"] = addTestKey(t, filepath.Join(sc.DataDir, "keys.json"), "writer", []Scope{ScopeWrite})
+	tokens["copier"] = addTestKey(t, filepath.Join(sc.DataDir, "keys.json"), "copier", []Scope{ScopeRead, ScopeWrite})
+	var err error
+	if sc.APIKeys, err = LoadAPIKeys(filepath.Join(sc.DataDir, "keys.json")); !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.NoError(t, sc.createFile("a.txt", 4, strings.NewReader("data"), false))
+
+	request := func(token, method, dest string) int {
+		req := httptest.NewRequest(method, "/dav/a.txt", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Destination", dest)
+		return doRequest(sc, req).Code
+	}
+
+	assert.Equal(t, 403, request(tokens["writer"], "MOVE", "/dav/b.txt"), "Move without the delete scope")
+	assert.Equal(t, 403, request(tokens["writer"], "COPY", "/dav/b.txt"), "Copy without the read scope")
+	assert.Equal(t, 403, request(tokens["copier"], "MOVE", "/dav/b.txt"), "Move without the delete scope")
+	exists, _ := sc.fileExists("a.txt")
+	assert.True(t, exists, "Source of a denied move deleted")
+
+	assert.Equal(t, 201, request(tokens["copier"], "COPY", "/dav/b.txt"))
+	assert.Equal(t, 403, request(tokens["builds"], "MOVE", "/dav/builds/a.txt"), "Move of a source outside the prefix")
+	assert.Equal(t, 201, request(tokens["admin"], "MOVE", "/dav/c.txt"))
+}
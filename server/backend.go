@@ -0,0 +1,47 @@
+package server
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrNotSupported is returned for operations the storage backend doesn't support
+var ErrNotSupported = errors.New("not supported by the storage backend")
+
+// Backend stores the content and header of files by their name
+type Backend interface {
+	// Put stores the file store and its content, ErrFileAlreadyExists is
+	// returned if the file exists and overwrite isn't set
+	Put(store *FileStore, overwrite bool) error
+
+	// Get opens a file, the returned file store reads the content once and
+	// the returned reader reads and seeks the content, it must be closed
+	Get(fileName string) (FileReader, *FileStore, error)
+
+	// Stat returns the header of a file without its content
+	Stat(fileName string) (*FileStore, error)
+
+	// Delete removes a file
+	Delete(fileName string) error
+
+	// List returns an iterator over the headers of every file
+	List() FileIterator
+}
+
+// FileReader reads and seeks the content of a file opened by a backend
+type FileReader interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+// FileIterator iterates over the files of a backend
+type FileIterator interface {
+	// Next advances to the next file, it returns false after the last file or on an error
+	Next() bool
+
+	// File returns the header of the current file
+	File() *FileStore
+
+	// Err returns the error that stopped the iteration
+	Err() error
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"io"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// backends creates an instance of every backend
+var backends = map[string]func() (Backend, error){
+	"disk": func() (Backend, error) {
+		return NewDiskBackend("../.testdata/.tmp/")
+	},
+	"memory": func() (Backend, error) {
+		return NewMemoryBackend(), nil
+	},
+}
+
+// Test_Backend tests storing, reading, listing and deleting files in every backend
+func Test_Backend(t *testing.T) {
+	for name, newBackend := range backends {
+		t.Run(name, func(t *testing.T) {
+			backend, err := newBackend()
+			if !assert.NoError(t, err, "Error creating backend") {
+				return
+			}
+			defer os.RemoveAll("../.testdata/.tmp/")
+
+			now := time.UnixMilli(time.Now().UnixMilli())
+			for _, fn := range []string{"b.txt", "a.txt"} {
+				err := backend.Put(&FileStore{
+					Version:    FSStoreV2,
+					Reader:     strings.NewReader("0123456789"),
+					FileName:   fn,
+					DataSize:   10,
+					CreatedAt:  now,
+					ModifiedAt: now,
+				}, false)
+				if !assert.NoError(t, err, "Error putting file") {
+					return
+				}
+			}
+
+			err = backend.Put(&FileStore{
+				Version:  FSStoreV2,
+				Reader:   strings.NewReader("x"),
+				FileName: "a.txt",
+				DataSize: 1,
+			}, false)
+			assert.ErrorIs(t, err, ErrFileAlreadyExists, "Existing file was replaced")
+
+			err = backend.Put(&FileStore{
+				Version:  FSStoreV2,
+				Reader:   strings.NewReader("short"),
+				FileName: "short.txt",
+				DataSize: 10,
+			}, false)
+			assert.ErrorIs(t, err, io.ErrUnexpectedEOF, "Short content was stored")
+
+			file, store, err := backend.Get("a.txt")
+			if assert.NoError(t, err, "Error getting file") {
+				data, err := io.ReadAll(store)
+				assert.NoError(t, err, "Error reading content")
+				assert.Equal(t, "0123456789", string(data), "File data is not the same")
+
+				_, err = file.Seek(4, io.SeekStart)
+				assert.NoError(t, err, "Error seeking content")
+				data, err = io.ReadAll(file)
+				assert.NoError(t, err, "Error reading content")
+				assert.Equal(t, "456789", string(data), "Seeked data is not the same")
+				file.Close()
+			}
+
+			store, err = backend.Stat("b.txt")
+			if assert.NoError(t, err, "Error getting header") {
+				assert.Equal(t, int64(10), store.DataSize)
+				assert.True(t, now.Equal(store.CreatedAt), "Creation time is not the same")
+				assert.NotEmpty(t, store.ETag())
+			}
+
+			names := []string{}
+			files := backend.List()
+			for files.Next() {
+				names = append(names, files.File().FileName)
+			}
+			assert.NoError(t, files.Err(), "Error listing files")
+			assert.ElementsMatch(t, []string{"a.txt", "b.txt"}, names)
+
+			assert.NoError(t, backend.Delete("a.txt"), "Error deleting file")
+			assert.ErrorIs(t, backend.Delete("a.txt"), ErrFileDoesntExist)
+			_, _, err = backend.Get("a.txt")
+			assert.ErrorIs(t, err, ErrFileDoesntExist)
+			_, err = backend.Stat("a.txt")
+			assert.ErrorIs(t, err, ErrFileDoesntExist)
+		})
+	}
+}
+
+// Test_DiskBackend_ListDeleted tests that files deleted while listing are skipped
+func Test_DiskBackend_ListDeleted(t *testing.T) {
+	backend, err := NewDiskBackend("../.testdata/.tmp/")
+	if !assert.NoError(t, err, "Error creating backend") {
+		return
+	}
+	defer os.RemoveAll("../.testdata/.tmp/")
+
+	for _, fn := range []string{"a.txt", "b.txt"} {
+		err := backend.Put(&FileStore{
+			Version:  FSStoreV2,
+			Reader:   strings.NewReader("data"),
+			FileName: fn,
+			DataSize: 4,
+		}, false)
+		if !assert.NoError(t, err, "Error putting file") {
+			return
+		}
+	}
+
+	files := backend.List()
+	assert.NoError(t, backend.Delete("a.txt"), "Error deleting file")
+	names := []string{}
+	for files.Next() {
+		names = append(names, files.File().FileName)
+	}
+	assert.NoError(t, files.Err(), "Deleted file stopped the listing")
+	assert.Equal(t, []string{"b.txt"}, names)
+}
+
+// Test_MemoryBackend_Routes tests the file routes without a data directory
+func Test_MemoryBackend_Routes(t *testing.T) {
+	sc, err := NewServerConfigWithBackend(":8080", "", NewMemoryBackend(), 10, "error")
+	if !assert.NoError(t, err, "Error creating server config") {
+		return
+	}
+
+	rec := doRequest(sc, httptest.NewRequest("PUT", "/files/dir/a.txt", strings.NewReader("0123")))
+	assert.Equal(t, 200, rec.Code, "Unexpected status code")
+
+	req := httptest.NewRequest("GET", "/files/dir/a.txt", nil)
+	req.Header.Set("Range", "bytes=1-2")
+	rec = doRequest(sc, req)
+	assert.Equal(t, 206, rec.Code, "Unexpected status code")
+	assert.Equal(t, "12", rec.Body.String(), "Unexpected range content")
+
+	rec = doRequest(sc, httptest.NewRequest("GET", "/files", nil))
+	assert.Equal(t, 200, rec.Code, "Unexpected status code")
+	assert.Contains(t, rec.Body.String(), `"fileName":"dir/a.txt"`)
+
+	rec = doRequest(sc, httptest.NewRequest("POST", "/admin/fsck", nil))
+	assert.Equal(t, 501, rec.Code, "Checking files is only supported on disk")
+
+	rec = doRequest(sc, httptest.NewRequest("DELETE", "/files?filename=dir/a.txt", nil))
+	assert.Equal(t, 200, rec.Code, "Unexpected status code")
+	rec = doRequest(sc, httptest.NewRequest("GET", "/files/dir/a.txt", nil))
+	assert.Equal(t, 404, rec.Code, "Unexpected status code for deleted file")
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"io"
+	"os"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DiskBackend stores every file in its own file in a data directory, named by
+// the md5 of the file name and arranged by the layout of the data directory
+type DiskBackend struct {
+	Dir    string
+	Layout Layout
+}
+
+// NewDiskBackend creates a disk backend on a data directory, using the layout
+// recorded in the data directory
+func NewDiskBackend(dataDir string) (*DiskBackend, error) {
+	if err := os.MkdirAll(dataDir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	// remove partial writes from a previous run
+	if err := cleanupTempFiles(dataDir); err != nil {
+		return nil, err
+	}
+
+	layout, err := readLayout(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	return &DiskBackend{Dir: dataDir, Layout: layout}, nil
+}
+
+// Put writes the file store to its file, replacing it atomically
+func (b *DiskBackend) Put(store *FileStore, overwrite bool) error {
+	return store.createFileAt(b.Dir, b.Layout, overwrite)
+}
+
+// Get opens the file of a file name
+func (b *DiskBackend) Get(fileName string) (FileReader, *FileStore, error) {
+	file, store, err := openFileAt(b.Dir, b.Layout, fileName)
+	if os.IsNotExist(err) {
+		return nil, nil, ErrFileDoesntExist
+	} else if err != nil {
+		return nil, nil, err
+	}
+	return &diskFileReader{
+		SectionReader: store.contentReader(file),
+		Closer:        file,
+	}, store, nil
+}
+
+// Stat reads the header of the file of a file name
+func (b *DiskBackend) Stat(fileName string) (*FileStore, error) {
+	file, store, err := b.Get(fileName)
+	if err != nil {
+		return nil, err
+	}
+	file.Close()
+	store.Reader = nil
+	return store, nil
+}
+
+// Delete removes the file of a file name
+func (b *DiskBackend) Delete(fileName string) error {
+	err := deleteFileAt(b.Dir, b.Layout, fileName)
+	if os.IsNotExist(err) {
+		return ErrFileDoesntExist
+	}
+	return err
+}
+
+// List iterates over the files of the data directory, files that can't be
+// parsed or aren't stored at their path are skipped, fsck reports them
+func (b *DiskBackend) List() FileIterator {
+	paths, err := storeFilePaths(b.Dir)
+	return &diskIterator{backend: b, paths: paths, err: err}
+}
+
+// diskFileReader reads the content section of an open file
+type diskFileReader struct {
+	*io.SectionReader
+	io.Closer
+}
+
+// diskIterator iterates over the file paths of a data directory
+type diskIterator struct {
+	backend *DiskBackend
+	paths   []string
+	store   *FileStore
+	err     error
+}
+
+// Next parses the header of the next valid file
+func (it *diskIterator) Next() bool {
+	for it.err == nil && len(it.paths) > 0 {
+		path := it.paths[0]
+		it.paths = it.paths[1:]
+
+		file, err := os.Open(path)
+		if os.IsNotExist(err) {
+			// deleted since the paths were listed
+			continue
+		} else if err != nil {
+			it.err = err
+			return false
+		}
+		store, err := parseFileStore(file)
+		file.Close()
+
+		if err != nil || path != it.backend.Layout.filePath(it.backend.Dir, store.FileName) {
+			logrus.Warn("Skipping invalid file: ", path)
+			continue
+		}
+		store.Reader = nil
+		it.store = store
+		return true
+	}
+	return false
+}
+
+// File returns the header of the current file
+func (it *diskIterator) File() *FileStore {
+	return it.store
+}
+
+// Err returns the error that stopped the iteration
+func (it *diskIterator) Err() error {
+	return it.err
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package server
+
+import "golang.org/x/sys/unix"
+
+// diskFree returns the bytes available to the process on the file system of the directory
+func diskFree(dir string) (uint64, error) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(dir, &stat); err != nil {
+		return 0, err
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
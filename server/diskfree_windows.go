@@ -0,0 +1,19 @@
+//go:build windows
+// +build windows
+
+package server
+
+import "golang.org/x/sys/windows"
+
+// diskFree returns the bytes available to the process on the file system of the directory
+func diskFree(dir string) (uint64, error) {
+	path, err := windows.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, err
+	}
+	var free uint64
+	if err := windows.GetDiskFreeSpaceEx(path, &free, nil, nil); err != nil {
+		return 0, err
+	}
+	return free, nil
+}
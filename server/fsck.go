@@ -0,0 +1,218 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "fs-store/types"
+)
+
+// lostFoundDirName is the directory in the data directory bad files are moved to
+const lostFoundDirName = "lost+found"
+
+// FsckOptions configures a check of the data directory
+type FsckOptions struct {
+	// Quarantine moves bad files into the lost+found directory together with the report
+	Quarantine bool
+
+	// lock is called with the name of a file before it is checked, the returned
+	// function releases the lock, this is used when the server is running
+	lock func(fileName string) func()
+}
+
+// Fsck checks the header, name, content length and checksum of every file in
+// the data directory and returns a report of the files with problems
+func Fsck(dataDir string, opts FsckOptions) (*FsckReport, error) {
+	layout, err := readLayout(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	paths, err := storeFilePaths(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &FsckReport{
+		CheckedAt: time.Now(),
+		Issues:    []FsckIssue{},
+	}
+	for _, path := range paths {
+		issue, err := fsckFile(dataDir, layout, path, opts)
+		if err != nil {
+			return nil, err
+		}
+		report.Checked++
+		if issue != nil {
+			report.Issues = append(report.Issues, *issue)
+		}
+	}
+
+	if opts.Quarantine && len(report.Issues) > 0 {
+		if err := writeFsckReport(dataDir, report); err != nil {
+			return nil, err
+		}
+
+		// the server rebuilds the index from the remaining files on start
+		if opts.lock == nil {
+			if err := os.Remove(filepath.Join(dataDir, indexFileName)); err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+		}
+	}
+	return report, nil
+}
+
+// fsck checks the data directory while the server is running, only the disk
+// backend can be checked
+func (sc *ServerConfig) fsck(quarantine bool) (*FsckReport, error) {
+	disk, ok := sc.Backend.(*DiskBackend)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+
+	report, err := Fsck(disk.Dir, FsckOptions{
+		Quarantine: quarantine,
+		lock: func(fileName string) func() {
+			mutex := sc.acquireLock(fileName)
+			return func() { sc.releaseLock(fileName, mutex) }
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// remove quarantined files from the index, unless a valid file with the name exists
+	for _, issue := range report.Issues {
+		if !issue.Quarantined || issue.FileName == "" {
+			continue
+		}
+		if exists, err := sc.fileExists(issue.FileName); err != nil {
+			return nil, err
+		} else if !exists {
+			if err := sc.index.delete(issue.FileName); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return report, nil
+}
+
+// fsckFile checks and quarantines a single file, returning nil if there are no problems
+func fsckFile(dataDir string, layout Layout, path string, opts FsckOptions) (*FsckIssue, error) {
+	issue := &FsckIssue{Path: path}
+
+	store, err := checkFileStore(dataDir, layout, path, nil)
+	if store != nil {
+		issue.FileName = store.FileName
+	}
+
+	// check again while holding the lock, the file might have been replaced
+	if opts.lock != nil && store != nil {
+		unlock := opts.lock(store.FileName)
+		defer unlock()
+		store, err = checkFileStore(dataDir, layout, path, store)
+	}
+	if err == nil || os.IsNotExist(err) {
+		return nil, nil
+	}
+	issue.Problem = err.Error()
+
+	// files without a valid header have no name to lock, while the server runs
+	// an upload could replace them with a valid file before they are moved
+	if opts.Quarantine && (opts.lock == nil || store != nil) {
+		if issue.QuarantinePath, err = quarantineFile(dataDir, path); err != nil {
+			return nil, err
+		}
+		issue.Quarantined = issue.QuarantinePath != ""
+	}
+	return issue, nil
+}
+
+// checkFileStore validates a file, if expected is set the file must still
+// belong to the same name
+func checkFileStore(dataDir string, layout Layout, path string, expected *FileStore) (*FileStore, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	store, err := parseFileStore(file)
+	if err != nil {
+		return nil, fmt.Errorf("invalid header: %w", err)
+	}
+	if expected != nil && expected.FileName != store.FileName {
+		return store, fmt.Errorf("file name changed while checking")
+	}
+
+	if filepath.Base(path) != generateFileName(store.FileName) {
+		return store, fmt.Errorf("file name %q doesn't match %s",
+			store.FileName, filepath.Base(path))
+	}
+	if path != layout.filePath(dataDir, store.FileName) {
+		return store, fmt.Errorf("file isn't stored at its path in the %s layout", layout)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return store, err
+	}
+	expectedSize := store.headerSize() + store.DataSize
+	if store.Version >= FSStoreV2 {
+		expectedSize += sha256.Size
+	}
+	if info.Size() != expectedSize {
+		return store, fmt.Errorf("file size %d doesn't match expected size %d",
+			info.Size(), expectedSize)
+	}
+
+	// reading the content verifies the checksum of V2 files
+	if _, err := io.Copy(io.Discard, store); err != nil {
+		return store, fmt.Errorf("invalid content: %w", err)
+	}
+	return store, nil
+}
+
+// quarantineFile moves a file into the lost+found directory and returns its
+// path there, empty if the file doesn't exist anymore, the name is suffixed
+// so earlier quarantined copies of the file are kept
+func quarantineFile(dataDir, path string) (string, error) {
+	lostFound := filepath.Join(dataDir, lostFoundDirName)
+	if err := os.MkdirAll(lostFound, os.ModePerm); err != nil {
+		return "", err
+	}
+
+	target := filepath.Join(lostFound, fmt.Sprintf("%s.%d", filepath.Base(path), time.Now().UnixNano()))
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(target); os.IsNotExist(err) {
+			break
+		} else if err != nil {
+			return "", err
+		}
+		target = filepath.Join(lostFound, fmt.Sprintf("%s.%d-%d", filepath.Base(path), time.Now().UnixNano(), i))
+	}
+
+	err := os.Rename(path, target)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return target, nil
+}
+
+// writeFsckReport writes the report as json into the lost+found directory
+func writeFsckReport(dataDir string, report *FsckReport) error {
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	name := "fsck-" + report.CheckedAt.UTC().Format("20060102T150405Z") + ".json"
+	return os.WriteFile(filepath.Join(dataDir, lostFoundDirName, name), b, 0644)
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test_Fsck tests the detection and quarantine of bad files
+func Test_Fsck(t *testing.T) {
+	sc := getServerConfig(t)
+	defer os.RemoveAll(sc.DataDir)
+
+	data := "test data"
+	for _, fn := range []string{"fsck_ok.txt", "fsck_truncated.txt", "fsck_renamed.txt", "fsck_checksum.txt"} {
+		err := sc.createFile(fn, int64(len(data)), strings.NewReader(data), false)
+		if !assert.NoError(t, err, "Error creating file") {
+			return
+		}
+	}
+
+	// Truncate a file
+	truncatedPath := filepath.Join(sc.DataDir, generateFileName("fsck_truncated.txt"))
+	assert.NoError(t, os.Truncate(truncatedPath, 20))
+
+	// Move a file to a path that doesn't match its name
+	renamedPath := filepath.Join(sc.DataDir, generateFileName("fsck_other.txt"))
+	assert.NoError(t, os.Rename(filepath.Join(sc.DataDir, generateFileName("fsck_renamed.txt")), renamedPath))
+
+	// Corrupt the content of a file
+	checksumPath := filepath.Join(sc.DataDir, generateFileName("fsck_checksum.txt"))
+	b, err := os.ReadFile(checksumPath)
+	if assert.NoError(t, err) {
+		b[len(b)-sha256.Size-1] ^= 0xff
+		assert.NoError(t, os.WriteFile(checksumPath, b, 0644))
+	}
+
+	report, err := sc.fsck(false)
+	if assert.NoError(t, err, "Error checking files") {
+		assert.Equal(t, 4, report.Checked)
+		assert.Len(t, report.Issues, 3)
+	}
+
+	report, err = Fsck(sc.DataDir, FsckOptions{Quarantine: true})
+	if assert.NoError(t, err, "Error checking files") {
+		assert.Len(t, report.Issues, 3)
+		for _, issue := range report.Issues {
+			assert.True(t, issue.Quarantined, "File was not quarantined")
+			assert.NoFileExists(t, issue.Path, "File was not moved")
+			assert.FileExists(t, issue.QuarantinePath)
+			assert.True(t, strings.HasPrefix(filepath.Base(issue.QuarantinePath), filepath.Base(issue.Path)+"."),
+				"Quarantined file has no suffix")
+		}
+	}
+
+	// a file quarantined again doesn't replace the earlier copy
+	assert.NoError(t, os.WriteFile(truncatedPath, []byte("invalid"), 0644))
+	report, err = Fsck(sc.DataDir, FsckOptions{Quarantine: true})
+	if assert.NoError(t, err, "Error checking files") && assert.Len(t, report.Issues, 1) {
+		entries, err := os.ReadDir(filepath.Join(sc.DataDir, lostFoundDirName))
+		assert.NoError(t, err)
+		copies := 0
+		for _, entry := range entries {
+			if strings.HasPrefix(entry.Name(), filepath.Base(truncatedPath)+".") {
+				copies++
+			}
+		}
+		assert.Equal(t, 2, copies, "Earlier quarantined copy was replaced")
+	}
+
+	// files with an invalid header aren't quarantined while the server runs
+	assert.NoError(t, os.WriteFile(truncatedPath, []byte("invalid"), 0644))
+	report, err = sc.fsck(true)
+	if assert.NoError(t, err, "Error checking files") && assert.Len(t, report.Issues, 1) {
+		assert.False(t, report.Issues[0].Quarantined, "File without a lockable name was quarantined")
+		assert.FileExists(t, truncatedPath)
+	}
+	assert.NoError(t, os.Remove(truncatedPath))
+
+	// Only the good file is left
+	report, err = Fsck(sc.DataDir, FsckOptions{})
+	if assert.NoError(t, err, "Error checking files") {
+		assert.Equal(t, 1, report.Checked)
+		assert.Empty(t, report.Issues)
+	}
+}
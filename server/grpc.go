@@ -0,0 +1,431 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"fs-store/rpc"
+	. "fs-store/types"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// grpcChunkSize is the size of the content chunks streamed to clients
+const grpcChunkSize = 64 * 1024
+
+// grpcServer implements the gRPC API with the server config
+type grpcServer struct {
+	rpc.UnimplementedFileStoreServer
+
+	sc *ServerConfig
+}
+
+// grpcMethodScopes are the scopes the methods of the service require
+var grpcMethodScopes = map[string]Scope{
+	"/fsstore.v1.FileStore/List":     ScopeRead,
+	"/fsstore.v1.FileStore/Download": ScopeRead,
+	"/fsstore.v1.FileStore/Stat":     ScopeRead,
+	"/fsstore.v1.FileStore/Upload":   ScopeWrite,
+	"/fsstore.v1.FileStore/Delete":   ScopeDelete,
+}
+
+// grpcAPIKey is the context key of the authenticated API key
+type grpcAPIKey struct{}
+
+// newGRPCServer creates the gRPC server with the file store service registered
+func (sc *ServerConfig) newGRPCServer() *grpc.Server {
+	unary := []grpc.UnaryServerInterceptor{grpcLogUnary}
+	stream := []grpc.StreamServerInterceptor{grpcLogStream}
+	if sc.APIKeys != nil {
+		unary = append(unary, sc.grpcAuthUnary)
+		stream = append(stream, sc.grpcAuthStream)
+	}
+
+	opts := []grpc.ServerOption{grpc.ChainUnaryInterceptor(unary...), grpc.ChainStreamInterceptor(stream...)}
+	if sc.TLS != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(sc.TLS.serverConfig())))
+	}
+	s := grpc.NewServer(opts...)
+	rpc.RegisterFileStoreServer(s, &grpcServer{sc: sc})
+	return s
+}
+
+// startGRPCServer listens on the gRPC address and serves the gRPC API
+func (sc *ServerConfig) startGRPCServer(s *grpc.Server) error {
+	lis, err := net.Listen("tcp", sc.GRPCAddress)
+	if err != nil {
+		return err
+	}
+	return s.Serve(lis)
+}
+
+// grpcLogUnary logs unary calls like the request logger of the REST API
+func grpcLogUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	resp, err := handler(ctx, req)
+	logrus.WithFields(logrus.Fields{
+		"method": info.FullMethod,
+		"status": status.Code(err).String(),
+	}).Info("grpc request")
+	return resp, err
+}
+
+// grpcLogStream logs streaming calls like the request logger of the REST API
+func grpcLogStream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	err := handler(srv, ss)
+	logrus.WithFields(logrus.Fields{
+		"method": info.FullMethod,
+		"status": status.Code(err).String(),
+	}).Info("grpc request")
+	return err
+}
+
+// grpcAuthenticate authenticates a call with the bearer API key of its
+// metadata and returns the context with the key
+func (sc *ServerConfig) grpcAuthenticate(ctx context.Context, method string) (context.Context, error) {
+	authorization := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get("authorization")) > 0 {
+		authorization = md.Get("authorization")[0]
+	}
+	var state *tls.ConnectionState
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			state = &info.State
+		}
+	}
+	key, err := sc.authenticate(authorization, state)
+	if err == errMissingAPIKey {
+		return nil, status.Error(codes.Unauthenticated, "API key required")
+	} else if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "Invalid API key")
+	}
+
+	scope, ok := grpcMethodScopes[method]
+	if !ok {
+		scope = ScopeAdmin
+	}
+	if !key.hasScope(scope) {
+		return nil, status.Error(codes.PermissionDenied, "Permission denied")
+	}
+	return context.WithValue(ctx, grpcAPIKey{}, key), nil
+}
+
+// grpcAuthUnary authenticates unary calls
+func (sc *ServerConfig) grpcAuthUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := sc.grpcAuthenticate(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// grpcAuthStream authenticates streaming calls
+func (sc *ServerConfig) grpcAuthStream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := sc.grpcAuthenticate(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &grpcAuthStream{ServerStream: ss, ctx: ctx})
+}
+
+// grpcAuthStream is a server stream with the context of the authenticated call
+type grpcAuthStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *grpcAuthStream) Context() context.Context {
+	return s.ctx
+}
+
+// grpcAllows checks if the API key of the call allows the action on the
+// file name, every action is allowed without authentication
+func (sc *ServerConfig) grpcAllows(ctx context.Context, action Scope, name string) error {
+	if key, ok := ctx.Value(grpcAPIKey{}).(*APIKey); ok && !sc.keyAllows(key, action, name) {
+		return status.Error(codes.PermissionDenied, "Permission denied")
+	}
+	return nil
+}
+
+// grpcError converts a server error to a gRPC status with the message of the REST API
+func grpcError(err error) error {
+	switch err {
+	case ErrFileDoesntExist:
+		return status.Error(codes.NotFound, "File doesn't exist")
+	case ErrFileAlreadyExists:
+		return status.Error(codes.AlreadyExists, "File already exists")
+	case io.ErrUnexpectedEOF:
+		return status.Error(codes.InvalidArgument, "Incomplete file content")
+	case ErrInvalidSort:
+		return status.Error(codes.InvalidArgument, "Invalid sort")
+	case ErrInvalidOrder:
+		return status.Error(codes.InvalidArgument, "Invalid order")
+	case ErrInvalidDelimiter:
+		return status.Error(codes.InvalidArgument, "Delimiter requires sorting by name")
+	case ErrFileTooLarge:
+		return status.Error(codes.InvalidArgument, "File too large")
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	logrus.Error("Error in gRPC call ", err)
+	return status.Error(codes.Internal, "Internal server error")
+}
+
+// grpcFileInfo converts the information of a file to its message
+func grpcFileInfo(file FileResponse) *rpc.FileInfo {
+	return &rpc.FileInfo{
+		FileName:    file.FileName,
+		FileSize:    file.FileSize,
+		CreatedAt:   timestamppb.New(file.CreatedAt),
+		ModifiedAt:  timestamppb.New(file.ModifiedAt),
+		ContentType: file.ContentType,
+		Metadata:    file.Metadata,
+	}
+}
+
+// validFileName checks a file name like the file routes
+func validFileName(fileName string) error {
+	if fileName == "" {
+		return status.Error(codes.InvalidArgument, "Invalid file name")
+	}
+	if len(fileName) > 255 {
+		return status.Error(codes.InvalidArgument, "File name too long")
+	}
+	return nil
+}
+
+// List streams every page of the file list
+func (s *grpcServer) List(req *rpc.ListRequest, stream rpc.FileStore_ListServer) error {
+	// the prefix is checked against the prefixes of the key, the ACL policy filters the entries
+	key, ok := stream.Context().Value(grpcAPIKey{}).(*APIKey)
+	if ok && !key.allowsName(req.Prefix) {
+		return status.Error(codes.PermissionDenied, "Permission denied")
+	}
+	opts := listOptions{
+		Prefix:    req.Prefix,
+		Sort:      ListSort(req.Sort),
+		Order:     ListOrder(req.Order),
+		Delimiter: req.Delimiter,
+		Limit:     s.sc.MaxListSize,
+	}
+	if ok {
+		opts.visible = s.sc.listFilter(key)
+	}
+	for {
+		page, err := s.sc.listFiles(opts)
+		if err != nil {
+			return grpcError(err)
+		}
+		for _, prefix := range page.CommonPrefixes {
+			err := stream.Send(&rpc.ListResponse{Entry: &rpc.ListResponse_CommonPrefix{CommonPrefix: prefix}})
+			if err != nil {
+				return err
+			}
+		}
+		for _, file := range page.Files {
+			err := stream.Send(&rpc.ListResponse{Entry: &rpc.ListResponse_File{File: grpcFileInfo(file)}})
+			if err != nil {
+				return err
+			}
+		}
+		if page.NextCursor == "" {
+			return nil
+		}
+		opts.Cursor = page.NextCursor
+	}
+}
+
+// grpcUploadReader reads the chunks of an upload stream, failing if the client
+// sends more than the size of the file. A negative size reads until the stream ends
+type grpcUploadReader struct {
+	stream    rpc.FileStore_UploadServer
+	remaining int64
+	chunk     []byte
+}
+
+func (r *grpcUploadReader) Read(p []byte) (int, error) {
+	for len(r.chunk) == 0 {
+		if r.remaining == 0 {
+			return 0, io.EOF
+		}
+		req, err := r.stream.Recv()
+		if err == io.EOF && r.remaining < 0 {
+			return 0, io.EOF
+		} else if err == io.EOF {
+			return 0, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return 0, err
+		}
+		r.chunk = req.GetChunk()
+		if r.remaining < 0 {
+			continue
+		}
+		if int64(len(r.chunk)) > r.remaining {
+			return 0, status.Error(codes.InvalidArgument, "File content larger than the file size")
+		}
+		r.remaining -= int64(len(r.chunk))
+
+		// the file is only stored once the client ended the stream
+		if r.remaining == 0 {
+			if _, err := r.stream.Recv(); err != io.EOF {
+				return 0, status.Error(codes.InvalidArgument, "File content larger than the file size")
+			}
+		}
+	}
+	n := copy(p, r.chunk)
+	r.chunk = r.chunk[n:]
+	return n, nil
+}
+
+// Upload stores a file from the header and the chunks following it
+func (s *grpcServer) Upload(stream rpc.FileStore_UploadServer) error {
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	header := req.GetHeader()
+	if header == nil {
+		return status.Error(codes.InvalidArgument, "Upload must start with a header")
+	}
+	if err := validFileName(header.FileName); err != nil {
+		return err
+	}
+	if err := s.sc.grpcAllows(stream.Context(), ScopeWrite, header.FileName); err != nil {
+		return err
+	}
+
+	// content of unknown size is spooled to the staging directory first
+	size := header.FileSize
+	var content io.Reader = &grpcUploadReader{stream: stream, remaining: size}
+	if size < 0 {
+		spool, spoolSize, err := s.sc.spoolUpload(content)
+		if err != nil {
+			return grpcError(err)
+		}
+		defer os.Remove(spool.Name())
+		defer spool.Close()
+		size, content = spoolSize, spool
+	}
+	if size == 0 {
+		return status.Error(codes.InvalidArgument, "File is empty")
+	}
+	if size > s.sc.MaxFileSize {
+		return status.Error(codes.InvalidArgument, "File too large")
+	}
+
+	logrus.Info("Uploading file over gRPC: ", header.FileName)
+	store := &FileStore{
+		FileName:    header.FileName,
+		DataSize:    size,
+		Reader:      content,
+		ContentType: header.ContentType,
+		Metadata:    header.Metadata,
+	}
+	if err := s.sc.createFileStore(grpcActor(stream.Context()), store, header.Overwrite); err != nil {
+		return grpcError(err)
+	}
+	return stream.SendAndClose(grpcFileInfo(fileResponse(store)))
+}
+
+// Download streams the information of a file and the chunks of the requested range
+func (s *grpcServer) Download(req *rpc.DownloadRequest, stream rpc.FileStore_DownloadServer) error {
+	if err := validFileName(req.FileName); err != nil {
+		return err
+	}
+	if err := s.sc.grpcAllows(stream.Context(), ScopeRead, req.FileName); err != nil {
+		return err
+	}
+
+	file, store, err := s.sc.openFile(req.FileName)
+	if err != nil {
+		return grpcError(err)
+	}
+	defer file.Close()
+
+	length := req.Length
+	if req.Offset < 0 || length < 0 || req.Offset > store.DataSize {
+		return status.Error(codes.OutOfRange, "Invalid range")
+	}
+	if length == 0 || req.Offset+length > store.DataSize {
+		length = store.DataSize - req.Offset
+	}
+	if _, err := file.Seek(req.Offset, io.SeekStart); err != nil {
+		return grpcError(err)
+	}
+
+	info := &rpc.DownloadResponse{Data: &rpc.DownloadResponse_Info{Info: grpcFileInfo(fileResponse(store))}}
+	if err := stream.Send(info); err != nil {
+		return err
+	}
+
+	buf := make([]byte, grpcChunkSize)
+	r := io.LimitReader(file, length)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			chunk := &rpc.DownloadResponse{Data: &rpc.DownloadResponse_Chunk{Chunk: buf[:n]}}
+			if err := stream.Send(chunk); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		} else if err != nil {
+			return grpcError(err)
+		}
+	}
+}
+
+// Delete deletes a file, or every file under the prefix
+func (s *grpcServer) Delete(ctx context.Context, req *rpc.DeleteRequest) (*rpc.DeleteResponse, error) {
+	if req.Prefix != "" {
+		if !strings.HasSuffix(req.Prefix, "/") || strings.Trim(req.Prefix, "/") == "" {
+			return nil, status.Error(codes.InvalidArgument, "Prefix must be a directory ending with /")
+		}
+		if err := s.sc.grpcAllows(ctx, ScopeDelete, req.Prefix); err != nil {
+			return nil, err
+		}
+		deleted, err := s.sc.deleteFiles(grpcActor(ctx), req.Prefix)
+		if err != nil {
+			return nil, grpcError(err)
+		}
+		return &rpc.DeleteResponse{Deleted: deleted}, nil
+	}
+
+	if err := validFileName(req.FileName); err != nil {
+		return nil, err
+	}
+	if err := s.sc.grpcAllows(ctx, ScopeDelete, req.FileName); err != nil {
+		return nil, err
+	}
+	if err := s.sc.deleteFile(grpcActor(ctx), req.FileName); err != nil {
+		return nil, grpcError(err)
+	}
+	return &rpc.DeleteResponse{Deleted: []string{req.FileName}}, nil
+}
+
+// Stat returns the information of a file from its header
+func (s *grpcServer) Stat(ctx context.Context, req *rpc.StatRequest) (*rpc.FileInfo, error) {
+	if err := validFileName(req.FileName); err != nil {
+		return nil, err
+	}
+	if err := s.sc.grpcAllows(ctx, ScopeRead, req.FileName); err != nil {
+		return nil, err
+	}
+	store, err := s.sc.Backend.Stat(req.FileName)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return grpcFileInfo(fileResponse(store)), nil
+}
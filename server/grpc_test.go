@@ -0,0 +1,164 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"fs-store/client"
+	"fs-store/rpc"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// getGRPCClient serves the gRPC API of the server config in memory and returns a client for it
+func getGRPCClient(t *testing.T, sc *ServerConfig, opts ...grpc.DialOption) *client.GRPCClientConfig {
+	lis := bufconn.Listen(1024 * 1024)
+	s := sc.newGRPCServer()
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conf, err := client.NewGRPCClientConfig("bufnet", false, append(opts,
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+	)...)
+	if !assert.NoError(t, err, "Error creating gRPC client") {
+		t.FailNow()
+	}
+	t.Cleanup(func() { conf.Close() })
+	return conf
+}
+
+// Test_GRPC_Files tests uploading, downloading, listing and deleting files over gRPC
+func Test_GRPC_Files(t *testing.T) {
+	sc := getServerConfig(t)
+	defer os.RemoveAll(sc.DataDir)
+	sc.MaxFileSize = 1 << 20
+	conf := getGRPCClient(t, sc)
+
+	// larger than a chunk so the content is streamed in several messages
+	data := strings.Repeat("0123456789", 10000)
+	assert.NoError(t, conf.UploadFile("dir/a.txt", strings.NewReader(data), false), "Error uploading file")
+	assert.NoError(t, conf.UploadFile("dir/sub/b.txt", strings.NewReader("b"), false), "Error uploading file")
+	assert.EqualError(t, conf.UploadFile("dir/a.txt", strings.NewReader("x"), false), "File already exists")
+
+	buf := &bytes.Buffer{}
+	assert.NoError(t, conf.DownloadFile("dir/a.txt", buf), "Error downloading file")
+	assert.Equal(t, data, buf.String(), "File data is not the same")
+	assert.EqualError(t, conf.DownloadFile("missing.txt", io.Discard), "File doesn't exist")
+
+	file, err := conf.StatFile("dir/a.txt")
+	if assert.NoError(t, err, "Error getting file information") {
+		assert.Equal(t, int64(len(data)), file.FileSize)
+		assert.Equal(t, "text/plain; charset=utf-8", file.ContentType)
+	}
+
+	prefixes, files, err := conf.ListDirectory("dir/")
+	if assert.NoError(t, err, "Error listing directory") {
+		assert.Equal(t, []string{"dir/sub/"}, prefixes)
+		if assert.Len(t, files, 1) {
+			assert.Equal(t, "dir/a.txt", files[0].FileName)
+		}
+	}
+
+	files, err = conf.ListAllFiles(client.ListOptions{Sort: "size"})
+	if assert.NoError(t, err, "Error listing files") && assert.Len(t, files, 2) {
+		assert.Equal(t, "dir/sub/b.txt", files[0].FileName)
+	}
+
+	assert.NoError(t, conf.DeleteFile("dir/a.txt"), "Error deleting file")
+	assert.EqualError(t, conf.DeleteFile("dir/a.txt"), "File doesn't exist")
+
+	// the root isn't a directory which can be deleted
+	for _, prefix := range []string{"/", "//"} {
+		_, err = conf.DeleteDirectory(prefix)
+		assert.EqualError(t, err, "Prefix must be a directory ending with /", "Root deleted with %s", prefix)
+	}
+	exists, err := sc.fileExists("dir/sub/b.txt")
+	assert.NoError(t, err)
+	assert.True(t, exists, "File deleted with the root")
+
+	deleted, err := conf.DeleteDirectory("dir/")
+	assert.NoError(t, err, "Error deleting directory")
+	assert.Equal(t, []string{"dir/sub/b.txt"}, deleted)
+}
+
+// Test_GRPC_UploadSize tests that uploads must match the size in the header
+func Test_GRPC_UploadSize(t *testing.T) {
+	sc := getServerConfig(t)
+	defer os.RemoveAll(sc.DataDir)
+	conf := getGRPCClient(t, sc)
+
+	upload := func(size int64, chunks ...string) error {
+		stream, err := conf.Client.Upload(context.Background())
+		if err != nil {
+			return err
+		}
+		stream.Send(&rpc.UploadRequest{Data: &rpc.UploadRequest_Header{
+			Header: &rpc.UploadHeader{FileName: "a.txt", FileSize: size},
+		}})
+		for _, chunk := range chunks {
+			stream.Send(&rpc.UploadRequest{Data: &rpc.UploadRequest_Chunk{Chunk: []byte(chunk)}})
+		}
+		_, err = stream.CloseAndRecv()
+		return err
+	}
+
+	assert.Equal(t, codes.InvalidArgument, status.Code(upload(4, "01")), "Short upload was stored")
+	assert.Equal(t, codes.InvalidArgument, status.Code(upload(4, "0123", "4")), "Long upload was stored")
+	assert.Equal(t, codes.InvalidArgument, status.Code(upload(11, "01234567890")), "Large upload was stored")
+	exists, err := sc.fileExists("a.txt")
+	assert.NoError(t, err)
+	assert.False(t, exists, "Invalid upload was stored")
+
+	assert.NoError(t, upload(4, "01", "23"), "Error uploading file")
+
+	// Ranges are streamed from the offset
+	stream, err := conf.Client.Download(context.Background(), &rpc.DownloadRequest{FileName: "a.txt", Offset: 1, Length: 2})
+	if assert.NoError(t, err) {
+		resp, err := stream.Recv()
+		if assert.NoError(t, err) {
+			assert.Equal(t, int64(4), resp.GetInfo().GetFileSize())
+		}
+		resp, err = stream.Recv()
+		if assert.NoError(t, err) {
+			assert.Equal(t, "12", string(resp.GetChunk()))
+		}
+	}
+}
+
+// Test_GRPC_UploadUnknownSize tests uploads which don't send the size upfront
+func Test_GRPC_UploadUnknownSize(t *testing.T) {
+	sc := getServerConfig(t)
+	defer os.RemoveAll(sc.DataDir)
+	conf := getGRPCClient(t, sc)
+
+	// the reader can't report its size or seek
+	assert.NoError(t, conf.UploadFile("a.txt", io.MultiReader(strings.NewReader("0123456789")), false))
+	file, store, err := sc.openFile("a.txt")
+	if assert.NoError(t, err) {
+		defer file.Close()
+		data, err := io.ReadAll(store)
+		assert.NoError(t, err)
+		assert.Equal(t, "0123456789", string(data))
+	}
+
+	err = conf.UploadFile("large.txt", io.MultiReader(strings.NewReader("01234567890")), false)
+	assert.EqualError(t, err, "File too large")
+	err = conf.UploadFile("empty.txt", io.MultiReader(strings.NewReader("")), false)
+	assert.EqualError(t, err, "File is empty")
+
+	entries, err := os.ReadDir(filepath.Join(sc.DataDir, uploadDirName))
+	assert.NoError(t, err)
+	assert.Empty(t, entries, "Spooled content left behind")
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	. "fs-store/types"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+)
+
+// BuildInfo is the version and revision the binary was built from
+type BuildInfo struct {
+	Version  string
+	Revision string
+}
+
+// healthzRoute reports that the process is alive
+func healthzRoute(sc *ServerConfig) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return c.JSON(200, GenericResponse{
+			Success: true,
+			Message: "OK",
+		})
+	}
+}
+
+// readyzRoute reports if the server can store files
+func readyzRoute(sc *ServerConfig) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ready := sc.readiness()
+		if !ready.Success {
+			logrus.WithField("checks", ready.Checks).Warn("Server not ready")
+			return c.JSON(503, ready)
+		}
+		return c.JSON(200, ready)
+	}
+}
+
+// infoRoute returns the version and limits of the server
+func infoRoute(sc *ServerConfig) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return c.JSON(200, sc.info())
+	}
+}
+
+// info returns the version and limits of the server
+func (sc *ServerConfig) info() *InfoResponse {
+	version := sc.storeVersion()
+	info := &InfoResponse{
+		Version:       sc.Build.Version,
+		Revision:      sc.Build.Revision,
+		MaxFileSize:   sc.MaxFileSize,
+		MaxListSize:   sc.MaxListSize,
+		FormatVersion: int(version),
+	}
+	for _, v := range SupportedVersions {
+		info.FormatVersions = append(info.FormatVersions, int(v))
+	}
+	return info
+}
+
+// readiness checks that the index is loaded, the data directory is writable
+// and its file system has the minimum free space, a shutting down server
+// isn't ready
+func (sc *ServerConfig) readiness() *ReadinessResponse {
+	checks := []ReadinessCheck{
+		{Name: "index", OK: sc.index != nil},
+		{Name: "dataDir", OK: true},
+		{Name: "freeSpace", OK: true},
+	}
+	if !checks[0].OK {
+		checks[0].Message = "Index not loaded"
+	}
+	if err := sc.checkWritable(); err != nil {
+		checks[1].OK = false
+		checks[1].Message = err.Error()
+	}
+	if free, err := diskFree(sc.DataDir); err != nil {
+		checks[2].OK = false
+		checks[2].Message = err.Error()
+	} else if free < uint64(sc.MinFreeSpace) {
+		checks[2].OK = false
+		checks[2].Message = fmt.Sprintf("%d bytes free, %d required", free, sc.MinFreeSpace)
+	}
+
+	if sc.isDraining() {
+		checks = append(checks, ReadinessCheck{Name: "shutdown", OK: false, Message: "Server shutting down"})
+	}
+
+	ready := &ReadinessResponse{Success: true, Checks: checks}
+	for _, check := range checks {
+		ready.Success = ready.Success && check.OK
+	}
+	return ready
+}
+
+// checkWritable writes and removes a file in the staging directory of the data directory
+func (sc *ServerConfig) checkWritable() error {
+	dir := filepath.Join(sc.DataDir, uploadDirName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	temp, err := os.CreateTemp(dir, tempFilePrefix+"*")
+	if err != nil {
+		return err
+	}
+	_, err = temp.Write([]byte("ok"))
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if removeErr := os.Remove(temp.Name()); err == nil {
+		err = removeErr
+	}
+	return err
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"math"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"fs-store/client"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test_Probes tests the health, readiness and info routes with authentication
+func Test_Probes(t *testing.T) {
+	sc, tokens := getAuthServerConfig(t)
+	defer os.RemoveAll(sc.DataDir)
+	sc.Build = BuildInfo{Version: "v1.2.3", Revision: "abc123"}
+
+	// probes don't need a key
+	assert.Equal(t, 200, doRequest(sc, httptest.NewRequest("GET", "/healthz", nil)).Code)
+	assert.Equal(t, 200, doRequest(sc, httptest.NewRequest("GET", "/readyz", nil)).Code)
+	assert.Equal(t, 401, doRequest(sc, httptest.NewRequest("GET", "/info", nil)).Code, "Info served without a key")
+
+	ts := httptest.NewServer(sc.newEcho())
+	defer ts.Close()
+	conf, err := client.NewFSClientConfig(ts.URL, false)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	conf.SetToken(tokens["reader"])
+
+	info, err := conf.Info()
+	if assert.NoError(t, err) {
+		assert.Equal(t, "v1.2.3", info.Version)
+		assert.Equal(t, "abc123", info.Revision)
+		assert.Equal(t, sc.MaxFileSize, info.MaxFileSize)
+		assert.Equal(t, sc.MaxListSize, info.MaxListSize)
+		assert.Equal(t, []int{1, 2}, info.FormatVersions)
+		assert.Equal(t, int(DefaultVersion), info.FormatVersion)
+	}
+
+	ready, err := conf.Ready()
+	if assert.NoError(t, err) {
+		assert.True(t, ready.Success)
+		assert.Len(t, ready.Checks, 3)
+	}
+
+	// not enough free space
+	sc.MinFreeSpace = math.MaxInt64
+	ready, err = conf.Ready()
+	if assert.NoError(t, err) {
+		assert.False(t, ready.Success, "Ready without free space")
+		assert.Equal(t, "freeSpace", ready.Checks[2].Name)
+		assert.False(t, ready.Checks[2].OK)
+		assert.NotEmpty(t, ready.Checks[2].Message)
+	}
+	assert.Equal(t, 503, doRequest(sc, httptest.NewRequest("GET", "/readyz", nil)).Code)
+}
+
+// Test_Probes_NotWritable tests readiness of a data directory which can't be written
+func Test_Probes_NotWritable(t *testing.T) {
+	sc := getServerConfig(t)
+	defer os.RemoveAll(sc.DataDir)
+
+	// a file in place of the staging directory can't be written to
+	staging := filepath.Join(sc.DataDir, uploadDirName)
+	assert.NoError(t, os.RemoveAll(staging))
+	assert.NoError(t, os.WriteFile(staging, nil, 0600))
+
+	ready := sc.readiness()
+	assert.False(t, ready.Success)
+	assert.Equal(t, "dataDir", ready.Checks[1].Name)
+	assert.False(t, ready.Checks[1].OK)
+}
@@ -0,0 +1,384 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	. "fs-store/types"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// indexFileName is the metadata index log in the data directory
+	indexFileName = "index.log"
+
+	// indexCompactMin is the number of log records before the log is compacted
+	indexCompactMin = 1024
+)
+
+// errPartialIndex is returned when the last record of the index log was only partially written
+var errPartialIndex = errors.New("partial last index record")
+
+// errIndexClosed is returned when changing the index after its log was closed
+var errIndexClosed = errors.New("index closed")
+
+// indexOp is the operation of an index log record
+type indexOp string
+
+const (
+	indexOpPut    indexOp = "put"
+	indexOpDelete indexOp = "delete"
+)
+
+// indexRecord is a single line of the index log
+type indexRecord struct {
+	Op       indexOp       `json:"op"`
+	FileName string        `json:"fileName"`
+	File     *FileResponse `json:"file,omitempty"`
+}
+
+// fileIndex keeps the metadata of every file in memory, changes are appended
+// to a log in the data directory which is compacted once it grows too large,
+// the index has no log without a data directory
+type fileIndex struct {
+	mtx     *sync.RWMutex
+	path    string
+	log     *os.File
+	records int
+	entries map[string]FileResponse
+	closed  bool
+}
+
+// loadIndex loads the index of a data directory, rebuilding it from the file
+// headers of the backend if the index is missing or unreadable, without a data
+// directory the index is only kept in memory
+func loadIndex(dataDir string, backend Backend) (*fileIndex, error) {
+	idx := &fileIndex{
+		mtx:     &sync.RWMutex{},
+		entries: make(map[string]FileResponse),
+	}
+	if dataDir == "" {
+		return idx, idx.rebuild(backend)
+	}
+	idx.path = filepath.Join(dataDir, indexFileName)
+
+	err := idx.replay()
+	if os.IsNotExist(err) {
+		logrus.Info("Index not found, rebuilding index from files")
+		err = idx.rebuild(backend)
+	} else if err == errPartialIndex {
+		// a crash while appending, the change may not be in the index
+		logrus.Warn("Index has a partial last record, rebuilding index from files")
+		err = idx.rebuild(backend)
+	} else if err != nil {
+		logrus.Warn("Index unreadable, rebuilding index from files: ", err)
+		err = idx.rebuild(backend)
+	} else if !idx.hasETags() {
+		logrus.Info("Index written by an older version, rebuilding index from files")
+		err = idx.rebuild(backend)
+	} else if disk, ok := backend.(*DiskBackend); ok {
+		var inSync bool
+		if inSync, err = idx.inSync(disk); err == nil && !inSync {
+			logrus.Warn("Index doesn't match the data directory, rebuilding index from files")
+			err = idx.rebuild(backend)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// compact on load, the log is rewritten after every rebuild
+	if err := idx.compact(); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// replay reads the index log into memory
+func (idx *fileIndex) replay() error {
+	file, err := os.Open(idx.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 && line[len(line)-1] != '\n' {
+			// a crash while appending leaves a partial last record
+			return errPartialIndex
+		}
+		if err != nil {
+			break
+		}
+
+		var record indexRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return err
+		}
+		idx.apply(record)
+	}
+	return nil
+}
+
+// rebuild replaces the index with the headers of the files in the backend
+func (idx *fileIndex) rebuild(backend Backend) error {
+	idx.entries = make(map[string]FileResponse)
+
+	files := backend.List()
+	for files.Next() {
+		store := files.File()
+		idx.entries[store.FileName] = fileResponse(store)
+	}
+	return files.Err()
+}
+
+// inSync checks the index against the files of a disk backend without reading
+// their headers, the stored files must be the indexed ones and none may have
+// been written after the log, which is appended after every write
+func (idx *fileIndex) inSync(disk *DiskBackend) (bool, error) {
+	logInfo, err := os.Stat(idx.path)
+	if err != nil {
+		return false, err
+	}
+	paths, err := storeFilePaths(disk.Dir)
+	if err != nil {
+		return false, err
+	}
+	if len(paths) != len(idx.entries) {
+		return false, nil
+	}
+
+	indexed := make(map[string]bool, len(idx.entries))
+	for name := range idx.entries {
+		indexed[disk.Layout.filePath(disk.Dir, name)] = true
+	}
+	for _, path := range paths {
+		if !indexed[path] {
+			return false, nil
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return false, err
+		}
+		if info.ModTime().After(logInfo.ModTime()) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// compact rewrites the log with a single record per file
+func (idx *fileIndex) compact() error {
+	tmpPath := idx.path + ".tmp"
+	tmp, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(writer)
+	for _, name := range idx.sortedNames() {
+		file := idx.entries[name]
+		if err := encoder.Encode(indexRecord{Op: indexOpPut, FileName: name, File: &file}); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if idx.log != nil {
+		idx.log.Close()
+	}
+	if err := os.Rename(tmpPath, idx.path); err != nil {
+		return err
+	}
+
+	idx.log, err = os.OpenFile(idx.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	idx.records = len(idx.entries)
+	return nil
+}
+
+// apply applies a record to the in-memory entries
+func (idx *fileIndex) apply(record indexRecord) {
+	switch record.Op {
+	case indexOpPut:
+		if record.File != nil {
+			idx.entries[record.FileName] = *record.File
+		}
+	case indexOpDelete:
+		delete(idx.entries, record.FileName)
+	}
+}
+
+// append applies a record and appends it to the log
+func (idx *fileIndex) append(record indexRecord) error {
+	idx.mtx.Lock()
+	defer idx.mtx.Unlock()
+
+	if idx.closed {
+		return errIndexClosed
+	}
+	idx.apply(record)
+	if idx.log == nil {
+		return nil
+	}
+
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if _, err := idx.log.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	if err := idx.log.Sync(); err != nil {
+		return err
+	}
+
+	idx.records++
+	if idx.records > indexCompactMin && idx.records > 2*len(idx.entries) {
+		return idx.compact()
+	}
+	return nil
+}
+
+// put adds or replaces the metadata of a file
+func (idx *fileIndex) put(store *FileStore) error {
+	file := fileResponse(store)
+	return idx.append(indexRecord{Op: indexOpPut, FileName: store.FileName, File: &file})
+}
+
+// delete removes the metadata of a file
+func (idx *fileIndex) delete(fileName string) error {
+	return idx.append(indexRecord{Op: indexOpDelete, FileName: fileName})
+}
+
+// list returns a page of the metadata of the files
+func (idx *fileIndex) list(opts listOptions) (*FileListResponse, error) {
+	idx.mtx.RLock()
+	files := make([]FileResponse, 0, len(idx.entries))
+	for _, file := range idx.entries {
+		files = append(files, file)
+	}
+	idx.mtx.RUnlock()
+
+	return opts.page(files)
+}
+
+// names returns the sorted names of the files starting with the prefix
+func (idx *fileIndex) names(prefix string) []string {
+	idx.mtx.RLock()
+	defer idx.mtx.RUnlock()
+
+	names := []string{}
+	for _, name := range idx.sortedNames() {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// get returns the metadata of a file
+func (idx *fileIndex) get(fileName string) (FileResponse, bool) {
+	idx.mtx.RLock()
+	defer idx.mtx.RUnlock()
+
+	file, ok := idx.entries[fileName]
+	return file, ok
+}
+
+// stats returns the number of files and their total content size
+func (idx *fileIndex) stats() (int, int64) {
+	idx.mtx.RLock()
+	defer idx.mtx.RUnlock()
+
+	var size int64
+	for _, file := range idx.entries {
+		size += file.FileSize
+	}
+	return len(idx.entries), size
+}
+
+// hasETags checks if every entry has its ETag, older versions didn't index it
+func (idx *fileIndex) hasETags() bool {
+	for _, file := range idx.entries {
+		if file.ETag == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// hasPrefix checks if any file starts with the prefix
+func (idx *fileIndex) hasPrefix(prefix string) bool {
+	idx.mtx.RLock()
+	defer idx.mtx.RUnlock()
+
+	for name := range idx.entries {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedNames returns the file names sorted, the caller must hold the lock
+func (idx *fileIndex) sortedNames() []string {
+	names := make([]string, 0, len(idx.entries))
+	for name := range idx.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// close closes the index log, the index can't be changed afterwards
+func (idx *fileIndex) close() error {
+	idx.mtx.Lock()
+	defer idx.mtx.Unlock()
+	idx.closed = true
+	if idx.log == nil {
+		return nil
+	}
+	err := idx.log.Close()
+	idx.log = nil
+	return err
+}
+
+// fileResponse returns the file information of a file store, times are
+// truncated to milliseconds as they are stored in the header
+func fileResponse(store *FileStore) FileResponse {
+	return FileResponse{
+		FileName:    store.FileName,
+		FileSize:    store.DataSize,
+		CreatedAt:   time.UnixMilli(store.CreatedAt.UnixMilli()),
+		ModifiedAt:  time.UnixMilli(store.ModifiedAt.UnixMilli()),
+		ContentType: store.ContentType,
+		Metadata:    store.Metadata,
+		ETag:        store.ETag(),
+		S3ETag:      store.S3ETag,
+	}
+}
@@ -0,0 +1,182 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "fs-store/types"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// assertSameFiles asserts that two file lists contain the same files
+func assertSameFiles(t *testing.T, expected, actual []FileResponse, msg string) {
+	if !assert.Len(t, actual, len(expected), msg) {
+		return
+	}
+	for i := range expected {
+		assert.Equal(t, expected[i].FileName, actual[i].FileName, msg)
+		assert.Equal(t, expected[i].FileSize, actual[i].FileSize, msg)
+		assert.True(t, expected[i].CreatedAt.Equal(actual[i].CreatedAt), msg)
+		assert.True(t, expected[i].ModifiedAt.Equal(actual[i].ModifiedAt), msg)
+	}
+}
+
+// listAll returns the files of an index sorted by name
+func listAll(t *testing.T, idx *fileIndex) []FileResponse {
+	opts := listOptions{}
+	assert.NoError(t, opts.validate(1000))
+	page, err := idx.list(opts)
+	assert.NoError(t, err, "Error listing index")
+	return page.Files
+}
+
+// Test_Index tests that the index is updated and persisted by create and delete
+func Test_Index(t *testing.T) {
+	sc := getServerConfig(t)
+	defer os.RemoveAll(sc.DataDir)
+
+	for _, fn := range []string{"index_b.txt", "index_a.txt", "index_c.txt"} {
+		err := sc.createFile(fn, int64(len(fn)), strings.NewReader(fn), false)
+		if !assert.NoError(t, err, "Error creating file") {
+			return
+		}
+	}
+	assert.NoError(t, sc.deleteFile(auditActor{}, "index_c.txt"), "Error deleting file")
+
+	files, err := sc.getFileList(10)
+	if assert.NoError(t, err, "Error listing files") && assert.Len(t, files, 2) {
+		assert.Equal(t, "index_a.txt", files[0].FileName, "Files are not sorted")
+		assert.Equal(t, "index_b.txt", files[1].FileName, "Files are not sorted")
+		assert.Equal(t, int64(len("index_a.txt")), files[0].FileSize)
+	}
+
+	// Reloading replays the log
+	idx, err := loadIndex(sc.DataDir, sc.Backend)
+	if assert.NoError(t, err, "Error loading index") {
+		assertSameFiles(t, files, listAll(t, idx), "Reloaded index is not the same")
+		idx.close()
+	}
+}
+
+// Test_Index_Rebuild tests rebuilding the index from the file headers
+func Test_Index_Rebuild(t *testing.T) {
+	sc := getServerConfig(t)
+	defer os.RemoveAll(sc.DataDir)
+
+	for _, fn := range []string{"rebuild_a.txt", "rebuild_b.txt"} {
+		err := sc.createFile(fn, int64(len(fn)), strings.NewReader(fn), false)
+		if !assert.NoError(t, err, "Error creating file") {
+			return
+		}
+	}
+	files, _ := sc.getFileList(10)
+
+	// Missing index
+	indexPath := filepath.Join(sc.DataDir, indexFileName)
+	assert.NoError(t, os.Remove(indexPath))
+	idx, err := loadIndex(sc.DataDir, sc.Backend)
+	if assert.NoError(t, err, "Error rebuilding index") {
+		assertSameFiles(t, files, listAll(t, idx), "Rebuilt index is not the same")
+		idx.close()
+	}
+
+	// Unreadable index
+	assert.NoError(t, os.WriteFile(indexPath, []byte("not json\n"), 0644))
+	idx, err = loadIndex(sc.DataDir, sc.Backend)
+	if assert.NoError(t, err, "Error rebuilding index") {
+		assertSameFiles(t, files, listAll(t, idx), "Rebuilt index is not the same")
+		idx.close()
+	}
+
+	// A partial last record rebuilds the index
+	f, err := os.OpenFile(indexPath, os.O_WRONLY|os.O_APPEND, 0644)
+	if assert.NoError(t, err) {
+		f.WriteString(`{"op":"delete","fileN`)
+		f.Close()
+	}
+	idx, err = loadIndex(sc.DataDir, sc.Backend)
+	if assert.NoError(t, err, "Error loading index") {
+		assertSameFiles(t, files, listAll(t, idx), "Partial record was applied")
+		idx.close()
+	}
+
+	// An index of an older version without ETags
+	record := `{"op":"put","fileName":"rebuild_a.txt","file":{"fileName":"rebuild_a.txt","fileSize":13}}` + "\n"
+	assert.NoError(t, os.WriteFile(indexPath, []byte(record), 0644))
+	idx, err = loadIndex(sc.DataDir, sc.Backend)
+	if assert.NoError(t, err, "Error rebuilding index") {
+		rebuilt := listAll(t, idx)
+		assertSameFiles(t, files, rebuilt, "Rebuilt index is not the same")
+		for _, file := range rebuilt {
+			assert.NotEmpty(t, file.ETag, "ETag not indexed")
+		}
+		idx.close()
+	}
+}
+
+// Test_Index_Reconcile tests that an index which doesn't match the data directory is rebuilt
+func Test_Index_Reconcile(t *testing.T) {
+	sc := getServerConfig(t)
+	defer os.RemoveAll(sc.DataDir)
+
+	for _, fn := range []string{"sync_a.txt", "sync_b.txt"} {
+		err := sc.createFile(fn, int64(len(fn)), strings.NewReader(fn), false)
+		if !assert.NoError(t, err, "Error creating file") {
+			return
+		}
+	}
+	indexPath := filepath.Join(sc.DataDir, indexFileName)
+	past := time.Now().Add(-time.Hour)
+
+	// files written and deleted without updating the index, like a crash before the index record
+	assert.NoError(t, sc.Backend.Put(&FileStore{FileName: "sync_c.txt", DataSize: 1, Reader: strings.NewReader("c")}, false))
+	assert.NoError(t, sc.Backend.Delete("sync_a.txt"))
+	idx, err := loadIndex(sc.DataDir, sc.Backend)
+	if assert.NoError(t, err, "Error loading index") {
+		files := listAll(t, idx)
+		if assert.Len(t, files, 2, "Index not rebuilt") {
+			assert.Equal(t, "sync_b.txt", files[0].FileName)
+			assert.Equal(t, "sync_c.txt", files[1].FileName)
+		}
+		idx.close()
+	}
+
+	// a file overwritten after the last index record
+	assert.NoError(t, os.Chtimes(indexPath, past, past))
+	assert.NoError(t, sc.Backend.Put(&FileStore{FileName: "sync_b.txt", DataSize: 3, Reader: strings.NewReader("new")}, true))
+	idx, err = loadIndex(sc.DataDir, sc.Backend)
+	if assert.NoError(t, err, "Error loading index") {
+		file, ok := idx.get("sync_b.txt")
+		assert.True(t, ok)
+		assert.Equal(t, int64(3), file.FileSize, "Overwritten file not reindexed")
+		idx.close()
+	}
+
+	// the rebuilt index is in sync
+	idx, err = loadIndex(sc.DataDir, sc.Backend)
+	if assert.NoError(t, err, "Error loading index") {
+		inSync, err := idx.inSync(sc.Backend.(*DiskBackend))
+		assert.NoError(t, err)
+		assert.True(t, inSync, "Index out of sync after loading")
+		idx.close()
+	}
+}
+
+// Test_Index_Compact tests that the log is compacted once it grows
+func Test_Index_Compact(t *testing.T) {
+	sc := getServerConfig(t)
+	defer os.RemoveAll(sc.DataDir)
+
+	store := &FileStore{FileName: "compact.txt", DataSize: 1}
+	for i := 0; i <= indexCompactMin; i++ {
+		if !assert.NoError(t, sc.index.put(store)) {
+			return
+		}
+	}
+	assert.LessOrEqual(t, sc.index.records, indexCompactMin, "Log was not compacted")
+	assert.Len(t, listAll(t, sc.index), 1)
+}
@@ -0,0 +1,271 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Layout is how files are arranged in the data directory
+type Layout string
+
+const (
+	// LayoutFlat stores every file directly in the data directory
+	LayoutFlat Layout = "flat"
+
+	// LayoutSharded stores files in ab/cd/ subdirectories taken from the start
+	// of the md5 file name, which keeps directories small with many files
+	LayoutSharded Layout = "sharded"
+
+	// DefaultLayout is the layout of data directories without a layout file
+	DefaultLayout = LayoutFlat
+)
+
+// layoutFileName is the file in the data directory recording its layout
+const layoutFileName = "layout"
+
+// ErrUnknownLayout is returned for an unknown layout
+var ErrUnknownLayout = errors.New("unknown layout")
+
+// Supported returns true if the layout is known
+func (l Layout) Supported() bool {
+	return l == LayoutFlat || l == LayoutSharded
+}
+
+// dir returns the directory a file with the name is stored in
+func (l Layout) dir(dataDir, fileName string) string {
+	if l != LayoutSharded {
+		return dataDir
+	}
+	name := generateFileName(fileName)
+	return filepath.Join(dataDir, name[0:2], name[2:4])
+}
+
+// filePath returns the path a file with the name is stored at
+func (l Layout) filePath(dataDir, fileName string) string {
+	return filepath.Join(l.dir(dataDir, fileName), generateFileName(fileName))
+}
+
+// readLayout returns the layout of a data directory
+func readLayout(dataDir string) (Layout, error) {
+	b, err := os.ReadFile(filepath.Join(dataDir, layoutFileName))
+	if os.IsNotExist(err) {
+		return DefaultLayout, nil
+	} else if err != nil {
+		return "", err
+	}
+
+	layout := Layout(strings.TrimSpace(string(b)))
+	if !layout.Supported() {
+		return "", fmt.Errorf("%w: %q", ErrUnknownLayout, layout)
+	}
+	return layout, nil
+}
+
+// writeLayout records the layout of a data directory
+func writeLayout(dataDir string, layout Layout) error {
+	tmpPath := filepath.Join(dataDir, layoutFileName+".tmp")
+	if err := os.WriteFile(tmpPath, []byte(layout+"\n"), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, filepath.Join(dataDir, layoutFileName)); err != nil {
+		return err
+	}
+	syncDir(dataDir)
+	return nil
+}
+
+// InitLayout sets the layout of a data directory without files, a directory
+// with files must already use the layout and is changed with MigrateLayout
+func InitLayout(dataDir string, layout Layout) error {
+	if !layout.Supported() {
+		return ErrUnknownLayout
+	}
+	if err := os.MkdirAll(dataDir, os.ModePerm); err != nil {
+		return err
+	}
+
+	current, err := readLayout(dataDir)
+	if err != nil {
+		return err
+	}
+	if current == layout {
+		return nil
+	}
+
+	paths, err := storeFilePaths(dataDir)
+	if err != nil {
+		return err
+	}
+	if len(paths) > 0 {
+		return fmt.Errorf("data directory uses the %s layout, migrate it to use the %s layout",
+			current, layout)
+	}
+	return writeLayout(dataDir, layout)
+}
+
+// storeFilePaths returns the paths of the stored files in the data directory
+// and its shard directories, regardless of the layout of the data directory
+func storeFilePaths(dataDir string) ([]string, error) {
+	var paths []string
+	var walk func(dir string, depth int) error
+	walk = func(dir string, depth int) error {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				if depth < 2 && isShardDir(entry.Name()) {
+					if err := walk(filepath.Join(dir, entry.Name()), depth+1); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			if strings.HasSuffix(entry.Name(), ".fs") {
+				paths = append(paths, filepath.Join(dir, entry.Name()))
+			}
+		}
+		return nil
+	}
+
+	if err := walk(dataDir, 0); err != nil {
+		return nil, err
+	}
+	return paths, nil
+}
+
+// isShardDir returns true for the two hex character names of shard directories
+func isShardDir(name string) bool {
+	if len(name) != 2 {
+		return false
+	}
+	for _, c := range name {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// MigrateLayout moves every file in the data directory to its path in the
+// target layout and records the layout once all files were moved. Files are
+// moved with renames, so an interrupted migration can be run again. The server
+// must not be running on the data directory during a migration.
+func MigrateLayout(dataDir string, targetLayout Layout, opts MigrateOptions) (*MigrateResult, error) {
+	if !targetLayout.Supported() {
+		return nil, ErrUnknownLayout
+	}
+
+	paths, err := storeFilePaths(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &MigrateResult{}
+	for i, path := range paths {
+		progress := moveFile(dataDir, path, targetLayout, opts.DryRun)
+		progress.Done = i + 1
+		progress.Total = len(paths)
+
+		switch progress.Status {
+		case MigrateStatusMigrated:
+			result.Migrated++
+		case MigrateStatusSkipped:
+			result.Skipped++
+		case MigrateStatusFailed:
+			result.Failed++
+		}
+
+		if opts.Progress != nil {
+			opts.Progress(progress)
+		}
+	}
+
+	if result.Failed > 0 {
+		return result, fmt.Errorf("%d of %d files failed to migrate", result.Failed, len(paths))
+	}
+	if opts.DryRun {
+		return result, nil
+	}
+
+	if err := writeLayout(dataDir, targetLayout); err != nil {
+		return result, err
+	}
+	if targetLayout != LayoutSharded {
+		removeEmptyShardDirs(dataDir)
+	}
+	return result, nil
+}
+
+// moveFile moves a single file to its path in the target layout
+func moveFile(dataDir, path string, targetLayout Layout, dryRun bool) MigrateProgress {
+	progress := MigrateProgress{Path: path}
+	fail := func(err error) MigrateProgress {
+		progress.Status = MigrateStatusFailed
+		progress.Err = err
+		return progress
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return fail(err)
+	}
+	store, err := parseFileStore(file)
+	file.Close()
+	if err != nil {
+		return fail(err)
+	}
+	progress.FileName = store.FileName
+	progress.FromVersion = store.Version
+
+	if filepath.Base(path) != generateFileName(store.FileName) {
+		return fail(fmt.Errorf("file name %q doesn't match %s", store.FileName, filepath.Base(path)))
+	}
+
+	target := targetLayout.filePath(dataDir, store.FileName)
+	if path == target {
+		progress.Status = MigrateStatusSkipped
+		return progress
+	}
+
+	if !dryRun {
+		dir := targetLayout.dir(dataDir, store.FileName)
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			return fail(err)
+		}
+		if err := os.Rename(path, target); err != nil {
+			return fail(err)
+		}
+		syncDir(dir)
+		syncDir(filepath.Dir(path))
+	}
+
+	progress.Status = MigrateStatusMigrated
+	return progress
+}
+
+// removeEmptyShardDirs removes shard directories without files, errors are
+// ignored since directories that aren't empty are kept
+func removeEmptyShardDirs(dataDir string) {
+	entries, err := os.ReadDir(dataDir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || !isShardDir(entry.Name()) {
+			continue
+		}
+		dir := filepath.Join(dataDir, entry.Name())
+		subEntries, _ := os.ReadDir(dir)
+		for _, sub := range subEntries {
+			if sub.IsDir() {
+				os.Remove(filepath.Join(dir, sub.Name()))
+			}
+		}
+		os.Remove(dir)
+	}
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test_Layout_filePath tests the paths of files in each layout
+func Test_Layout_filePath(t *testing.T) {
+	name := generateFileName("test.txt")
+	assert.Equal(t, filepath.Join("data", name), LayoutFlat.filePath("data", "test.txt"))
+	assert.Equal(t, filepath.Join("data", name[0:2], name[2:4], name),
+		LayoutSharded.filePath("data", "test.txt"))
+}
+
+// Test_ServerConfig_ShardedLayout tests creating, listing and deleting files in the sharded layout
+func Test_ServerConfig_ShardedLayout(t *testing.T) {
+	dataDir := "../.testdata/.tmp/"
+	defer os.RemoveAll(dataDir)
+	if !assert.NoError(t, InitLayout(dataDir, LayoutSharded), "Error setting layout") {
+		return
+	}
+
+	sc := getServerConfig(t)
+	assert.Equal(t, LayoutSharded, sc.Backend.(*DiskBackend).Layout, "Layout not read from the data directory")
+
+	files := []string{"a.txt", "dir/b.txt"}
+	for _, fn := range files {
+		err := sc.createFile(fn, int64(len(fn)), strings.NewReader(fn), false)
+		if !assert.NoError(t, err, "Error creating file") {
+			return
+		}
+		_, err = os.Stat(LayoutSharded.filePath(sc.DataDir, fn))
+		assert.NoError(t, err, "File not stored in its shard directory")
+	}
+
+	// The index is rebuilt from the shard directories
+	sc.index.close()
+	assert.NoError(t, os.Remove(filepath.Join(sc.DataDir, indexFileName)))
+	sc = getServerConfig(t)
+	assert.Equal(t, files, listPages(t, sc, listOptions{Limit: 10}))
+
+	assert.NoError(t, sc.deleteFile(auditActor{}, "a.txt"), "Error deleting file")
+	exists, err := sc.fileExists("a.txt")
+	assert.NoError(t, err)
+	assert.False(t, exists, "File still exists after deletion")
+
+	// The layout of a data directory with files is only changed by migrating
+	assert.Error(t, InitLayout(sc.DataDir, LayoutFlat), "Layout changed with files in the data directory")
+}
+
+// Test_MigrateLayout tests moving files between layouts
+func Test_MigrateLayout(t *testing.T) {
+	sc := getServerConfig(t)
+	defer os.RemoveAll(sc.DataDir)
+
+	files := map[string]string{
+		"layout_test1.txt": "data 1",
+		"dir/test2.txt":    "data 2",
+	}
+	for fn, data := range files {
+		err := sc.createFile(fn, int64(len(data)), strings.NewReader(data), false)
+		if !assert.NoError(t, err, "Error creating file") {
+			return
+		}
+	}
+	sc.index.close()
+
+	result, err := MigrateLayout(sc.DataDir, LayoutSharded, MigrateOptions{DryRun: true})
+	if assert.NoError(t, err, "Error in dry run") {
+		assert.Equal(t, &MigrateResult{Migrated: 2}, result)
+	}
+	layout, err := readLayout(sc.DataDir)
+	assert.NoError(t, err)
+	assert.Equal(t, LayoutFlat, layout, "Dry run changed the layout")
+
+	result, err = MigrateLayout(sc.DataDir, LayoutSharded, MigrateOptions{})
+	if assert.NoError(t, err, "Error migrating") {
+		assert.Equal(t, &MigrateResult{Migrated: 2}, result)
+	}
+
+	sc = getServerConfig(t)
+	assert.Equal(t, LayoutSharded, sc.Backend.(*DiskBackend).Layout, "Layout not recorded")
+	for fn, data := range files {
+		file, store, err := sc.openFile(fn)
+		if assert.NoError(t, err, "Error opening file") {
+			dataBytes, err := io.ReadAll(store)
+			assert.NoError(t, err, "Error reading content from file")
+			assert.Equal(t, data, string(dataBytes), "File data is not the same")
+			file.Close()
+		}
+	}
+	sc.index.close()
+
+	// Running the migration again skips the moved files
+	result, err = MigrateLayout(sc.DataDir, LayoutSharded, MigrateOptions{})
+	if assert.NoError(t, err, "Error migrating again") {
+		assert.Equal(t, &MigrateResult{Skipped: 2}, result)
+	}
+
+	// Migrating back removes the shard directories
+	result, err = MigrateLayout(sc.DataDir, LayoutFlat, MigrateOptions{})
+	if assert.NoError(t, err, "Error migrating back") {
+		assert.Equal(t, &MigrateResult{Migrated: 2}, result)
+	}
+	entries, err := os.ReadDir(sc.DataDir)
+	if assert.NoError(t, err) {
+		for _, entry := range entries {
+			assert.False(t, entry.IsDir() && isShardDir(entry.Name()), "Shard directory not removed")
+		}
+	}
+}
+
+// Test_Fsck_Layout tests that fsck reports files outside of their layout path
+func Test_Fsck_Layout(t *testing.T) {
+	sc := getServerConfig(t)
+	defer os.RemoveAll(sc.DataDir)
+
+	err := sc.createFile("misplaced.txt", 4, strings.NewReader("data"), false)
+	if !assert.NoError(t, err, "Error creating file") {
+		return
+	}
+	assert.NoError(t, writeLayout(sc.DataDir, LayoutSharded))
+
+	report, err := Fsck(sc.DataDir, FsckOptions{})
+	if assert.NoError(t, err, "Error checking files") && assert.Len(t, report.Issues, 1) {
+		assert.Equal(t, "misplaced.txt", report.Issues[0].FileName)
+	}
+}
@@ -0,0 +1,231 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	. "fs-store/types"
+)
+
+// ListSort is the field a file list is sorted by
+type ListSort string
+
+const (
+	ListSortName      ListSort = "name"
+	ListSortSize      ListSort = "size"
+	ListSortCreatedAt ListSort = "createdAt"
+)
+
+// ListOrder is the order a file list is sorted in
+type ListOrder string
+
+const (
+	ListOrderAsc  ListOrder = "asc"
+	ListOrderDesc ListOrder = "desc"
+)
+
+// Define Errors
+var (
+	// ErrInvalidCursor is returned when a cursor can't be decoded or doesn't match the list options
+	ErrInvalidCursor = errors.New("invalid cursor")
+
+	// ErrInvalidSort is returned when listing with an unknown sort field
+	ErrInvalidSort = errors.New("invalid sort")
+
+	// ErrInvalidOrder is returned when listing with an unknown order
+	ErrInvalidOrder = errors.New("invalid order")
+
+	// ErrInvalidDelimiter is returned when listing with a delimiter and not sorting by name
+	ErrInvalidDelimiter = errors.New("delimiter requires sorting by name")
+)
+
+// listOptions are the options for listing a page of files
+type listOptions struct {
+	Limit  int
+	Cursor string
+	Prefix string
+	Sort   ListSort
+	Order  ListOrder
+
+	// Delimiter groups the names containing it after the prefix into common
+	// prefixes, like the directories of a path
+	Delimiter string
+
+	// visible filters the files and common prefixes before the page is cut,
+	// every entry is listed without it
+	visible func(name string, commonPrefix bool) bool
+}
+
+// listCursor is the position after the last file of a page, it's encoded as
+// opaque base64 json and includes the options it's valid for
+type listCursor struct {
+	Sort      ListSort  `json:"s"`
+	Order     ListOrder `json:"o"`
+	Prefix    string    `json:"p,omitempty"`
+	Delimiter string    `json:"d,omitempty"`
+	FileName  string    `json:"n"`
+	FileSize  int64     `json:"z,omitempty"`
+	CreatedAt time.Time `json:"c"`
+}
+
+// validate sets defaults and checks the list options
+func (opts *listOptions) validate(maxLimit int) error {
+	if opts.Limit <= 0 || opts.Limit > maxLimit {
+		opts.Limit = maxLimit
+	}
+	if opts.Sort == "" {
+		opts.Sort = ListSortName
+	}
+	if opts.Order == "" {
+		opts.Order = ListOrderAsc
+	}
+
+	switch opts.Sort {
+	case ListSortName, ListSortSize, ListSortCreatedAt:
+	default:
+		return ErrInvalidSort
+	}
+	switch opts.Order {
+	case ListOrderAsc, ListOrderDesc:
+	default:
+		return ErrInvalidOrder
+	}
+
+	// common prefixes have no size or creation time to sort by
+	if opts.Delimiter != "" && opts.Sort != ListSortName {
+		return ErrInvalidDelimiter
+	}
+	return nil
+}
+
+// less compares two files by the sort field, using the name to break ties
+func (opts *listOptions) less(a, b *FileResponse) bool {
+	var less, equal bool
+	switch opts.Sort {
+	case ListSortSize:
+		less, equal = a.FileSize < b.FileSize, a.FileSize == b.FileSize
+	case ListSortCreatedAt:
+		less, equal = a.CreatedAt.Before(b.CreatedAt), a.CreatedAt.Equal(b.CreatedAt)
+	default:
+		equal = true
+	}
+	if equal {
+		less = a.FileName < b.FileName
+		equal = a.FileName == b.FileName
+	}
+	if opts.Order == ListOrderDesc {
+		return !less && !equal
+	}
+	return less
+}
+
+// page sorts and filters files, returning the page after the cursor
+func (opts *listOptions) page(files []FileResponse) (*FileListResponse, error) {
+	filtered := make([]FileResponse, 0, len(files))
+	prefixes := make(map[string]bool)
+	for _, file := range files {
+		if !strings.HasPrefix(file.FileName, opts.Prefix) {
+			continue
+		}
+		if prefix, ok := opts.commonPrefix(file.FileName); ok {
+			// a common prefix is listed once, in place of the files it contains
+			if !prefixes[prefix] {
+				prefixes[prefix] = true
+				if opts.visible == nil || opts.visible(prefix, true) {
+					filtered = append(filtered, FileResponse{FileName: prefix})
+				}
+			}
+			continue
+		}
+		if opts.visible == nil || opts.visible(file.FileName, false) {
+			filtered = append(filtered, file)
+		}
+	}
+	sort.Slice(filtered, func(i, j int) bool {
+		return opts.less(&filtered[i], &filtered[j])
+	})
+
+	start := 0
+	if opts.Cursor != "" {
+		cursor, err := opts.decodeCursor()
+		if err != nil {
+			return nil, err
+		}
+		after := &FileResponse{
+			FileName:  cursor.FileName,
+			FileSize:  cursor.FileSize,
+			CreatedAt: cursor.CreatedAt,
+		}
+		start = sort.Search(len(filtered), func(i int) bool {
+			return opts.less(after, &filtered[i])
+		})
+	}
+
+	end := start + opts.Limit
+	if end > len(filtered) {
+		end = len(filtered)
+	}
+
+	response := &FileListResponse{
+		Files: make([]FileResponse, 0, end-start),
+	}
+	for _, file := range filtered[start:end] {
+		if prefixes[file.FileName] {
+			response.CommonPrefixes = append(response.CommonPrefixes, file.FileName)
+		} else {
+			response.Files = append(response.Files, file)
+		}
+	}
+	if end < len(filtered) {
+		response.NextCursor = opts.encodeCursor(&filtered[end-1])
+	}
+	return response, nil
+}
+
+// commonPrefix returns the name up to and including the first delimiter after
+// the prefix, if the name contains the delimiter
+func (opts *listOptions) commonPrefix(fileName string) (string, bool) {
+	if opts.Delimiter == "" {
+		return "", false
+	}
+	i := strings.Index(fileName[len(opts.Prefix):], opts.Delimiter)
+	if i < 0 {
+		return "", false
+	}
+	return fileName[:len(opts.Prefix)+i+len(opts.Delimiter)], true
+}
+
+// encodeCursor encodes the position after a file
+func (opts *listOptions) encodeCursor(last *FileResponse) string {
+	b, _ := json.Marshal(listCursor{
+		Sort:      opts.Sort,
+		Order:     opts.Order,
+		Prefix:    opts.Prefix,
+		Delimiter: opts.Delimiter,
+		FileName:  last.FileName,
+		FileSize:  last.FileSize,
+		CreatedAt: last.CreatedAt,
+	})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeCursor decodes the cursor, which must match the list options
+func (opts *listOptions) decodeCursor() (*listCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(opts.Cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var cursor listCursor
+	if err := json.Unmarshal(b, &cursor); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if cursor.Sort != opts.Sort || cursor.Order != opts.Order || cursor.Prefix != opts.Prefix ||
+		cursor.Delimiter != opts.Delimiter {
+		return nil, ErrInvalidCursor
+	}
+	return &cursor, nil
+}
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// listPages lists every page of files and returns the file names
+func listPages(t *testing.T, sc *ServerConfig, opts listOptions) []string {
+	names := []string{}
+	for {
+		page, err := sc.listFiles(opts)
+		if !assert.NoError(t, err, "Error listing files") {
+			return names
+		}
+		assert.LessOrEqual(t, len(page.Files), opts.Limit, "Page is larger than the limit")
+		for _, file := range page.Files {
+			names = append(names, file.FileName)
+		}
+		if page.NextCursor == "" {
+			return names
+		}
+		opts.Cursor = page.NextCursor
+	}
+}
+
+// Test_ServerConfig_listFiles tests paginated, sorted and filtered listings
+func Test_ServerConfig_listFiles(t *testing.T) {
+	sc := getServerConfig(t)
+	defer os.RemoveAll(sc.DataDir)
+
+	for i, fn := range []string{"b/3.txt", "a/1.txt", "a/2.txt", "c.txt", "a/0.txt"} {
+		data := strings.Repeat("x", 5-i)
+		err := sc.createFile(fn, int64(len(data)), strings.NewReader(data), false)
+		if !assert.NoError(t, err, "Error creating file") {
+			return
+		}
+	}
+
+	for _, test := range []struct {
+		opts     listOptions
+		expected []string
+	}{
+		{listOptions{Limit: 2}, []string{"a/0.txt", "a/1.txt", "a/2.txt", "b/3.txt", "c.txt"}},
+		{listOptions{Limit: 2, Order: ListOrderDesc}, []string{"c.txt", "b/3.txt", "a/2.txt", "a/1.txt", "a/0.txt"}},
+		{listOptions{Limit: 3, Sort: ListSortSize}, []string{"a/0.txt", "c.txt", "a/2.txt", "a/1.txt", "b/3.txt"}},
+		{listOptions{Limit: 2, Prefix: "a/"}, []string{"a/0.txt", "a/1.txt", "a/2.txt"}},
+		{listOptions{Limit: 1, Prefix: "d"}, []string{}},
+	} {
+		t.Run(fmt.Sprintf("%+v", test.opts), func(t *testing.T) {
+			assert.Equal(t, test.expected, listPages(t, sc, test.opts))
+		})
+	}
+}
+
+// Test_ServerConfig_listFiles_CreatedAt tests sorting by creation time
+func Test_ServerConfig_listFiles_CreatedAt(t *testing.T) {
+	sc := getServerConfig(t)
+	defer os.RemoveAll(sc.DataDir)
+
+	now := time.Now()
+	for i, fn := range []string{"b.txt", "c.txt", "a.txt"} {
+		store := &FileStore{FileName: fn, DataSize: 1, CreatedAt: now.Add(time.Duration(i) * time.Second)}
+		if !assert.NoError(t, sc.index.put(store)) {
+			return
+		}
+	}
+
+	assert.Equal(t, []string{"b.txt", "c.txt", "a.txt"},
+		listPages(t, sc, listOptions{Limit: 1, Sort: ListSortCreatedAt}))
+	assert.Equal(t, []string{"a.txt", "c.txt", "b.txt"},
+		listPages(t, sc, listOptions{Limit: 2, Sort: ListSortCreatedAt, Order: ListOrderDesc}))
+}
+
+// Test_ServerConfig_listFiles_Invalid tests the validation of list options
+func Test_ServerConfig_listFiles_Invalid(t *testing.T) {
+	sc := getServerConfig(t)
+	defer os.RemoveAll(sc.DataDir)
+
+	for _, fn := range []string{"a.txt", "b.txt"} {
+		err := sc.createFile(fn, int64(len(fn)), strings.NewReader(fn), false)
+		if !assert.NoError(t, err, "Error creating file") {
+			return
+		}
+	}
+
+	_, err := sc.listFiles(listOptions{Sort: "other"})
+	assert.ErrorIs(t, err, ErrInvalidSort)
+
+	_, err = sc.listFiles(listOptions{Order: "other"})
+	assert.ErrorIs(t, err, ErrInvalidOrder)
+
+	_, err = sc.listFiles(listOptions{Cursor: "not a cursor"})
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+
+	// A cursor is only valid for the options it was created with
+	page, err := sc.listFiles(listOptions{Limit: 1})
+	if assert.NoError(t, err) && assert.NotEmpty(t, page.NextCursor) {
+		_, err = sc.listFiles(listOptions{Limit: 1, Cursor: page.NextCursor, Sort: ListSortSize})
+		assert.ErrorIs(t, err, ErrInvalidCursor)
+	}
+}
+
+// Test_ServerConfig_listFiles_Delimiter tests grouping names into common prefixes
+func Test_ServerConfig_listFiles_Delimiter(t *testing.T) {
+	sc := getServerConfig(t)
+	defer os.RemoveAll(sc.DataDir)
+
+	for _, fn := range []string{"a/1.txt", "a/b/2.txt", "a/b/3.txt", "a/c/4.txt", "b.txt", "d/5.txt"} {
+		err := sc.createFile(fn, int64(len(fn)%10), strings.NewReader(fn[:len(fn)%10]), false)
+		if !assert.NoError(t, err, "Error creating file") {
+			return
+		}
+	}
+
+	for _, test := range []struct {
+		opts     listOptions
+		prefixes []string
+		files    []string
+	}{
+		{listOptions{Limit: 10, Delimiter: "/"}, []string{"a/", "d/"}, []string{"b.txt"}},
+		{listOptions{Limit: 10, Delimiter: "/", Prefix: "a/"}, []string{"a/b/", "a/c/"}, []string{"a/1.txt"}},
+		{listOptions{Limit: 10, Delimiter: "/", Prefix: "a/b/"}, nil, []string{"a/b/2.txt", "a/b/3.txt"}},
+		{listOptions{Limit: 10, Delimiter: "/", Order: ListOrderDesc}, []string{"d/", "a/"}, []string{"b.txt"}},
+	} {
+		t.Run(fmt.Sprintf("%+v", test.opts), func(t *testing.T) {
+			page, err := sc.listFiles(test.opts)
+			if !assert.NoError(t, err, "Error listing files") {
+				return
+			}
+			assert.Equal(t, test.prefixes, page.CommonPrefixes, "Unexpected common prefixes")
+			names := []string{}
+			for _, file := range page.Files {
+				names = append(names, file.FileName)
+			}
+			assert.Equal(t, test.files, names, "Unexpected files")
+		})
+	}
+
+	// Common prefixes count towards the limit and are paginated with the files
+	opts := listOptions{Limit: 1, Delimiter: "/"}
+	entries := []string{}
+	for {
+		page, err := sc.listFiles(opts)
+		if !assert.NoError(t, err, "Error listing files") {
+			return
+		}
+		assert.Equal(t, 1, len(page.Files)+len(page.CommonPrefixes), "Unexpected page size")
+		entries = append(entries, page.CommonPrefixes...)
+		for _, file := range page.Files {
+			entries = append(entries, file.FileName)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		opts.Cursor = page.NextCursor
+	}
+	assert.Equal(t, []string{"a/", "b.txt", "d/"}, entries)
+
+	_, err := sc.listFiles(listOptions{Delimiter: "/", Sort: ListSortSize})
+	assert.ErrorIs(t, err, ErrInvalidDelimiter)
+}
+
+// Test_ServerConfig_deleteFiles tests deleting every file under a prefix
+func Test_ServerConfig_deleteFiles(t *testing.T) {
+	sc := getServerConfig(t)
+	defer os.RemoveAll(sc.DataDir)
+
+	for _, fn := range []string{"a/1.txt", "a/b/2.txt", "ab.txt"} {
+		err := sc.createFile(fn, 1, strings.NewReader("x"), false)
+		if !assert.NoError(t, err, "Error creating file") {
+			return
+		}
+	}
+
+	deleted, err := sc.deleteFiles(auditActor{}, "a/")
+	assert.NoError(t, err, "Error deleting files")
+	assert.Equal(t, []string{"a/1.txt", "a/b/2.txt"}, deleted)
+	assert.Equal(t, []string{"ab.txt"}, listPages(t, sc, listOptions{Limit: 10}))
+
+	exists, err := sc.fileExists("a/1.txt")
+	assert.NoError(t, err)
+	assert.False(t, exists, "File still exists after deleting its directory")
+}
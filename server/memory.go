@@ -0,0 +1,153 @@
+package server
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"sort"
+	"sync"
+)
+
+// MemoryBackend keeps files in memory, it's used for tests and embedding
+// the store without a data directory
+type MemoryBackend struct {
+	mtx   *sync.RWMutex
+	files map[string]*memoryFile
+}
+
+// memoryFile is the header and content of a file in memory
+type memoryFile struct {
+	store FileStore
+	data  []byte
+}
+
+// NewMemoryBackend creates an empty memory backend
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		mtx:   &sync.RWMutex{},
+		files: make(map[string]*memoryFile),
+	}
+}
+
+// Put reads the content of the file store into memory
+func (b *MemoryBackend) Put(store *FileStore, overwrite bool) error {
+	if !overwrite {
+		if _, err := b.Stat(store.FileName); err == nil {
+			return ErrFileAlreadyExists
+		}
+	}
+
+	data := make([]byte, store.DataSize)
+	if _, err := io.ReadFull(store.Reader, data); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	if store.S3ETag == "" {
+		contentMD5 := md5.Sum(data)
+		store.S3ETag = `"` + hex.EncodeToString(contentMD5[:]) + `"`
+	}
+	file := &memoryFile{store: *store, data: data}
+	file.store.Reader = nil
+	if store.Version >= FSStoreV2 {
+		checksum := sha256.Sum256(data)
+		file.store.Checksum = checksum[:]
+		store.Checksum = file.store.Checksum
+	}
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if _, exists := b.files[store.FileName]; exists && !overwrite {
+		return ErrFileAlreadyExists
+	}
+	b.files[store.FileName] = file
+	return nil
+}
+
+// Get returns a reader for the content of a file
+func (b *MemoryBackend) Get(fileName string) (FileReader, *FileStore, error) {
+	b.mtx.RLock()
+	file, ok := b.files[fileName]
+	b.mtx.RUnlock()
+	if !ok {
+		return nil, nil, ErrFileDoesntExist
+	}
+
+	store := file.store
+	store.Reader = bytes.NewReader(file.data)
+	return memoryFileReader{bytes.NewReader(file.data)}, &store, nil
+}
+
+// Stat returns the header of a file
+func (b *MemoryBackend) Stat(fileName string) (*FileStore, error) {
+	b.mtx.RLock()
+	defer b.mtx.RUnlock()
+	file, ok := b.files[fileName]
+	if !ok {
+		return nil, ErrFileDoesntExist
+	}
+	store := file.store
+	return &store, nil
+}
+
+// Delete removes a file
+func (b *MemoryBackend) Delete(fileName string) error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if _, ok := b.files[fileName]; !ok {
+		return ErrFileDoesntExist
+	}
+	delete(b.files, fileName)
+	return nil
+}
+
+// List iterates over the files sorted by name at the time of the call
+func (b *MemoryBackend) List() FileIterator {
+	b.mtx.RLock()
+	defer b.mtx.RUnlock()
+
+	stores := make([]FileStore, 0, len(b.files))
+	for _, file := range b.files {
+		stores = append(stores, file.store)
+	}
+	sort.Slice(stores, func(i, j int) bool {
+		return stores[i].FileName < stores[j].FileName
+	})
+	return &memoryIterator{stores: stores, pos: -1}
+}
+
+// memoryFileReader reads the content of a file in memory
+type memoryFileReader struct {
+	*bytes.Reader
+}
+
+// Close does nothing, the content stays in memory
+func (memoryFileReader) Close() error {
+	return nil
+}
+
+// memoryIterator iterates over a snapshot of the files
+type memoryIterator struct {
+	stores []FileStore
+	pos    int
+}
+
+// Next advances to the next file
+func (it *memoryIterator) Next() bool {
+	it.pos++
+	return it.pos < len(it.stores)
+}
+
+// File returns the header of the current file
+func (it *memoryIterator) File() *FileStore {
+	return &it.stores[it.pos]
+}
+
+// Err always returns nil, listing files in memory can't fail
+func (it *memoryIterator) Err() error {
+	return nil
+}
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsNamespace is the prefix of every metric name
+const metricsNamespace = "fs_store"
+
+// serverMetrics are the Prometheus metrics of a server config, each server
+// config has its own registry
+type serverMetrics struct {
+	registry *prometheus.Registry
+
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	uploadedBytes   prometheus.Counter
+	deletedBytes    prometheus.Counter
+	lockWait        prometheus.Histogram
+	activeUploads   prometheus.Gauge
+	listDuration    prometheus.Histogram
+}
+
+// newServerMetrics creates and registers the metrics of the server config
+func newServerMetrics(sc *ServerConfig) *serverMetrics {
+	m := &serverMetrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests by API, route, method and status.",
+		}, []string{"api", "route", "method", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "HTTP request latencies by API, route and method.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"api", "route", "method"}),
+		uploadedBytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "uploaded_bytes_total",
+			Help:      "Content bytes of stored files.",
+		}),
+		deletedBytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "deleted_bytes_total",
+			Help:      "Content bytes of deleted files.",
+		}),
+		lockWait: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "lock_wait_seconds",
+			Help:      "Time spent waiting for file locks.",
+			Buckets:   []float64{.0001, .001, .01, .1, 1, 10},
+		}),
+		activeUploads: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "active_uploads",
+			Help:      "Files being stored.",
+		}),
+		listDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "list_scan_duration_seconds",
+			Help:      "Time spent scanning the index for listings.",
+			Buckets:   []float64{.0001, .001, .01, .1, 1},
+		}),
+	}
+
+	m.registry.MustRegister(
+		m.requests, m.requestDuration, m.uploadedBytes, m.deletedBytes,
+		m.lockWait, m.activeUploads, m.listDuration,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "files",
+			Help:      "Number of stored files.",
+		}, func() float64 {
+			count, _ := sc.index.stats()
+			return float64(count)
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "stored_bytes",
+			Help:      "Content bytes of the stored files.",
+		}, func() float64 {
+			_, size := sc.index.stats()
+			return float64(size)
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "upload_sessions",
+			Help:      "Open resumable upload sessions.",
+		}, func() float64 {
+			return float64(sc.countUploadSessions())
+		}),
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// countUploadSessions returns the number of upload session directories
+func (sc *ServerConfig) countUploadSessions() int {
+	entries, err := os.ReadDir(filepath.Join(sc.DataDir, uploadDirName))
+	if err != nil {
+		return 0
+	}
+	count := 0
+	for _, entry := range entries {
+		if entry.IsDir() {
+			count++
+		}
+	}
+	return count
+}
+
+// metricsMiddleware counts requests and observes their latency by the route
+// they matched, requests matching no route are counted together
+func (sc *ServerConfig) metricsMiddleware(api string) echo.MiddlewareFunc {
+	davPrefix := ""
+	if sc.WebDAVPath != "" {
+		davPrefix = path.Clean("/" + sc.WebDAVPath)
+	}
+
+	// routes are registered after the middleware, echo sets the path of
+	// requests matching no route to the request path
+	var once sync.Once
+	routes := map[string]bool{}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			start := time.Now()
+			err := next(c)
+
+			once.Do(func() {
+				for _, r := range c.Echo().Routes() {
+					routes[r.Path] = true
+				}
+			})
+			route := c.Path()
+			if p := c.Request().URL.Path; davPrefix != "" && (p == davPrefix || strings.HasPrefix(p, davPrefix+"/")) {
+				route = davPrefix
+			} else if !routes[route] {
+				route = "unmatched"
+			}
+			status := c.Response().Status
+			if he, ok := err.(*echo.HTTPError); ok {
+				status = he.Code
+			} else if err != nil && !c.Response().Committed {
+				status = http.StatusInternalServerError
+			}
+
+			method := c.Request().Method
+			sc.metrics.requests.WithLabelValues(api, route, method, strconv.Itoa(status)).Inc()
+			sc.metrics.requestDuration.WithLabelValues(api, route, method).Observe(time.Since(start).Seconds())
+			return err
+		}
+	}
+}
+
+// metricsHandler serves the metrics in the Prometheus text format
+func (sc *ServerConfig) metricsHandler() echo.HandlerFunc {
+	return echo.WrapHandler(promhttp.HandlerFor(sc.metrics.registry, promhttp.HandlerOpts{}))
+}
+
+// newMetricsEcho creates the echo instance of the separate metrics listener,
+// which also serves the probes
+func (sc *ServerConfig) newMetricsEcho() *echo.Echo {
+	e := echo.New()
+
+	// Hide initial messages
+	e.HideBanner = true
+	e.HidePort = true
+
+	e.GET("/metrics", sc.metricsHandler())
+	e.GET("/healthz", healthzRoute(sc))
+	e.GET("/readyz", readyzRoute(sc))
+	return e
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test_Metrics tests the request, byte and file metrics served at /metrics
+func Test_Metrics(t *testing.T) {
+	sc := getServerConfig(t)
+	defer os.RemoveAll(sc.DataDir)
+
+	doRequest(sc, httptest.NewRequest("PUT", "/files/a.txt", strings.NewReader("0123")))
+	doRequest(sc, httptest.NewRequest("PUT", "/files/b.txt", strings.NewReader("012345")))
+	doRequest(sc, httptest.NewRequest("PUT", "/files/b.txt", strings.NewReader("012345")))
+	doRequest(sc, httptest.NewRequest("DELETE", "/files?filename=a.txt", nil))
+	doRequest(sc, httptest.NewRequest("GET", "/files", nil))
+	doRequest(sc, httptest.NewRequest("GET", "/missing", nil))
+
+	rec := doRequest(sc, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, 200, rec.Code)
+	body := rec.Body.String()
+	for _, line := range []string{
+		`fs_store_http_requests_total{api="rest",method="PUT",route="/files/*",status="200"} 2`,
+		`fs_store_http_requests_total{api="rest",method="PUT",route="/files/*",status="409"} 1`,
+		`fs_store_http_requests_total{api="rest",method="GET",route="unmatched",status="404"} 1`,
+		`fs_store_http_request_duration_seconds_count{api="rest",method="DELETE",route="/files"} 1`,
+		`fs_store_uploaded_bytes_total 10`,
+		`fs_store_deleted_bytes_total 4`,
+		`fs_store_files 1`,
+		`fs_store_stored_bytes 6`,
+		`fs_store_active_uploads 0`,
+		`fs_store_list_scan_duration_seconds_count 1`,
+		`fs_store_lock_wait_seconds_count 4`,
+	} {
+		assert.Contains(t, body, line+"\n")
+	}
+}
+
+// Test_Metrics_Listener tests serving the metrics on a separate listener only
+func Test_Metrics_Listener(t *testing.T) {
+	sc, tokens := getAuthServerConfig(t)
+	defer os.RemoveAll(sc.DataDir)
+	request := func(token string) int {
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		return doRequest(sc, req).Code
+	}
+
+	assert.Equal(t, 403, request(tokens["reader"]), "Metrics served without the admin scope")
+	assert.Equal(t, 200, request(tokens["admin"]))
+
+	sc.MetricsAddress = "127.0.0.1:0"
+	assert.Equal(t, 404, request(tokens["admin"]), "Metrics served on the main listener")
+	rec := httptest.NewRecorder()
+	sc.newMetricsEcho().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, 200, rec.Code)
+	assert.Contains(t, rec.Body.String(), "fs_store_files 0\n")
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// MigrateStatus is the outcome of migrating a single file
+type MigrateStatus string
+
+const (
+	// MigrateStatusMigrated is used for files rewritten in the target version
+	MigrateStatusMigrated MigrateStatus = "migrated"
+
+	// MigrateStatusSkipped is used for files already in the target version
+	MigrateStatusSkipped MigrateStatus = "skipped"
+
+	// MigrateStatusFailed is used for files that couldn't be migrated
+	MigrateStatusFailed MigrateStatus = "failed"
+)
+
+// MigrateOptions configures a migration
+type MigrateOptions struct {
+	// DryRun reports what would be migrated without writing anything
+	DryRun bool
+
+	// Progress is called after each file is processed
+	Progress func(MigrateProgress)
+}
+
+// MigrateProgress describes a processed file during a migration
+type MigrateProgress struct {
+	Path        string
+	FileName    string
+	FromVersion FSVersion
+	Status      MigrateStatus
+	Err         error
+	Done        int
+	Total       int
+}
+
+// MigrateResult summarizes a migration
+type MigrateResult struct {
+	Migrated int
+	Skipped  int
+	Failed   int
+}
+
+// Migrate rewrites every file in the data directory in the target version.
+// Files are replaced atomically and files already in the target version are
+// skipped, so an interrupted migration can be run again. The server must not
+// be running on the data directory during a migration.
+func Migrate(dataDir string, targetVersion FSVersion, opts MigrateOptions) (*MigrateResult, error) {
+	if !targetVersion.Supported() {
+		return nil, ErrUnknownVersion
+	}
+
+	// remove temp files of an interrupted migration
+	if !opts.DryRun {
+		if err := cleanupTempFiles(dataDir); err != nil {
+			return nil, err
+		}
+	}
+
+	layout, err := readLayout(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	paths, err := storeFilePaths(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &MigrateResult{}
+	for i, path := range paths {
+		progress := migrateFile(dataDir, layout, path, targetVersion, opts.DryRun)
+		progress.Done = i + 1
+		progress.Total = len(paths)
+
+		switch progress.Status {
+		case MigrateStatusMigrated:
+			result.Migrated++
+		case MigrateStatusSkipped:
+			result.Skipped++
+		case MigrateStatusFailed:
+			result.Failed++
+		}
+
+		if opts.Progress != nil {
+			opts.Progress(progress)
+		}
+	}
+
+	// the server rebuilds the index from the migrated files on start
+	if !opts.DryRun && result.Migrated > 0 {
+		if err := os.Remove(filepath.Join(dataDir, indexFileName)); err != nil && !os.IsNotExist(err) {
+			return result, err
+		}
+	}
+
+	if result.Failed > 0 {
+		return result, fmt.Errorf("%d of %d files failed to migrate", result.Failed, len(paths))
+	}
+	return result, nil
+}
+
+// migrateFile rewrites a single file in the target version
+func migrateFile(dataDir string, layout Layout, path string, targetVersion FSVersion, dryRun bool) MigrateProgress {
+	progress := MigrateProgress{Path: path}
+	fail := func(err error) MigrateProgress {
+		progress.Status = MigrateStatusFailed
+		progress.Err = err
+		return progress
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return fail(err)
+	}
+	defer file.Close()
+
+	store, err := parseFileStore(file)
+	if err != nil {
+		return fail(err)
+	}
+	progress.FileName = store.FileName
+	progress.FromVersion = store.Version
+
+	// the file is rewritten at the path of its name, which must be the current path
+	if path != layout.filePath(dataDir, store.FileName) {
+		return fail(fmt.Errorf("file name %q doesn't match %s", store.FileName, path))
+	}
+
+	if store.Version == targetVersion {
+		progress.Status = MigrateStatusSkipped
+		return progress
+	}
+
+	if !dryRun {
+		migrated := &FileStore{
+			Version:     targetVersion,
+			Reader:      store,
+			FileName:    store.FileName,
+			DataSize:    store.DataSize,
+			CreatedAt:   store.CreatedAt,
+			ModifiedAt:  store.ModifiedAt,
+			ContentType: store.ContentType,
+			Metadata:    store.Metadata,
+		}
+		if err := migrated.createFileAt(dataDir, layout, true); err != nil {
+			return fail(err)
+		}
+	}
+
+	progress.Status = MigrateStatusMigrated
+	return progress
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test_Migrate tests migrating files between versions
+func Test_Migrate(t *testing.T) {
+	sc := getServerConfig(t)
+	defer os.RemoveAll(sc.DataDir)
+
+	sc.Version = FSStoreV1
+	files := map[string]string{
+		"migrate_test1.txt": "test data 1",
+		"migrate_test2.txt": "test data 2",
+	}
+	for fn, data := range files {
+		err := sc.createFile(fn, int64(len(data)), strings.NewReader(data), false)
+		if !assert.NoError(t, err, "Error creating file") {
+			return
+		}
+	}
+
+	// Dry run doesn't change any file
+	result, err := Migrate(sc.DataDir, FSStoreV2, MigrateOptions{DryRun: true})
+	if assert.NoError(t, err, "Error in dry run") {
+		assert.Equal(t, &MigrateResult{Migrated: 2}, result)
+	}
+	for fn := range files {
+		file, store, err := sc.openFile(fn)
+		if assert.NoError(t, err, "Error opening file") {
+			assert.Equal(t, FSStoreV1, store.Version, "Dry run changed the file")
+			file.Close()
+		}
+	}
+
+	progress := 0
+	result, err = Migrate(sc.DataDir, FSStoreV2, MigrateOptions{
+		Progress: func(p MigrateProgress) { progress++ },
+	})
+	if assert.NoError(t, err, "Error migrating") {
+		assert.Equal(t, &MigrateResult{Migrated: 2}, result)
+		assert.Equal(t, 2, progress, "Progress not reported for every file")
+	}
+	for fn, data := range files {
+		file, store, err := sc.openFile(fn)
+		if assert.NoError(t, err, "Error opening file") {
+			assert.Equal(t, FSStoreV2, store.Version, "File was not migrated")
+			dataBytes, err := io.ReadAll(store)
+			if assert.NoError(t, err, "Error reading content from file") {
+				assert.Equal(t, data, string(dataBytes), "File data is not the same")
+			}
+			file.Close()
+		}
+	}
+
+	// Running again skips migrated files
+	result, err = Migrate(sc.DataDir, FSStoreV2, MigrateOptions{})
+	if assert.NoError(t, err, "Error migrating again") {
+		assert.Equal(t, &MigrateResult{Skipped: 2}, result)
+	}
+}
+
+// Test_Migrate_Corrupt tests that corrupt files are reported and left alone
+func Test_Migrate_Corrupt(t *testing.T) {
+	sc := getServerConfig(t)
+	defer os.RemoveAll(sc.DataDir)
+
+	data := "test data"
+	err := sc.createFile("migrate_ok.txt", int64(len(data)), strings.NewReader(data), false)
+	if !assert.NoError(t, err, "Error creating file") {
+		return
+	}
+	corruptPath := filepath.Join(sc.DataDir, generateFileName("corrupt.txt"))
+	err = os.WriteFile(corruptPath, []byte{byte(FSStoreV1), 10}, 0644)
+	if !assert.NoError(t, err, "Error creating corrupt file") {
+		return
+	}
+
+	result, err := Migrate(sc.DataDir, FSStoreV1, MigrateOptions{})
+	assert.Error(t, err, "No error for corrupt file")
+	assert.Equal(t, &MigrateResult{Migrated: 1, Failed: 1}, result)
+	assert.FileExists(t, corruptPath, "Corrupt file was removed")
+}
@@ -0,0 +1,261 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	. "fs-store/types"
+
+	"github.com/labstack/echo/v4"
+)
+
+// MaxSignExpiry is the longest time a signed URL can be valid for
+const MaxSignExpiry = 7 * 24 * time.Hour
+
+// signedContextKey is the echo context key set for requests with a valid signed URL
+const signedContextKey = "signed"
+
+var (
+	// errInvalidSignature is returned when a signed URL doesn't match its signature
+	errInvalidSignature = errors.New("invalid signature")
+
+	// errSignatureExpired is returned when a signed URL is past its expiry
+	errSignatureExpired = errors.New("signature expired")
+)
+
+// SignSecrets are the secrets URLs are signed with by their id, the first
+// secret signs new URLs while the others only verify URLs signed before a rotation
+type SignSecrets struct {
+	ids     []string
+	secrets map[string][]byte
+
+	now func() time.Time
+}
+
+// NewSignSecrets creates the sign secrets from ID:SECRET pairs, the first
+// pair is the current secret
+func NewSignSecrets(pairs []string) (*SignSecrets, error) {
+	if len(pairs) == 0 {
+		return nil, errors.New("at least one sign secret is required")
+	}
+	s := &SignSecrets{secrets: make(map[string][]byte, len(pairs)), now: time.Now}
+	for _, pair := range pairs {
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("sign secret must be ID:SECRET: %s", pair)
+		}
+		if _, ok := s.secrets[parts[0]]; ok {
+			return nil, fmt.Errorf("duplicate sign secret id: %s", parts[0])
+		}
+		s.ids = append(s.ids, parts[0])
+		s.secrets[parts[0]] = []byte(parts[1])
+	}
+	return s, nil
+}
+
+// signedParams are the query parameters covered by the signature, other
+// parameters such as the list cursor can be changed by the holder of the URL
+var signedParams = []string{"expires", "keyId", "maxSize", "overwrite", "prefix"}
+
+// signature returns the hex HMAC of the method, resource and signed query parameters of a URL
+func signature(secret []byte, method, resource string, query url.Values) string {
+	if method == "HEAD" {
+		method = "GET"
+	}
+	signed := url.Values{}
+	for _, key := range signedParams {
+		if values, ok := query[key]; ok {
+			signed[key] = values
+		}
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(method + "\n" + resource + "\n" + signed.Encode()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Sign returns the query of a URL for the method on the resource, signed with
+// the current secret, the query may already set other parameters such as the prefix
+func (s *SignSecrets) Sign(method, resource string, query url.Values, expiresAt time.Time, maxSize int64) url.Values {
+	signed := url.Values{}
+	for key, values := range query {
+		signed[key] = values
+	}
+	signed.Set("expires", strconv.FormatInt(expiresAt.Unix(), 10))
+	signed.Set("keyId", s.ids[0])
+	if maxSize > 0 {
+		signed.Set("maxSize", strconv.FormatInt(maxSize, 10))
+	}
+	signed.Set("signature", signature(s.secrets[s.ids[0]], method, resource, signed))
+	return signed
+}
+
+// verify checks the signature and expiry of the query of a URL
+func (s *SignSecrets) verify(method, resource string, query url.Values) error {
+	secret, ok := s.secrets[query.Get("keyId")]
+	if !ok {
+		return errInvalidSignature
+	}
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil {
+		return errInvalidSignature
+	}
+
+	expected := signature(secret, method, resource, query)
+	if !hmac.Equal([]byte(expected), []byte(query.Get("signature"))) {
+		return errInvalidSignature
+	}
+	if s.now().Unix() > expires {
+		return errSignatureExpired
+	}
+	return nil
+}
+
+// signedResource returns the resource a signed URL covers for the method and
+// route of a request, signed URLs only list, download and upload files
+func signedResource(c echo.Context) (string, bool) {
+	method := c.Request().Method
+	switch c.Path() {
+	case "/files":
+		return "/files", method == "GET"
+	case "/files/*":
+		name, err := fileNameParam(c)
+		if err != nil || name == "" {
+			return "", false
+		}
+		return "/files/" + name, method == "GET" || method == "HEAD" || method == "PUT"
+	}
+	return "", false
+}
+
+// signedURLMiddleware verifies requests with a signature in the query, a
+// valid signature grants the request without an API key
+func (sc *ServerConfig) signedURLMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			query := c.QueryParams()
+			if !query.Has("signature") {
+				return next(c)
+			}
+
+			resource, ok := signedResource(c)
+			if !ok {
+				return c.JSON(403, GenericResponse{
+					Success: false,
+					Message: "Invalid signature",
+				})
+			}
+
+			err := sc.SignSecrets.verify(c.Request().Method, resource, query)
+			if err == errSignatureExpired {
+				return c.JSON(403, GenericResponse{
+					Success: false,
+					Message: "Signed URL expired",
+				})
+			}
+
+			if err != nil {
+				return c.JSON(403, GenericResponse{
+					Success: false,
+					Message: "Invalid signature",
+				})
+			}
+
+			if query.Has("maxSize") {
+				maxSize, err := strconv.ParseInt(query.Get("maxSize"), 10, 64)
+				if err != nil || c.Request().ContentLength > maxSize {
+					return c.JSON(400, GenericResponse{
+						Success: false,
+						Message: "File too large",
+					})
+				}
+				c.Request().Body = http.MaxBytesReader(c.Response(), c.Request().Body, maxSize)
+			}
+
+			c.Set(signedContextKey, true)
+			return next(c)
+		}
+	}
+}
+
+// signRoute is the route for signing URLs of files with the current secret
+func signRoute(sc *ServerConfig) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if sc.SignSecrets == nil {
+			return c.JSON(404, GenericResponse{
+				Success: false,
+				Message: "Signed URLs are disabled",
+			})
+		}
+
+		req := &SignRequest{}
+		if err := c.Bind(req); err != nil {
+			return c.JSON(400, GenericResponse{
+				Success: false,
+				Message: "Invalid request",
+			})
+		}
+
+		expiresIn := time.Duration(req.ExpiresIn) * time.Second
+		if expiresIn <= 0 || expiresIn > MaxSignExpiry {
+			return c.JSON(400, GenericResponse{
+				Success: false,
+				Message: "Invalid expiry",
+			})
+		}
+
+		resource, query, name := "/files/"+req.FileName, url.Values{}, req.FileName
+		switch req.Method {
+		case "GET":
+			if req.FileName == "" {
+				resource, name = "/files", req.Prefix
+				query.Set("prefix", req.Prefix)
+			}
+		case "PUT":
+			if req.FileName == "" || len(req.FileName) > 255 {
+				return c.JSON(400, GenericResponse{
+					Success: false,
+					Message: "Invalid file name",
+				})
+			}
+			if req.Overwrite {
+				query.Set("overwrite", "true")
+			}
+		default:
+			return c.JSON(400, GenericResponse{
+				Success: false,
+				Message: "Invalid method",
+			})
+		}
+
+		scope := ScopeRead
+		if req.Method == "PUT" {
+			scope = ScopeWrite
+		}
+		if !hasScope(c, scope) || !sc.allows(c, scope, name) {
+			return c.JSON(403, GenericResponse{
+				Success: false,
+				Message: "Permission denied",
+			})
+		}
+
+		expiresAt := sc.SignSecrets.now().Add(expiresIn)
+		signed := sc.SignSecrets.Sign(req.Method, resource, query, expiresAt, req.MaxSize)
+		target := "/files"
+		if resource != "/files" {
+			target += "/" + url.PathEscape(req.FileName)
+		}
+		return c.JSON(200, SignResponse{
+			Success:   true,
+			URL:       target + "?" + signed.Encode(),
+			ExpiresAt: time.Unix(expiresAt.Unix(), 0).UTC(),
+		})
+	}
+}
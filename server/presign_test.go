@@ -0,0 +1,83 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	. "fs-store/types"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test_SignedURLs tests signing URLs and using them without an API key
+func Test_SignedURLs(t *testing.T) {
+	sc, tokens := getAuthServerConfig(t)
+	defer os.RemoveAll(sc.DataDir)
+	var err error
+	sc.SignSecrets, err = NewSignSecrets([]string{"k1:secret1"})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	sign := func(token string, req SignRequest) (int, string) {
+		body, _ := json.Marshal(req)
+		httpReq := httptest.NewRequest("POST", "/sign", strings.NewReader(string(body)))
+		httpReq.Header.Set("Content-Type", "application/json")
+		httpReq.Header.Set("Authorization", "Bearer "+token)
+		rec := doRequest(sc, httpReq)
+		resp := &SignResponse{}
+		json.Unmarshal(rec.Body.Bytes(), resp)
+		return rec.Code, resp.URL
+	}
+	request := func(method, target, body string) int {
+		return doRequest(sc, httptest.NewRequest(method, target, strings.NewReader(body))).Code
+	}
+
+	code, _ := sign(tokens["reader"], SignRequest{Method: "PUT", FileName: "a.txt", ExpiresIn: 60})
+	assert.Equal(t, 403, code, "Put URL signed without the write scope")
+	code, _ = sign(tokens["builds"], SignRequest{Method: "GET", FileName: "other/a.txt", ExpiresIn: 60})
+	assert.Equal(t, 403, code, "URL signed outside the prefix of the key")
+	code, _ = sign(tokens["admin"], SignRequest{Method: "GET", FileName: "a.txt", ExpiresIn: 8 * 24 * 3600})
+	assert.Equal(t, 400, code, "URL signed past the max expiry")
+
+	code, putURL := sign(tokens["builds"], SignRequest{Method: "PUT", FileName: "builds/a b.txt", ExpiresIn: 60, MaxSize: 4})
+	assert.Equal(t, 200, code, "Error signing put URL")
+	assert.Equal(t, 400, request("PUT", putURL, "01234"), "Upload larger than the max size")
+	assert.Equal(t, 200, request("PUT", putURL, "0123"), "Error uploading with signed URL")
+	assert.Equal(t, 403, request("PUT", strings.Replace(putURL, "a%20b", "c", 1), "0123"), "Signed URL used for another file")
+	assert.Equal(t, 403, request("PUT", putURL+"&overwrite=true", "0123"), "Unsigned overwrite accepted")
+	assert.Equal(t, 401, request("GET", strings.Replace(putURL, "signature=", "x=", 1), ""), "Request without signature wasn't authenticated")
+
+	code, getURL := sign(tokens["builds"], SignRequest{Method: "GET", FileName: "builds/a b.txt", ExpiresIn: 60})
+	assert.Equal(t, 200, code, "Error signing get URL")
+	rec := doRequest(sc, httptest.NewRequest("GET", getURL, nil))
+	assert.Equal(t, 200, rec.Code)
+	assert.Equal(t, "0123", rec.Body.String())
+	assert.Equal(t, 403, request("PUT", getURL, "0123"), "Get URL used for an upload")
+
+	// the unsigned list parameters can be changed
+	code, listURL := sign(tokens["builds"], SignRequest{Method: "GET", Prefix: "builds/", ExpiresIn: 60})
+	assert.Equal(t, 200, code, "Error signing list URL")
+	assert.Equal(t, 200, request("GET", listURL+"&limit=1", ""))
+	u, _ := url.Parse(listURL)
+	query := u.Query()
+	query.Set("prefix", "")
+	assert.Equal(t, 403, request("GET", "/files?"+query.Encode(), ""), "List URL used for another prefix")
+
+	// URLs signed before a rotation are valid until they expire
+	sc.SignSecrets, _ = NewSignSecrets([]string{"k2:secret2", "k1:secret1"})
+	assert.Equal(t, 200, request("GET", getURL, ""), "URL signed with the old secret rejected")
+	sc.SignSecrets, _ = NewSignSecrets([]string{"k2:secret2"})
+	assert.Equal(t, 403, request("GET", getURL, ""), "URL signed with a removed secret accepted")
+
+	sc.SignSecrets, _ = NewSignSecrets([]string{"k1:secret1"})
+	sc.SignSecrets.now = func() time.Time { return time.Now().Add(time.Minute + time.Second) }
+	rec = doRequest(sc, httptest.NewRequest("GET", getURL, nil))
+	assert.Equal(t, 403, rec.Code, "Expired URL accepted")
+	assert.Contains(t, rec.Body.String(), "Signed URL expired")
+}
@@ -1,6 +1,13 @@
 package server
 
 import (
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
 	. "fs-store/types"
 
 	"github.com/labstack/echo/v4"
@@ -10,8 +17,56 @@ import (
 // ListFilesRoute is the route for listing files
 func listFilesRoute(sc *ServerConfig) echo.HandlerFunc {
 	return func(c echo.Context) error {
+		opts := listOptions{
+			Cursor: c.QueryParam("cursor"),
+			Prefix: c.QueryParam("prefix"),
+			Sort:   ListSort(c.QueryParam("sort")),
+			Order:  ListOrder(c.QueryParam("order")),
+
+			Delimiter: c.QueryParam("delimiter"),
+		}
+		if limit := c.QueryParam("limit"); limit != "" {
+			var err error
+			if opts.Limit, err = strconv.Atoi(limit); err != nil || opts.Limit <= 0 {
+				return c.JSON(400, GenericResponse{
+					Success: false,
+					Message: "Invalid limit",
+				})
+			}
+		}
+
+		if key, ok := c.Get(apiKeyContextKey).(*APIKey); ok {
+			opts.visible = sc.listFilter(key)
+		}
+		files, err := sc.listFiles(opts)
 
-		files, err := sc.getFileList(sc.MaxListSize)
+		if err == ErrInvalidCursor {
+			return c.JSON(400, GenericResponse{
+				Success: false,
+				Message: "Invalid cursor",
+			})
+		}
+
+		if err == ErrInvalidSort {
+			return c.JSON(400, GenericResponse{
+				Success: false,
+				Message: "Invalid sort",
+			})
+		}
+
+		if err == ErrInvalidOrder {
+			return c.JSON(400, GenericResponse{
+				Success: false,
+				Message: "Invalid order",
+			})
+		}
+
+		if err == ErrInvalidDelimiter {
+			return c.JSON(400, GenericResponse{
+				Success: false,
+				Message: "Delimiter requires sorting by name",
+			})
+		}
 
 		if err != nil {
 			logrus.Error("Error while trying to get file list", err)
@@ -25,6 +80,87 @@ func listFilesRoute(sc *ServerConfig) echo.HandlerFunc {
 	}
 }
 
+// fileNameParam returns the unescaped file name from the path, the name may
+// contain slashes
+func fileNameParam(c echo.Context) (string, error) {
+	fileName := c.Param("*")
+	// the router matches on the raw path when the path contains escaped characters
+	if c.Request().URL.RawPath == "" {
+		return fileName, nil
+	}
+	return url.PathUnescape(fileName)
+}
+
+// metadataHeaderPrefix is the prefix of headers carrying file metadata
+const metadataHeaderPrefix = "X-Meta-"
+
+// metadataFromHeader returns the file metadata from headers with the metadata prefix
+func metadataFromHeader(header http.Header) map[string]string {
+	var metadata map[string]string
+	for key, values := range header {
+		if !strings.HasPrefix(key, metadataHeaderPrefix) || len(values) == 0 {
+			continue
+		}
+		if metadata == nil {
+			metadata = make(map[string]string)
+		}
+		metadata[strings.ToLower(strings.TrimPrefix(key, metadataHeaderPrefix))] = values[0]
+	}
+	return metadata
+}
+
+// DownloadFileRoute is the route for downloading files, supports range and conditional requests
+func downloadFileRoute(sc *ServerConfig) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		fileName, err := fileNameParam(c)
+		if err != nil || fileName == "" {
+			return c.JSON(400, GenericResponse{
+				Success: false,
+				Message: "Invalid file name",
+			})
+		}
+
+		if len(fileName) > 255 {
+			return c.JSON(400, GenericResponse{
+				Success: false,
+				Message: "File name too long",
+			})
+		}
+
+		file, store, err := sc.openFile(fileName)
+		if err == ErrFileDoesntExist {
+			return c.JSON(404, GenericResponse{
+				Success: false,
+				Message: "File doesn't exist",
+			})
+		}
+
+		if err != nil {
+			logrus.Error("Error while trying to open file", err)
+			return c.JSON(500, GenericResponse{
+				Success: false,
+				Message: "Internal server error",
+			})
+		}
+		defer file.Close()
+
+		// ServeContent handles range requests and conditional headers
+		header := c.Response().Header()
+		contentType := store.ContentType
+		if contentType == "" {
+			contentType = echo.MIMEOctetStream
+		}
+		header.Set(echo.HeaderContentType, contentType)
+		header.Set("ETag", store.ETag())
+		for key, value := range store.Metadata {
+			header.Set(metadataHeaderPrefix+key, value)
+		}
+		http.ServeContent(c.Response(), c.Request(), store.FileName,
+			store.ModifiedAt, file)
+		return nil
+	}
+}
+
 // UploadFileRoute is the route for uploading files
 func uploadFileRoute(sc *ServerConfig) echo.HandlerFunc {
 	return func(c echo.Context) error {
@@ -59,6 +195,13 @@ func uploadFileRoute(sc *ServerConfig) echo.HandlerFunc {
 			})
 		}
 
+		if !sc.allows(c, ScopeWrite, files[0].Filename) {
+			return c.JSON(403, GenericResponse{
+				Success: false,
+				Message: "Permission denied",
+			})
+		}
+
 		logrus.Info("Uploading file: ", files[0].Size, " ", sc.MaxFileSize)
 		if files[0].Size > sc.MaxFileSize {
 			return c.JSON(400, GenericResponse{
@@ -76,7 +219,12 @@ func uploadFileRoute(sc *ServerConfig) echo.HandlerFunc {
 			})
 		}
 		logrus.Info("Uploading file: ", fileHeader.Filename)
-		err = sc.createFile(fileHeader.Filename, fileHeader.Size, fileReader, overwrite)
+		err = sc.createFileStore(sc.echoActor(c), &FileStore{
+			FileName:    fileHeader.Filename,
+			DataSize:    fileHeader.Size,
+			Reader:      fileReader,
+			ContentType: fileHeader.Header.Get(echo.HeaderContentType),
+		}, overwrite)
 
 		if err == ErrFileAlreadyExists {
 			return c.JSON(409, GenericResponse{
@@ -100,9 +248,114 @@ func uploadFileRoute(sc *ServerConfig) echo.HandlerFunc {
 	}
 }
 
+// PutFileRoute is the route for uploading files by streaming the request body
+func putFileRoute(sc *ServerConfig) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		fileName, err := fileNameParam(c)
+		if err != nil || fileName == "" {
+			return c.JSON(400, GenericResponse{
+				Success: false,
+				Message: "Invalid file name",
+			})
+		}
+
+		if len(fileName) > 255 {
+			return c.JSON(400, GenericResponse{
+				Success: false,
+				Message: "File name too long",
+			})
+		}
+
+		overwrite := c.QueryParams().
+			Get("overwrite") == "true"
+
+		// The size is written before the content, content of unknown size
+		// is spooled to the staging directory first
+		size := c.Request().ContentLength
+		var body io.Reader = http.MaxBytesReader(c.Response(), c.Request().Body, sc.MaxFileSize)
+		if size < 0 {
+			spool, spoolSize, err := sc.spoolUpload(c.Request().Body)
+			if err == ErrFileTooLarge {
+				return c.JSON(400, GenericResponse{
+					Success: false,
+					Message: "File too large",
+				})
+			} else if err == io.ErrUnexpectedEOF {
+				return c.JSON(400, GenericResponse{
+					Success: false,
+					Message: "Incomplete file content",
+				})
+			} else if err != nil {
+				logrus.Error("Error spooling file: ", err)
+				return c.JSON(500, GenericResponse{
+					Success: false,
+					Message: "Internal server error",
+				})
+			}
+			defer os.Remove(spool.Name())
+			defer spool.Close()
+			size, body = spoolSize, spool
+		}
+
+		if size == 0 {
+			return c.JSON(400, GenericResponse{
+				Success: false,
+				Message: "File is empty",
+			})
+		}
+
+		if size > sc.MaxFileSize {
+			return c.JSON(400, GenericResponse{
+				Success: false,
+				Message: "File too large",
+			})
+		}
+
+		logrus.Info("Uploading file: ", fileName)
+		err = sc.createFileStore(sc.echoActor(c), &FileStore{
+			FileName:    fileName,
+			DataSize:    size,
+			Reader:      body,
+			ContentType: c.Request().Header.Get(echo.HeaderContentType),
+			Metadata:    metadataFromHeader(c.Request().Header),
+		}, overwrite)
+
+		if err == ErrFileAlreadyExists {
+			return c.JSON(409, GenericResponse{
+				Success: false,
+				Message: "File already exists",
+			})
+		}
+
+		if err == io.ErrUnexpectedEOF {
+			return c.JSON(400, GenericResponse{
+				Success: false,
+				Message: "Incomplete file content",
+			})
+		}
+
+		if err != nil {
+			logrus.Error("Error while trying to read body", err)
+			return c.JSON(500, GenericResponse{
+				Success: false,
+				Message: "Internal server error",
+			})
+		}
+
+		return c.JSON(200, GenericResponse{
+			Success: true,
+			Message: "File uploaded",
+		})
+	}
+}
+
 // DeleteFileRoute is the route for deleting files
 func deleteFileRoute(sc *ServerConfig) echo.HandlerFunc {
 	return func(c echo.Context) error {
+		if c.QueryParams().Has("prefix") {
+			return deletePrefix(sc, c)
+		}
+
 		fileName := c.QueryParam("filename")
 		if fileName == "" {
 			return c.JSON(400, GenericResponse{
@@ -118,7 +371,7 @@ func deleteFileRoute(sc *ServerConfig) echo.HandlerFunc {
 			})
 		}
 
-		err := sc.deleteFile(fileName)
+		err := sc.deleteFile(sc.echoActor(c), fileName)
 		if err == ErrFileDoesntExist {
 			return c.JSON(400, GenericResponse{
 				Success: false,
@@ -139,3 +392,268 @@ func deleteFileRoute(sc *ServerConfig) echo.HandlerFunc {
 		})
 	}
 }
+
+// deletePrefix deletes every file in a virtual directory
+func deletePrefix(sc *ServerConfig, c echo.Context) error {
+	prefix := c.QueryParam("prefix")
+	if !strings.HasSuffix(prefix, "/") || strings.Trim(prefix, "/") == "" {
+		return c.JSON(400, GenericResponse{
+			Success: false,
+			Message: "Prefix must be a directory ending with /",
+		})
+	}
+
+	deleted, err := sc.deleteFiles(sc.echoActor(c), prefix)
+	if err != nil {
+		logrus.Error("Error while trying to delete files", err)
+		return c.JSON(500, DeleteResponse{
+			Success: false,
+			Message: "Internal server error",
+			Deleted: deleted,
+		})
+	}
+
+	return c.JSON(200, DeleteResponse{
+		Success: true,
+		Message: strconv.Itoa(len(deleted)) + " files deleted",
+		Deleted: deleted,
+	})
+}
+
+// uploadOwner returns the name of the API key of the request which owns the
+// upload sessions it creates, the empty name without authentication
+func uploadOwner(c echo.Context) string {
+	if key, ok := c.Get(apiKeyContextKey).(*APIKey); ok {
+		return key.Name
+	}
+	return ""
+}
+
+// CreateUploadRoute is the route for creating an upload session
+func createUploadRoute(sc *ServerConfig) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var req UploadSessionRequest
+		if err := c.Bind(&req); err != nil || req.FileName == "" {
+			return c.JSON(400, GenericResponse{
+				Success: false,
+				Message: "Invalid upload session request",
+			})
+		}
+
+		if len(req.FileName) > 255 {
+			return c.JSON(400, GenericResponse{
+				Success: false,
+				Message: "File name too long",
+			})
+		}
+
+		if !sc.allows(c, ScopeWrite, req.FileName) {
+			return c.JSON(403, GenericResponse{
+				Success: false,
+				Message: "Permission denied",
+			})
+		}
+
+		if req.FileSize <= 0 {
+			return c.JSON(400, GenericResponse{
+				Success: false,
+				Message: "File is empty",
+			})
+		}
+
+		if req.FileSize > sc.MaxFileSize {
+			return c.JSON(400, GenericResponse{
+				Success: false,
+				Message: "File too large",
+			})
+		}
+
+		if !req.Overwrite {
+			if exists, err := sc.fileExists(req.FileName); err == nil && exists {
+				return c.JSON(409, GenericResponse{
+					Success: false,
+					Message: "File already exists",
+				})
+			}
+		}
+
+		session, err := sc.createUploadSession(uploadOwner(c), req)
+		if err != nil {
+			logrus.Error("Error while trying to create upload session", err)
+			return c.JSON(500, GenericResponse{
+				Success: false,
+				Message: "Internal server error",
+			})
+		}
+
+		return c.JSON(201, session)
+	}
+}
+
+// GetUploadRoute is the route for querying the received ranges of an upload session
+func getUploadRoute(sc *ServerConfig) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		session, err := sc.getUploadSession(uploadOwner(c), c.Param("id"))
+		if err == ErrUploadDoesntExist {
+			return c.JSON(404, GenericResponse{
+				Success: false,
+				Message: "Upload session doesn't exist",
+			})
+		}
+
+		if err != nil {
+			logrus.Error("Error while trying to read upload session", err)
+			return c.JSON(500, GenericResponse{
+				Success: false,
+				Message: "Internal server error",
+			})
+		}
+
+		return c.JSON(200, session)
+	}
+}
+
+// UploadChunkRoute is the route for uploading a numbered chunk of an upload session
+func uploadChunkRoute(sc *ServerConfig) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		number, err := strconv.ParseInt(c.Param("number"), 10, 64)
+		if err != nil {
+			return c.JSON(400, GenericResponse{
+				Success: false,
+				Message: "Invalid chunk number",
+			})
+		}
+
+		size := c.Request().ContentLength
+		if size < 0 {
+			return c.JSON(411, GenericResponse{
+				Success: false,
+				Message: "Content length required",
+			})
+		}
+
+		session, err := sc.writeUploadChunk(uploadOwner(c), c.Param("id"), number, size, c.Request().Body)
+		if err == ErrUploadDoesntExist {
+			return c.JSON(404, GenericResponse{
+				Success: false,
+				Message: "Upload session doesn't exist",
+			})
+		}
+
+		if err == ErrInvalidChunk {
+			return c.JSON(400, GenericResponse{
+				Success: false,
+				Message: "Invalid chunk",
+			})
+		}
+
+		if err == io.ErrUnexpectedEOF {
+			return c.JSON(400, GenericResponse{
+				Success: false,
+				Message: "Incomplete chunk content",
+			})
+		}
+
+		if err != nil {
+			logrus.Error("Error while trying to write chunk", err)
+			return c.JSON(500, GenericResponse{
+				Success: false,
+				Message: "Internal server error",
+			})
+		}
+
+		return c.JSON(200, session)
+	}
+}
+
+// CommitUploadRoute is the route for creating the file from a complete upload session
+func commitUploadRoute(sc *ServerConfig) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		err := sc.commitUploadSession(sc.echoActor(c), uploadOwner(c), c.Param("id"))
+		if err == ErrUploadDoesntExist {
+			return c.JSON(404, GenericResponse{
+				Success: false,
+				Message: "Upload session doesn't exist",
+			})
+		}
+
+		if err == ErrUploadIncomplete {
+			return c.JSON(400, GenericResponse{
+				Success: false,
+				Message: "Upload session is incomplete",
+			})
+		}
+
+		if err == ErrFileAlreadyExists {
+			return c.JSON(409, GenericResponse{
+				Success: false,
+				Message: "File already exists",
+			})
+		}
+
+		if err != nil {
+			logrus.Error("Error while trying to commit upload session", err)
+			return c.JSON(500, GenericResponse{
+				Success: false,
+				Message: "Internal server error",
+			})
+		}
+
+		return c.JSON(200, GenericResponse{
+			Success: true,
+			Message: "File uploaded",
+		})
+	}
+}
+
+// AbortUploadRoute is the route for aborting an upload session
+func abortUploadRoute(sc *ServerConfig) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		err := sc.abortUploadSession(uploadOwner(c), c.Param("id"))
+		if err == ErrUploadDoesntExist {
+			return c.JSON(404, GenericResponse{
+				Success: false,
+				Message: "Upload session doesn't exist",
+			})
+		}
+
+		if err != nil {
+			logrus.Error("Error while trying to abort upload session", err)
+			return c.JSON(500, GenericResponse{
+				Success: false,
+				Message: "Internal server error",
+			})
+		}
+
+		return c.JSON(200, GenericResponse{
+			Success: true,
+			Message: "Upload session aborted",
+		})
+	}
+}
+
+// FsckRoute is the route for checking the data directory while the server is running
+func fsckRoute(sc *ServerConfig) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		quarantine := c.QueryParams().
+			Get("quarantine") == "true"
+
+		report, err := sc.fsck(quarantine)
+		if err == ErrNotSupported {
+			return c.JSON(501, GenericResponse{
+				Success: false,
+				Message: "Checking files is not supported by the storage backend",
+			})
+		}
+
+		if err != nil {
+			logrus.Error("Error while trying to check files", err)
+			return c.JSON(500, GenericResponse{
+				Success: false,
+				Message: "Internal server error",
+			})
+		}
+
+		return c.JSON(200, report)
+	}
+}
@@ -0,0 +1,259 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// doRequest performs a request against the server routes
+func doRequest(sc *ServerConfig, req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	sc.newEcho().ServeHTTP(rec, req)
+	return rec
+}
+
+// Test_DownloadFileRoute test the downloading of a file
+func Test_DownloadFileRoute(t *testing.T) {
+	sc := getServerConfig(t)
+	defer os.RemoveAll(sc.DataDir)
+
+	fn := "download_test.txt"
+	data := "0123456789"
+	err := sc.createFile(fn, int64(len(data)), strings.NewReader(data), false)
+	if !assert.NoError(t, err, "Error creating file") {
+		return
+	}
+
+	rec := doRequest(sc, httptest.NewRequest("GET", "/files/"+fn, nil))
+	assert.Equal(t, 200, rec.Code, "Unexpected status code")
+	assert.Equal(t, data, rec.Body.String(), "File data is not the same")
+	assert.Equal(t, "10", rec.Header().Get("Content-Length"), "Unexpected content length")
+	assert.NotEmpty(t, rec.Header().Get("ETag"), "ETag not set")
+
+	rec = doRequest(sc, httptest.NewRequest("GET", "/files/missing.txt", nil))
+	assert.Equal(t, 404, rec.Code, "Unexpected status code for missing file")
+}
+
+// Test_DownloadFileRoute_Range test range requests on a file
+func Test_DownloadFileRoute_Range(t *testing.T) {
+	sc := getServerConfig(t)
+	defer os.RemoveAll(sc.DataDir)
+
+	fn := "range_test.txt"
+	data := "0123456789"
+	err := sc.createFile(fn, int64(len(data)), strings.NewReader(data), false)
+	if !assert.NoError(t, err, "Error creating file") {
+		return
+	}
+
+	for _, test := range []struct {
+		description string
+		rangeHeader string
+		status      int
+		body        string
+	}{
+		{"Start and end", "bytes=2-5", 206, "2345"},
+		{"Open ended", "bytes=7-", 206, "789"},
+		{"Suffix", "bytes=-2", 206, "89"},
+		{"Not satisfiable", "bytes=20-30", 416, ""},
+	} {
+		t.Run(test.description, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/files/"+fn, nil)
+			req.Header.Set("Range", test.rangeHeader)
+			rec := doRequest(sc, req)
+			assert.Equal(t, test.status, rec.Code, "Unexpected status code")
+			if test.body != "" {
+				assert.Equal(t, test.body, rec.Body.String(), "Unexpected range content")
+			}
+		})
+	}
+
+	// Multiple ranges are returned as multipart
+	req := httptest.NewRequest("GET", "/files/"+fn, nil)
+	req.Header.Set("Range", "bytes=0-1,8-9")
+	rec := doRequest(sc, req)
+	assert.Equal(t, 206, rec.Code, "Unexpected status code")
+	assert.True(t, strings.HasPrefix(rec.Header().Get("Content-Type"), "multipart/byteranges"),
+		"Unexpected content type for multiple ranges")
+}
+
+// Test_DownloadFileRoute_Conditional test conditional requests on a file
+func Test_DownloadFileRoute_Conditional(t *testing.T) {
+	sc := getServerConfig(t)
+	defer os.RemoveAll(sc.DataDir)
+
+	fn := "conditional_test.txt"
+	data := "0123456789"
+	err := sc.createFile(fn, int64(len(data)), strings.NewReader(data), false)
+	if !assert.NoError(t, err, "Error creating file") {
+		return
+	}
+
+	rec := doRequest(sc, httptest.NewRequest("GET", "/files/"+fn, nil))
+	etag := rec.Header().Get("ETag")
+	lastModified := rec.Header().Get("Last-Modified")
+
+	req := httptest.NewRequest("GET", "/files/"+fn, nil)
+	req.Header.Set("If-None-Match", etag)
+	rec = doRequest(sc, req)
+	assert.Equal(t, 304, rec.Code, "Expected not modified for matching ETag")
+
+	req = httptest.NewRequest("GET", "/files/"+fn, nil)
+	req.Header.Set("If-Modified-Since", lastModified)
+	rec = doRequest(sc, req)
+	assert.Equal(t, 304, rec.Code, "Expected not modified for If-Modified-Since")
+
+	req = httptest.NewRequest("GET", "/files/"+fn, nil)
+	req.Header.Set("If-None-Match", `"other"`)
+	rec = doRequest(sc, req)
+	assert.Equal(t, 200, rec.Code, "Expected content for different ETag")
+}
+
+// Test_PutFileRoute test the uploading of a file by streaming the body
+func Test_PutFileRoute(t *testing.T) {
+	sc := getServerConfig(t)
+	defer os.RemoveAll(sc.DataDir)
+
+	fn := "put_test.txt"
+	data := "test data"
+
+	rec := doRequest(sc, httptest.NewRequest("PUT", "/files/"+fn, strings.NewReader(data)))
+	if !assert.Equal(t, 200, rec.Code, "Unexpected status code") {
+		return
+	}
+
+	file, store, err := sc.openFile(fn)
+	if assert.NoError(t, err, "Error opening file") {
+		defer file.Close()
+		dataBytes, err := io.ReadAll(store)
+		if assert.NoError(t, err, "Error reading content from file") {
+			assert.Equal(t, data, string(dataBytes), "File data is not the same")
+		}
+	}
+
+	rec = doRequest(sc, httptest.NewRequest("PUT", "/files/"+fn, strings.NewReader(data)))
+	assert.Equal(t, 409, rec.Code, "Expected conflict when file exists")
+
+	rec = doRequest(sc, httptest.NewRequest("PUT", "/files/"+fn+"?overwrite=true", strings.NewReader(data)))
+	assert.Equal(t, 200, rec.Code, "Expected overwrite to succeed")
+}
+
+// Test_PutFileRoute_Invalid test the rejection of invalid uploads
+func Test_PutFileRoute_Invalid(t *testing.T) {
+	sc := getServerConfig(t)
+	defer os.RemoveAll(sc.DataDir)
+
+	// max file size of the test config is 10 bytes
+	rec := doRequest(sc, httptest.NewRequest("PUT", "/files/large.txt", strings.NewReader("01234567890")))
+	assert.Equal(t, 400, rec.Code, "Expected file too large")
+
+	rec = doRequest(sc, httptest.NewRequest("PUT", "/files/empty.txt", strings.NewReader("")))
+	assert.Equal(t, 400, rec.Code, "Expected empty file to be rejected")
+
+	exists, err := sc.fileExists("large.txt")
+	assert.NoError(t, err, "Error when checking if file exists")
+	assert.False(t, exists, "File was created")
+}
+
+// Test_PutFileRoute_UnknownSize test uploads without a content length
+func Test_PutFileRoute_UnknownSize(t *testing.T) {
+	sc := getServerConfig(t)
+	defer os.RemoveAll(sc.DataDir)
+
+	put := func(fn, data string) int {
+		req := httptest.NewRequest("PUT", "/files/"+fn, io.MultiReader(strings.NewReader(data)))
+		req.ContentLength = -1
+		return doRequest(sc, req).Code
+	}
+
+	assert.Equal(t, 200, put("unknown.txt", "0123456789"), "Expected upload without length to succeed")
+	file, store, err := sc.openFile("unknown.txt")
+	if assert.NoError(t, err, "Error opening file") {
+		defer file.Close()
+		assert.Equal(t, int64(10), store.DataSize)
+		dataBytes, err := io.ReadAll(store)
+		if assert.NoError(t, err, "Error reading content from file") {
+			assert.Equal(t, "0123456789", string(dataBytes), "File data is not the same")
+		}
+	}
+
+	// max file size of the test config is 10 bytes
+	assert.Equal(t, 400, put("large.txt", "01234567890"), "Expected file too large")
+	assert.Equal(t, 400, put("empty.txt", ""), "Expected empty file to be rejected")
+	exists, err := sc.fileExists("large.txt")
+	assert.NoError(t, err, "Error when checking if file exists")
+	assert.False(t, exists, "File was created")
+
+	// the spooled content is removed
+	entries, err := os.ReadDir(filepath.Join(sc.DataDir, uploadDirName))
+	assert.NoError(t, err)
+	assert.Empty(t, entries, "Spooled content left behind")
+}
+
+// Test_PutFileRoute_Metadata test that content type and metadata are returned on download
+func Test_PutFileRoute_Metadata(t *testing.T) {
+	sc := getServerConfig(t)
+	defer os.RemoveAll(sc.DataDir)
+
+	fn := "metadata_test.txt"
+	data := "test data"
+
+	req := httptest.NewRequest("PUT", "/files/"+fn, strings.NewReader(data))
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("X-Meta-Build-Id", "42")
+	rec := doRequest(sc, req)
+	if !assert.Equal(t, 200, rec.Code, "Unexpected status code") {
+		return
+	}
+
+	rec = doRequest(sc, httptest.NewRequest("GET", "/files/"+fn, nil))
+	assert.Equal(t, data, rec.Body.String(), "File data is not the same")
+	assert.Equal(t, "text/plain", rec.Header().Get("Content-Type"), "Unexpected content type")
+	assert.Equal(t, "42", rec.Header().Get("X-Meta-Build-Id"), "Unexpected metadata")
+
+	checksum := sha256.Sum256([]byte(data))
+	assert.Equal(t, `"`+hex.EncodeToString(checksum[:])+`"`, rec.Header().Get("ETag"),
+		"ETag is not the content checksum")
+}
+
+// Test_FileRoutes_Directories test names with slashes and deleting directories
+func Test_FileRoutes_Directories(t *testing.T) {
+	sc := getServerConfig(t)
+	defer os.RemoveAll(sc.DataDir)
+
+	data := "0123"
+	for _, target := range []string{"/files/team/a/build.log", "/files/team%2Fb%2Fbuild.log"} {
+		req := httptest.NewRequest("PUT", target, strings.NewReader(data))
+		rec := doRequest(sc, req)
+		assert.Equal(t, 200, rec.Code, "Unexpected status code for %s", target)
+	}
+
+	rec := doRequest(sc, httptest.NewRequest("GET", "/files/team/b/build.log", nil))
+	assert.Equal(t, 200, rec.Code, "Unexpected status code")
+	assert.Equal(t, data, rec.Body.String(), "File data is not the same")
+
+	rec = doRequest(sc, httptest.NewRequest("GET", "/files?delimiter=/&prefix=team/", nil))
+	assert.Equal(t, 200, rec.Code, "Unexpected status code")
+	assert.Contains(t, rec.Body.String(), `"commonPrefixes":["team/a/","team/b/"]`)
+
+	rec = doRequest(sc, httptest.NewRequest("DELETE", "/files?prefix=team", nil))
+	assert.Equal(t, 400, rec.Code, "Prefix without a trailing slash was accepted")
+
+	rec = doRequest(sc, httptest.NewRequest("DELETE", "/files?prefix=team/a/", nil))
+	assert.Equal(t, 200, rec.Code, "Unexpected status code")
+	assert.Contains(t, rec.Body.String(), `"deleted":["team/a/build.log"]`)
+
+	files, err := sc.getFileList(10)
+	if assert.NoError(t, err) && assert.Len(t, files, 1) {
+		assert.Equal(t, "team/b/build.log", files[0].FileName)
+	}
+}
@@ -0,0 +1,936 @@
+package server
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	. "fs-store/types"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+)
+
+// S3Config configures the S3 compatible frontend, buckets are the first
+// directory of file names and objects are the files in them
+type S3Config struct {
+	// Address is the address the S3 frontend listens on
+	Address string
+
+	// Region is the region requests must be signed for, any region if empty
+	Region string
+
+	// Credentials maps access key ids to secret access keys
+	Credentials map[string]string
+
+	// Identities maps access key ids to the names of the API keys they
+	// authenticate as when the server has API keys, by default the access key id
+	Identities map[string]string
+
+	now func() time.Time
+}
+
+// NewS3Config creates the configuration of the S3 frontend
+func NewS3Config(address, region string, credentials map[string]string) *S3Config {
+	return &S3Config{
+		Address:     address,
+		Region:      region,
+		Credentials: credentials,
+		now:         time.Now,
+	}
+}
+
+// s3Error is an S3 error code and its status
+type s3Error struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+// Error returns the message of the error
+func (e *s3Error) Error() string {
+	return e.Message
+}
+
+// Define S3 Errors
+var (
+	errS3AccessDenied           = &s3Error{403, "AccessDenied", "Access Denied"}
+	errS3InvalidAccessKeyID     = &s3Error{403, "InvalidAccessKeyId", "The access key id does not exist"}
+	errS3SignatureDoesNotMatch  = &s3Error{403, "SignatureDoesNotMatch", "The request signature does not match"}
+	errS3AuthorizationMalformed = &s3Error{400, "AuthorizationHeaderMalformed", "The authorization is malformed"}
+	errS3RequestTimeTooSkewed   = &s3Error{403, "RequestTimeTooSkewed", "The request time is too far from the server time"}
+	errS3RequestExpired         = &s3Error{403, "AccessDenied", "Request has expired"}
+	errS3ContentSHA256Missing   = &s3Error{400, "InvalidRequest", "Missing required header x-amz-content-sha256"}
+	errS3ContentSHA256Mismatch  = &s3Error{400, "XAmzContentSHA256Mismatch", "The content sha256 does not match"}
+	errS3BadDigest              = &s3Error{400, "BadDigest", "The Content-MD5 does not match"}
+	errS3IncompleteBody         = &s3Error{400, "IncompleteBody", "The request body is incomplete"}
+	errS3MissingContentLength   = &s3Error{411, "MissingContentLength", "Content-Length is required"}
+	errS3EntityTooLarge         = &s3Error{400, "EntityTooLarge", "The object is too large"}
+	errS3EmptyObject            = &s3Error{400, "InvalidRequest", "Empty objects can't be stored in the store version"}
+	errS3KeyTooLong             = &s3Error{400, "KeyTooLongError", "The key is too long"}
+	errS3InvalidBucketName      = &s3Error{400, "InvalidBucketName", "The bucket name is not valid"}
+	errS3BucketNotEmpty         = &s3Error{409, "BucketNotEmpty", "The bucket is not empty"}
+	errS3NoSuchKey              = &s3Error{404, "NoSuchKey", "The key does not exist"}
+	errS3NoSuchUpload           = &s3Error{404, "NoSuchUpload", "The upload does not exist"}
+	errS3InvalidPart            = &s3Error{400, "InvalidPart", "A part was not uploaded or its ETag does not match"}
+	errS3InvalidPartOrder       = &s3Error{400, "InvalidPartOrder", "The parts are not in ascending order"}
+	errS3InvalidArgument        = &s3Error{400, "InvalidArgument", "Invalid argument"}
+	errS3MalformedXML           = &s3Error{400, "MalformedXML", "The XML is not well-formed"}
+	errS3NotImplemented         = &s3Error{501, "NotImplemented", "The operation is not implemented"}
+	errS3InternalError          = &s3Error{500, "InternalError", "Internal server error"}
+)
+
+// s3Namespace is the xml namespace of S3 responses
+const s3Namespace = "http://s3.amazonaws.com/doc/2006-03-01/"
+
+// s3TimeFormat is the format of times in S3 responses
+const s3TimeFormat = "2006-01-02T15:04:05.000Z"
+
+// s3MaxKeys is the default number of keys in a listing
+const s3MaxKeys = 1000
+
+// s3MetadataHeaderPrefix is the prefix of headers carrying object metadata
+const s3MetadataHeaderPrefix = "X-Amz-Meta-"
+
+// s3BucketName matches valid bucket names
+var s3BucketName = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+
+// S3 request and response bodies
+type (
+	s3ErrorResponse struct {
+		XMLName   xml.Name `xml:"Error"`
+		Code      string
+		Message   string
+		Resource  string
+		RequestID string `xml:"RequestId"`
+	}
+
+	s3ListBucketsResponse struct {
+		XMLName xml.Name   `xml:"ListAllMyBucketsResult"`
+		Xmlns   string     `xml:"xmlns,attr"`
+		Owner   s3Owner    `xml:"Owner"`
+		Buckets []s3Bucket `xml:"Buckets>Bucket"`
+	}
+
+	s3Owner struct {
+		ID          string
+		DisplayName string
+	}
+
+	s3Bucket struct {
+		Name         string
+		CreationDate string
+	}
+
+	s3ListObjectsResponse struct {
+		XMLName               xml.Name         `xml:"ListBucketResult"`
+		Xmlns                 string           `xml:"xmlns,attr"`
+		Name                  string           `xml:"Name"`
+		Prefix                string           `xml:"Prefix"`
+		Delimiter             string           `xml:"Delimiter,omitempty"`
+		StartAfter            string           `xml:"StartAfter,omitempty"`
+		ContinuationToken     string           `xml:"ContinuationToken,omitempty"`
+		NextContinuationToken string           `xml:"NextContinuationToken,omitempty"`
+		EncodingType          string           `xml:"EncodingType,omitempty"`
+		MaxKeys               int              `xml:"MaxKeys"`
+		KeyCount              int              `xml:"KeyCount"`
+		IsTruncated           bool             `xml:"IsTruncated"`
+		Contents              []s3Object       `xml:"Contents"`
+		CommonPrefixes        []s3CommonPrefix `xml:"CommonPrefixes"`
+	}
+
+	s3Object struct {
+		Key          string
+		LastModified string
+		ETag         string
+		Size         int64
+		StorageClass string
+	}
+
+	s3CommonPrefix struct {
+		Prefix string
+	}
+
+	s3DeleteRequest struct {
+		XMLName xml.Name `xml:"Delete"`
+		Quiet   bool     `xml:"Quiet"`
+		Objects []struct {
+			Key string `xml:"Key"`
+		} `xml:"Object"`
+	}
+
+	s3DeleteResponse struct {
+		XMLName xml.Name        `xml:"DeleteResult"`
+		Xmlns   string          `xml:"xmlns,attr"`
+		Deleted []s3DeletedKey  `xml:"Deleted"`
+		Errors  []s3DeleteError `xml:"Error"`
+	}
+
+	s3DeletedKey struct {
+		Key string
+	}
+
+	s3DeleteError struct {
+		Key     string
+		Code    string
+		Message string
+	}
+
+	s3InitiateMultipartResponse struct {
+		XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+		Xmlns    string   `xml:"xmlns,attr"`
+		Bucket   string
+		Key      string
+		UploadID string `xml:"UploadId"`
+	}
+
+	s3CompleteMultipartRequest struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []struct {
+			PartNumber int    `xml:"PartNumber"`
+			ETag       string `xml:"ETag"`
+		} `xml:"Part"`
+	}
+
+	s3CompleteMultipartResponse struct {
+		XMLName  xml.Name `xml:"CompleteMultipartUploadResult"`
+		Xmlns    string   `xml:"xmlns,attr"`
+		Location string
+		Bucket   string
+		Key      string
+		ETag     string
+	}
+)
+
+// s3Multipart is a multipart upload staged in the upload directory
+type s3Multipart struct {
+	FileName    string            `json:"fileName"`
+	ContentType string            `json:"contentType,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	CreatedAt   time.Time         `json:"createdAt"`
+}
+
+// newS3Echo creates the echo instance of the S3 frontend
+func (sc *ServerConfig) newS3Echo() *echo.Echo {
+	e := echo.New()
+
+	// Hide initial messages
+	e.HideBanner = true
+	e.HidePort = true
+
+	e.Use(sc.metricsMiddleware("s3"))
+	e.Use(sc.s3Auth)
+
+	// Buckets
+	e.GET("/", s3ListBucketsRoute(sc))
+	e.Any("/:bucket", s3BucketRoute(sc))
+
+	// Objects
+	e.Any("/:bucket/*", s3ObjectRoute(sc))
+
+	return e
+}
+
+// s3AccessKeyContextKey is the echo context key of the access key of a verified request
+const s3AccessKeyContextKey = "s3AccessKey"
+
+// s3Auth verifies the signature of S3 requests and replaces the body with a
+// reader verifying the payload
+func (sc *ServerConfig) s3Auth(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		c.Response().Header().Set("X-Amz-Request-Id", s3RequestID())
+
+		body, err := sc.S3.verifySigV4(c.Request())
+		if err != nil {
+			return s3ErrorResponseFor(c, err)
+		}
+		c.Request().Body = body
+		if sig, err := parseSigV4(c.Request()); err == nil {
+			c.Set(s3AccessKeyContextKey, sig.AccessKey)
+
+			// requests are granted the scopes and prefixes of the API key of the access key
+			if sc.APIKeys != nil {
+				key := sc.s3APIKey(sig.AccessKey)
+				if key == nil {
+					return s3ErrorResponseFor(c, errS3InvalidAccessKeyID)
+				}
+				c.Set(apiKeyContextKey, key)
+			}
+		}
+		return next(c)
+	}
+}
+
+// s3APIKey returns the API key an access key authenticates as, nil if there is none
+func (sc *ServerConfig) s3APIKey(accessKey string) *APIKey {
+	name, ok := sc.S3.Identities[accessKey]
+	if !ok {
+		name = accessKey
+	}
+	return sc.APIKeys.byName(name)
+}
+
+// s3ListFilter returns the filter of the names the API key of the request can
+// list by its prefixes and the ACL policy, nil if it can list every name
+func (sc *ServerConfig) s3ListFilter(c echo.Context) func(string, bool) bool {
+	key, ok := c.Get(apiKeyContextKey).(*APIKey)
+	if !ok {
+		return nil
+	}
+	visible := sc.listFilter(key)
+	if len(key.Prefixes) == 0 {
+		return visible
+	}
+	return func(name string, commonPrefix bool) bool {
+		return key.allowsName(name) && (visible == nil || visible(name, commonPrefix))
+	}
+}
+
+// s3AllowsList checks if the API key of the request can list the prefix,
+// every prefix can be listed without authentication
+func s3AllowsList(c echo.Context, prefix string) bool {
+	key, ok := c.Get(apiKeyContextKey).(*APIKey)
+	return !ok || (key.hasScope(ScopeRead) && key.allowsName(prefix))
+}
+
+// s3ErrorResponseFor writes the S3 error response of an error
+func s3ErrorResponseFor(c echo.Context, err error) error {
+	s3Err, ok := err.(*s3Error)
+	if !ok {
+		logrus.Error("Error while handling S3 request ", err)
+		s3Err = errS3InternalError
+	}
+	return c.XML(s3Err.Status, s3ErrorResponse{
+		Code:      s3Err.Code,
+		Message:   s3Err.Message,
+		Resource:  c.Request().URL.Path,
+		RequestID: c.Response().Header().Get("X-Amz-Request-Id"),
+	})
+}
+
+// s3RequestID returns a random request id
+func s3RequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return strings.ToUpper(hex.EncodeToString(b))
+}
+
+// s3ObjectName returns the file name of an object in a bucket
+func s3ObjectName(bucket, key string) (string, error) {
+	if !s3BucketName.MatchString(bucket) {
+		return "", errS3InvalidBucketName
+	}
+	fileName := bucket + "/" + key
+	if len(fileName) > 255 {
+		return "", errS3KeyTooLong
+	}
+	return fileName, nil
+}
+
+// S3ListBucketsRoute lists the buckets, which are the first directories of the file names
+func s3ListBucketsRoute(sc *ServerConfig) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if !hasScope(c, ScopeRead) {
+			return s3ErrorResponseFor(c, errS3AccessDenied)
+		}
+
+		// only the buckets of the files the key can list are returned
+		page, err := sc.index.list(listOptions{
+			Limit:   math.MaxInt32,
+			Sort:    ListSortName,
+			Order:   ListOrderAsc,
+			visible: sc.s3ListFilter(c),
+		})
+		if err != nil {
+			return s3ErrorResponseFor(c, err)
+		}
+
+		// a bucket is created with its first object
+		response := s3ListBucketsResponse{Xmlns: s3Namespace, Buckets: []s3Bucket{}}
+		created := make(map[string]time.Time)
+		for _, file := range page.Files {
+			i := strings.Index(file.FileName, "/")
+			if i < 0 || !s3BucketName.MatchString(file.FileName[:i]) {
+				continue
+			}
+			bucket := file.FileName[:i]
+			if t, ok := created[bucket]; !ok {
+				response.Buckets = append(response.Buckets, s3Bucket{Name: bucket})
+				created[bucket] = file.CreatedAt
+			} else if file.CreatedAt.Before(t) {
+				created[bucket] = file.CreatedAt
+			}
+		}
+		for i, bucket := range response.Buckets {
+			response.Buckets[i].CreationDate = created[bucket.Name].UTC().Format(s3TimeFormat)
+		}
+		return c.XML(200, response)
+	}
+}
+
+// S3BucketRoute handles the bucket operations
+func s3BucketRoute(sc *ServerConfig) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		bucket := c.Param("bucket")
+		if !s3BucketName.MatchString(bucket) {
+			return s3ErrorResponseFor(c, errS3InvalidBucketName)
+		}
+		query := c.QueryParams()
+
+		switch c.Request().Method {
+		case http.MethodGet:
+			if query.Has("location") {
+				if !hasScope(c, ScopeRead) {
+					return s3ErrorResponseFor(c, errS3AccessDenied)
+				}
+				return c.XML(200, struct {
+					XMLName xml.Name `xml:"LocationConstraint"`
+					Xmlns   string   `xml:"xmlns,attr"`
+					Region  string   `xml:",chardata"`
+				}{Xmlns: s3Namespace, Region: sc.S3.Region})
+			}
+			return s3ListObjects(sc, c, bucket)
+		case http.MethodHead, http.MethodPut:
+			// buckets exist as long as they contain objects
+			scope := ScopeRead
+			if c.Request().Method == http.MethodPut {
+				scope = ScopeWrite
+			}
+			if !hasScope(c, scope) {
+				return s3ErrorResponseFor(c, errS3AccessDenied)
+			}
+			return c.NoContent(200)
+		case http.MethodDelete:
+			if !hasScope(c, ScopeDelete) {
+				return s3ErrorResponseFor(c, errS3AccessDenied)
+			}
+			if len(sc.index.names(bucket+"/")) > 0 {
+				return s3ErrorResponseFor(c, errS3BucketNotEmpty)
+			}
+			return c.NoContent(204)
+		case http.MethodPost:
+			if query.Has("delete") {
+				return s3DeleteObjects(sc, c, bucket)
+			}
+		}
+		return s3ErrorResponseFor(c, errS3NotImplemented)
+	}
+}
+
+// s3ListObjects lists a page of the objects in a bucket (ListObjectsV2)
+func s3ListObjects(sc *ServerConfig, c echo.Context, bucket string) error {
+	query := c.QueryParams()
+	maxKeys := s3MaxKeys
+	if value := query.Get("max-keys"); value != "" {
+		var err error
+		if maxKeys, err = strconv.Atoi(value); err != nil || maxKeys < 0 {
+			return s3ErrorResponseFor(c, errS3InvalidArgument)
+		}
+	}
+
+	encode := func(s string) string { return s }
+	if query.Get("encoding-type") == "url" {
+		encode = url.QueryEscape
+	}
+
+	bucketPrefix := bucket + "/"
+	opts := listOptions{
+		Limit:     maxKeys,
+		Cursor:    query.Get("continuation-token"),
+		Prefix:    bucketPrefix + query.Get("prefix"),
+		Delimiter: query.Get("delimiter"),
+		visible:   sc.s3ListFilter(c),
+	}
+	if err := opts.validate(sc.MaxListSize); err != nil {
+		return s3ErrorResponseFor(c, errS3InvalidArgument)
+	}
+	if !s3AllowsList(c, opts.Prefix) {
+		return s3ErrorResponseFor(c, errS3AccessDenied)
+	}
+	// report the limit after clamping it to the max list size
+	if maxKeys > 0 {
+		maxKeys = opts.Limit
+	}
+	// the start is only used for the first page
+	if startAfter := query.Get("start-after"); startAfter != "" && opts.Cursor == "" {
+		opts.Cursor = opts.encodeCursor(&FileResponse{FileName: bucketPrefix + startAfter})
+	}
+
+	response := s3ListObjectsResponse{
+		Xmlns:             s3Namespace,
+		Name:              bucket,
+		Prefix:            encode(query.Get("prefix")),
+		Delimiter:         encode(opts.Delimiter),
+		StartAfter:        encode(query.Get("start-after")),
+		ContinuationToken: query.Get("continuation-token"),
+		EncodingType:      query.Get("encoding-type"),
+		MaxKeys:           maxKeys,
+	}
+	if maxKeys == 0 {
+		return c.XML(200, response)
+	}
+
+	page, err := sc.index.list(opts)
+	if err == ErrInvalidCursor {
+		return s3ErrorResponseFor(c, errS3InvalidArgument)
+	} else if err != nil {
+		return s3ErrorResponseFor(c, err)
+	}
+
+	for _, file := range page.Files {
+		response.Contents = append(response.Contents, s3Object{
+			Key:          encode(strings.TrimPrefix(file.FileName, bucketPrefix)),
+			LastModified: file.ModifiedAt.UTC().Format(s3TimeFormat),
+			ETag:         s3ETag(file),
+			Size:         file.FileSize,
+			StorageClass: "STANDARD",
+		})
+	}
+	for _, prefix := range page.CommonPrefixes {
+		response.CommonPrefixes = append(response.CommonPrefixes,
+			s3CommonPrefix{Prefix: encode(strings.TrimPrefix(prefix, bucketPrefix))})
+	}
+	response.KeyCount = len(response.Contents) + len(response.CommonPrefixes)
+	response.NextContinuationToken = page.NextCursor
+	response.IsTruncated = page.NextCursor != ""
+	return c.XML(200, response)
+}
+
+// s3DeleteObjects deletes the objects listed in the request (DeleteObjects)
+func s3DeleteObjects(sc *ServerConfig, c echo.Context, bucket string) error {
+	var req s3DeleteRequest
+	if err := xml.NewDecoder(io.LimitReader(c.Request().Body, 1<<20)).Decode(&req); err != nil {
+		return s3ErrorResponseFor(c, errS3MalformedXML)
+	}
+
+	response := s3DeleteResponse{Xmlns: s3Namespace}
+	for _, object := range req.Objects {
+		fileName, err := s3ObjectName(bucket, object.Key)
+		if err == nil && !sc.allows(c, ScopeDelete, fileName) {
+			err = errS3AccessDenied
+		}
+		if err == nil {
+			err = sc.deleteFile(sc.echoActor(c), fileName)
+		}
+		if err != nil && err != ErrFileDoesntExist {
+			s3Err, ok := err.(*s3Error)
+			if !ok {
+				logrus.Error("Error while trying to delete object ", err)
+				s3Err = errS3InternalError
+			}
+			response.Errors = append(response.Errors, s3DeleteError{
+				Key:     object.Key,
+				Code:    s3Err.Code,
+				Message: s3Err.Message,
+			})
+			continue
+		}
+		if !req.Quiet {
+			response.Deleted = append(response.Deleted, s3DeletedKey{Key: object.Key})
+		}
+	}
+	return c.XML(200, response)
+}
+
+// S3ObjectRoute handles the object and multipart upload operations
+func s3ObjectRoute(sc *ServerConfig) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		bucket := c.Param("bucket")
+		key, err := fileNameParam(c)
+		if err != nil {
+			return s3ErrorResponseFor(c, errS3InvalidArgument)
+		}
+		if key == "" {
+			return s3BucketRoute(sc)(c)
+		}
+		fileName, err := s3ObjectName(bucket, key)
+		if err != nil {
+			return s3ErrorResponseFor(c, err)
+		}
+
+		query := c.QueryParams()
+		uploadID := query.Get("uploadId")
+		if !sc.allows(c, s3ObjectScope(c.Request().Method, uploadID), fileName) {
+			return s3ErrorResponseFor(c, errS3AccessDenied)
+		}
+		switch c.Request().Method {
+		case http.MethodGet, http.MethodHead:
+			err = s3GetObject(sc, c, fileName)
+		case http.MethodPut:
+			if uploadID != "" {
+				err = s3UploadPart(sc, c, fileName, uploadID)
+			} else if c.Request().Header.Get("X-Amz-Copy-Source") != "" {
+				err = errS3NotImplemented
+			} else {
+				err = s3PutObject(sc, c, fileName)
+			}
+		case http.MethodDelete:
+			if uploadID != "" {
+				err = s3AbortMultipart(sc, c, fileName, uploadID)
+			} else {
+				err = sc.deleteFile(sc.echoActor(c), fileName)
+				if err == nil || err == ErrFileDoesntExist {
+					return c.NoContent(204)
+				}
+			}
+		case http.MethodPost:
+			if query.Has("uploads") {
+				err = s3CreateMultipart(sc, c, bucket, key, fileName)
+			} else if uploadID != "" {
+				err = s3CompleteMultipart(sc, c, bucket, key, fileName, uploadID)
+			} else {
+				err = errS3NotImplemented
+			}
+		default:
+			err = errS3NotImplemented
+		}
+
+		if err != nil {
+			return s3ErrorResponseFor(c, err)
+		}
+		return nil
+	}
+}
+
+// s3ObjectScope returns the scope an object operation requires, multipart
+// uploads write the object even when they are aborted
+func s3ObjectScope(method, uploadID string) Scope {
+	switch {
+	case method == http.MethodGet || method == http.MethodHead:
+		return ScopeRead
+	case method == http.MethodDelete && uploadID == "":
+		return ScopeDelete
+	}
+	return ScopeWrite
+}
+
+// s3ETag returns the ETag of an object, the md5 of its content if it's indexed
+func s3ETag(file FileResponse) string {
+	if file.S3ETag != "" {
+		return file.S3ETag
+	}
+	return file.ETag
+}
+
+// s3GetObject writes the content of an object, supporting range and conditional requests
+func s3GetObject(sc *ServerConfig, c echo.Context, fileName string) error {
+	file, store, err := sc.openFile(fileName)
+	if err == ErrFileDoesntExist {
+		return errS3NoSuchKey
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	header := c.Response().Header()
+	contentType := store.ContentType
+	if contentType == "" {
+		contentType = echo.MIMEOctetStream
+	}
+	header.Set(echo.HeaderContentType, contentType)
+	etag := store.ETag()
+	if file, ok := sc.index.get(fileName); ok {
+		etag = s3ETag(file)
+	}
+	header.Set("ETag", etag)
+	for key, value := range store.Metadata {
+		header.Set(s3MetadataHeaderPrefix+key, value)
+	}
+	http.ServeContent(c.Response(), c.Request(), "", store.ModifiedAt, file)
+	return nil
+}
+
+// s3ContentLength returns the size of the payload, chunked payloads declare the decoded size
+func s3ContentLength(r *http.Request) (int64, error) {
+	size := r.ContentLength
+	if decoded := r.Header.Get("X-Amz-Decoded-Content-Length"); decoded != "" {
+		var err error
+		if size, err = strconv.ParseInt(decoded, 10, 64); err != nil {
+			return 0, errS3InvalidArgument
+		}
+	}
+	if size < 0 {
+		return 0, errS3MissingContentLength
+	}
+	return size, nil
+}
+
+// s3Body returns the payload of a request, verifying the Content-MD5 if set
+func s3Body(r *http.Request, size int64) (io.Reader, error) {
+	contentMD5 := r.Header.Get("Content-Md5")
+	if contentMD5 == "" {
+		return r.Body, nil
+	}
+	expected, err := base64.StdEncoding.DecodeString(contentMD5)
+	if err != nil || len(expected) != md5.Size {
+		return nil, errS3InvalidArgument
+	}
+	return newVerifyReader(r.Body, size, md5.New(), expected, errS3BadDigest), nil
+}
+
+// s3PutObject stores the payload as an object, replacing an existing object (PutObject)
+func s3PutObject(sc *ServerConfig, c echo.Context, fileName string) error {
+	req := c.Request()
+	size, err := s3ContentLength(req)
+	if err != nil {
+		return err
+	}
+	if size > sc.MaxFileSize {
+		return errS3EntityTooLarge
+	}
+	if size == 0 && sc.storeVersion() == FSStoreV1 {
+		return errS3EmptyObject
+	}
+	body, err := s3Body(req, size)
+	if err != nil {
+		return err
+	}
+
+	store := &FileStore{
+		FileName:    fileName,
+		DataSize:    size,
+		Reader:      body,
+		ContentType: req.Header.Get(echo.HeaderContentType),
+		Metadata:    s3MetadataFromHeader(req.Header),
+	}
+	if err := sc.createFileStore(sc.echoActor(c), store, true); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return errS3IncompleteBody
+		}
+		return err
+	}
+
+	c.Response().Header().Set("ETag", store.S3ETag)
+	return c.NoContent(200)
+}
+
+// s3MetadataFromHeader returns the object metadata from the x-amz-meta- headers
+func s3MetadataFromHeader(header http.Header) map[string]string {
+	var metadata map[string]string
+	for key, values := range header {
+		if !strings.HasPrefix(key, s3MetadataHeaderPrefix) || len(values) == 0 {
+			continue
+		}
+		if metadata == nil {
+			metadata = make(map[string]string)
+		}
+		metadata[strings.ToLower(strings.TrimPrefix(key, s3MetadataHeaderPrefix))] = values[0]
+	}
+	return metadata
+}
+
+// s3UploadDir returns the staging directory and the session of a multipart
+// upload of an object, the ids are prefixed so the upload session routes
+// can't access them
+func (sc *ServerConfig) s3UploadDir(uploadID, fileName string) (string, *s3Multipart, error) {
+	if b, err := hex.DecodeString(uploadID); err != nil || len(b) != 16 {
+		return "", nil, errS3NoSuchUpload
+	}
+	dir := filepath.Join(sc.DataDir, uploadDirName, "s3-"+uploadID)
+	b, err := os.ReadFile(filepath.Join(dir, "multipart.json"))
+	if os.IsNotExist(err) {
+		return "", nil, errS3NoSuchUpload
+	} else if err != nil {
+		return "", nil, err
+	}
+	var multipart s3Multipart
+	if err := json.Unmarshal(b, &multipart); err != nil {
+		return "", nil, err
+	}
+	// the upload belongs to the bucket and key it was created for
+	if multipart.FileName != fileName {
+		return "", nil, errS3NoSuchUpload
+	}
+	return dir, &multipart, nil
+}
+
+// s3CreateMultipart creates a multipart upload of an object (CreateMultipartUpload)
+func s3CreateMultipart(sc *ServerConfig, c echo.Context, bucket, key, fileName string) error {
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		return err
+	}
+	uploadID := hex.EncodeToString(idBytes)
+
+	dir := filepath.Join(sc.DataDir, uploadDirName, "s3-"+uploadID)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	b, err := json.Marshal(s3Multipart{
+		FileName:    fileName,
+		ContentType: c.Request().Header.Get(echo.HeaderContentType),
+		Metadata:    s3MetadataFromHeader(c.Request().Header),
+		CreatedAt:   time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "multipart.json"), b, 0644); err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+
+	return c.XML(200, s3InitiateMultipartResponse{
+		Xmlns:    s3Namespace,
+		Bucket:   bucket,
+		Key:      key,
+		UploadID: uploadID,
+	})
+}
+
+// s3PartPath returns the path of a staged part
+func s3PartPath(dir string, number int) string {
+	return filepath.Join(dir, fmt.Sprintf("part-%05d", number))
+}
+
+// s3UploadPart stages a part of a multipart upload (UploadPart)
+func s3UploadPart(sc *ServerConfig, c echo.Context, fileName, uploadID string) error {
+	dir, _, err := sc.s3UploadDir(uploadID, fileName)
+	if err != nil {
+		return err
+	}
+	number, err := strconv.Atoi(c.QueryParam("partNumber"))
+	if err != nil || number < 1 || number > 10000 {
+		return errS3InvalidArgument
+	}
+
+	req := c.Request()
+	size, err := s3ContentLength(req)
+	if err != nil {
+		return err
+	}
+	if size > sc.MaxFileSize {
+		return errS3EntityTooLarge
+	}
+	body, err := s3Body(req, size)
+	if err != nil {
+		return err
+	}
+
+	// write and rename so a retried part replaces the previous one atomically
+	part, err := os.CreateTemp(dir, tempFilePrefix+"*")
+	if err != nil {
+		return err
+	}
+	h := md5.New()
+	_, err = io.CopyN(io.MultiWriter(part, h), body, size)
+	if err == nil {
+		err = part.Sync()
+	}
+	part.Close()
+	if err == io.EOF {
+		err = errS3IncompleteBody
+	}
+	if err == nil {
+		err = os.Rename(part.Name(), s3PartPath(dir, number))
+	}
+	if err != nil {
+		os.Remove(part.Name())
+		return err
+	}
+
+	c.Response().Header().Set("ETag", `"`+hex.EncodeToString(h.Sum(nil))+`"`)
+	return c.NoContent(200)
+}
+
+// s3CompleteMultipart creates the object from the parts of a multipart upload (CompleteMultipartUpload)
+func s3CompleteMultipart(sc *ServerConfig, c echo.Context, bucket, key, fileName, uploadID string) error {
+	dir, multipart, err := sc.s3UploadDir(uploadID, fileName)
+	if err != nil {
+		return err
+	}
+
+	var req s3CompleteMultipartRequest
+	if err := xml.NewDecoder(io.LimitReader(c.Request().Body, 1<<20)).Decode(&req); err != nil ||
+		len(req.Parts) == 0 {
+		return errS3MalformedXML
+	}
+
+	// the object is the concatenation of the listed parts, its ETag is the md5
+	// of the part md5s and the number of parts
+	readers := make([]io.Reader, 0, len(req.Parts))
+	partMD5s := md5.New()
+	var size int64
+	for i, p := range req.Parts {
+		if i > 0 && p.PartNumber <= req.Parts[i-1].PartNumber {
+			return errS3InvalidPartOrder
+		}
+		part, err := os.Open(s3PartPath(dir, p.PartNumber))
+		if os.IsNotExist(err) {
+			return errS3InvalidPart
+		} else if err != nil {
+			return err
+		}
+		defer part.Close()
+
+		h := md5.New()
+		n, err := io.Copy(h, part)
+		if err != nil {
+			return err
+		}
+		if strings.Trim(p.ETag, `"`) != hex.EncodeToString(h.Sum(nil)) {
+			return errS3InvalidPart
+		}
+		partMD5s.Write(h.Sum(nil))
+		if _, err := part.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		readers = append(readers, part)
+		size += n
+	}
+	if size > sc.MaxFileSize {
+		return errS3EntityTooLarge
+	}
+	if size == 0 && sc.storeVersion() == FSStoreV1 {
+		return errS3EmptyObject
+	}
+
+	store := &FileStore{
+		FileName:    fileName,
+		DataSize:    size,
+		Reader:      io.MultiReader(readers...),
+		ContentType: multipart.ContentType,
+		Metadata:    multipart.Metadata,
+		S3ETag:      fmt.Sprintf(`"%x-%d"`, partMD5s.Sum(nil), len(req.Parts)),
+	}
+	if err := sc.createFileStore(sc.echoActor(c), store, true); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		logrus.Warn("Error while removing multipart upload ", err)
+	}
+
+	return c.XML(200, s3CompleteMultipartResponse{
+		Xmlns:    s3Namespace,
+		Location: "/" + bucket + "/" + key,
+		Bucket:   bucket,
+		Key:      key,
+		ETag:     store.S3ETag,
+	})
+}
+
+// s3AbortMultipart removes a multipart upload and its parts (AbortMultipartUpload)
+func s3AbortMultipart(sc *ServerConfig, c echo.Context, fileName, uploadID string) error {
+	dir, _, err := sc.s3UploadDir(uploadID, fileName)
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	return c.NoContent(204)
+}
@@ -1,18 +1,21 @@
 package server
 
 import (
+	"context"
+	"crypto/sha256"
 	"errors"
+	"fmt"
 	"io"
-	"os"
-	"path/filepath"
-	"strings"
+	"net/http"
 	"sync"
+	"time"
 
 	. "fs-store/types"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
 )
 
 // ServerConfig is the configuration for server properties
@@ -24,10 +27,83 @@ type ServerConfig struct {
 	MaxFileSize int64
 	MaxListSize int
 
+	// Timeouts of the HTTP listeners for reading requests, writing responses
+	// and keeping idle connections, no timeout when 0
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+
+	// Version is the file store version new files are written in
+	Version FSVersion
+
+	// Build is the version and revision reported by /info
+	Build BuildInfo
+
+	// MinFreeSpace is the free space in bytes the data directory needs for /readyz
+	MinFreeSpace int64
+
+	// UploadTTL is how long upload sessions and S3 multipart uploads are kept
+	// without activity while the server runs, they never expire when 0
+	UploadTTL time.Duration
+
+	// Backend stores the files, upload sessions are staged in the data directory
+	Backend Backend
+
+	// APIKeys authenticates requests with bearer API keys if set
+	APIKeys *APIKeys
+
+	// ACL restricts the actions of API keys on file names by a policy if set
+	ACL *ACLPolicy
+
+	// Audit records every upload, overwrite and delete if set
+	Audit *AuditLog
+
+	// TLS serves every API over TLS if set
+	TLS *TLSConfig
+
+	// SignSecrets verifies signed URLs and signs new ones if set
+	SignSecrets *SignSecrets
+
+	// S3 enables the S3 compatible frontend if set
+	S3 *S3Config
+
+	// GRPCAddress serves the gRPC API on the address if set
+	GRPCAddress string
+
+	// WebDAVPath serves the files over WebDAV under the path if set
+	WebDAVPath string
+
+	// MetricsAddress serves the metrics and probes on a separate listener
+	// without authentication if set, the metrics are served at /metrics of
+	// the address otherwise
+	MetricsAddress string
+
 	mapLock *sync.RWMutex
 	mtxMap  map[string]*sync.Mutex
+	// lockRefs counts the holders and waiters of the mutexes of mtxMap, a
+	// mutex is removed when the count drops to zero
+	lockRefs map[string]int
+
+	uploadLock *sync.Mutex
+
+	index *fileIndex
+
+	metrics *serverMetrics
+
+	// runLock guards the listeners and the upload sweep started by StartServer for Shutdown
+	runLock    *sync.Mutex
+	servers    []*echo.Echo
+	grpcServer *grpc.Server
+	sweepStop  chan struct{}
+
+	// activeOps counts active uploads and deletes, draining is set by Shutdown
+	activeOps int64
+	draining  int32
 }
 
+// DefaultMaxListSize is the default maximum number of files of a list page
+const DefaultMaxListSize = 255
+
 // Define Errors
 var (
 	// ErrFileAlreadyExists is returned when a file already exists
@@ -35,14 +111,23 @@ var (
 
 	// ErrFileDoesntExist is returned when a file doesn't exists
 	ErrFileDoesntExist = errors.New("file doesn't exist")
+
+	// ErrEmptyFile is returned when writing an empty file in a version which can't store it
+	ErrEmptyFile = errors.New("file is empty")
 )
 
+// NewServerConfig creates a server config storing files on disk in the data directory
 func NewServerConfig(address, dataDir string, maxFileSize int64, logLevel string) (*ServerConfig, error) {
-	err := os.MkdirAll(dataDir, os.ModePerm)
+	backend, err := NewDiskBackend(dataDir)
 	if err != nil {
 		return nil, err
 	}
+	return NewServerConfigWithBackend(address, dataDir, backend, maxFileSize, logLevel)
+}
 
+// NewServerConfigWithBackend creates a server config storing files in the backend,
+// the index is kept in the data directory for the disk backend and in memory otherwise
+func NewServerConfigWithBackend(address, dataDir string, backend Backend, maxFileSize int64, logLevel string) (*ServerConfig, error) {
 	level, err := logrus.ParseLevel(logLevel)
 	if err != nil {
 		return nil, err
@@ -56,32 +141,119 @@ func NewServerConfig(address, dataDir string, maxFileSize int64, logLevel string
 	logrus.WithFields(logrus.Fields{
 		"address":     address,
 		"dataDir":     dataDir,
+		"backend":     fmt.Sprintf("%T", backend),
 		"maxFileSize": maxFileSize,
 		"logLevel":    logLevel,
 	}).Info("Creating server config")
 
-	return &ServerConfig{
+	indexDir := ""
+	if disk, ok := backend.(*DiskBackend); ok {
+		indexDir = disk.Dir
+	}
+	index, err := loadIndex(indexDir, backend)
+	if err != nil {
+		return nil, err
+	}
+
+	sc := &ServerConfig{
 		DataDir:     dataDir,
 		Address:     address,
 		MaxFileSize: maxFileSize,
-		MaxListSize: 255,
+		MaxListSize: DefaultMaxListSize,
+		UploadTTL:   DefaultUploadTTL,
+		Version:     DefaultVersion,
+		Backend:     backend,
 		mapLock:     &sync.RWMutex{},
 		mtxMap:      make(map[string]*sync.Mutex, 255),
-	}, nil
+		lockRefs:    make(map[string]int, 255),
+		uploadLock:  &sync.Mutex{},
+		index:       index,
+		runLock:     &sync.Mutex{},
+	}
+	sc.metrics = newServerMetrics(sc)
+	return sc, nil
 }
 
+// StartServer serves the APIs until a listener fails or Shutdown is called,
+// the caller has to wait for Shutdown to return before exiting
 func (sc *ServerConfig) StartServer() error {
-	e := echo.New()
+	e := sc.newEcho()
+	errs := make(chan error, 4)
+
+	listeners := 1
+	sc.runLock.Lock()
+	sc.servers = []*echo.Echo{e}
+	if sc.S3 != nil {
+		s3 := sc.newS3Echo()
+		sc.servers = append(sc.servers, s3)
+		listeners++
+		logrus.Info("Starting S3 frontend at ", sc.S3.Address)
+		go func() {
+			errs <- sc.startEcho(s3, sc.S3.Address)
+		}()
+	}
+
+	if sc.GRPCAddress != "" {
+		sc.grpcServer = sc.newGRPCServer()
+		listeners++
+		logrus.Info("Starting gRPC server at ", sc.GRPCAddress)
+		go func(s *grpc.Server) {
+			errs <- sc.startGRPCServer(s)
+		}(sc.grpcServer)
+	}
+
+	if sc.MetricsAddress != "" {
+		metrics := sc.newMetricsEcho()
+		sc.servers = append(sc.servers, metrics)
+		listeners++
+		logrus.Info("Starting metrics listener at ", sc.MetricsAddress)
+		go func() {
+			errs <- sc.startEcho(metrics, sc.MetricsAddress)
+		}()
+	}
+
+	if sc.UploadTTL > 0 {
+		sc.sweepStop = make(chan struct{})
+		go sc.sweepUploadsUntil(sc.sweepStop)
+	}
+	sc.runLock.Unlock()
 
 	logrus.Info("Starting server at ", sc.Address)
 
+	// Start server
+	go func() {
+		errs <- sc.startEcho(e, sc.Address)
+	}()
+	err := <-errs
+	if err == http.ErrServerClosed || sc.isDraining() {
+		return nil
+	}
+
+	// stop the other listeners so none keep running after a failed one
+	logrus.Error("Listener failed, stopping the server: ", err)
+	ctx, cancel := context.WithTimeout(context.Background(), stopTimeout)
+	defer cancel()
+	sc.Shutdown(ctx)
+	for i := 1; i < listeners; i++ {
+		<-errs
+	}
+	return err
+}
+
+// newEcho creates the echo instance with middleware and routes registered
+func (sc *ServerConfig) newEcho() *echo.Echo {
+	e := echo.New()
+
 	// Hide initial messages
 	e.HideBanner = true
 	e.HidePort = true
 
 	// Middleware
+	e.Use(middleware.RequestID())
+	e.Use(sc.metricsMiddleware("rest"))
 	e.Use(middleware.RequestLoggerWithConfig(middleware.RequestLoggerConfig{
 		LogURI:       true,
+		LogRequestID: true,
 		LogRemoteIP:  true,
 		LogMethod:    true,
 		LogLatency:   true,
@@ -96,6 +268,7 @@ func (sc *ServerConfig) StartServer() error {
 				"latency":   values.Latency,
 				"ip":        values.RemoteIP,
 				"userAgent": values.UserAgent,
+				"requestId": values.RequestID,
 			}
 			logrus.WithFields(fields).
 				Info("request")
@@ -105,128 +278,241 @@ func (sc *ServerConfig) StartServer() error {
 	}))
 	// e.Use(middleware.Recover())
 
+	// Signed URLs
+	if sc.SignSecrets != nil {
+		e.Use(sc.signedURLMiddleware())
+	}
+
+	// Authentication
+	if sc.APIKeys != nil {
+		e.Use(sc.authMiddleware())
+	}
+
+	// WebDAV
+	if sc.WebDAVPath != "" {
+		e.Use(sc.webdavMiddleware())
+	}
+
 	// Get File List
 	e.GET("/files", listFilesRoute(sc))
 
+	// Download File
+	e.GET("/files/*", downloadFileRoute(sc))
+	e.HEAD("/files/*", downloadFileRoute(sc))
+
 	// Update File
 	e.POST("/files", uploadFileRoute(sc))
+	e.PUT("/files/*", putFileRoute(sc))
 
 	// Delete File
 	e.DELETE("/files", deleteFileRoute(sc))
 
-	// Start server
-	return e.Start(sc.Address)
+	// Upload Sessions
+	e.POST("/uploads", createUploadRoute(sc))
+	e.GET("/uploads/:id", getUploadRoute(sc))
+	e.PUT("/uploads/:id/chunks/:number", uploadChunkRoute(sc))
+	e.POST("/uploads/:id/commit", commitUploadRoute(sc))
+	e.DELETE("/uploads/:id", abortUploadRoute(sc))
+
+	// Sign URLs
+	e.POST("/sign", signRoute(sc))
+
+	// Check Files
+	e.POST("/admin/fsck", fsckRoute(sc))
+
+	// Probes and Info
+	e.GET("/healthz", healthzRoute(sc))
+	e.GET("/readyz", readyzRoute(sc))
+	e.GET("/info", infoRoute(sc))
+
+	// Metrics
+	if sc.MetricsAddress == "" {
+		e.GET("/metrics", sc.metricsHandler())
+	}
+
+	return e
 }
 
 // acquireLock acquires a lock for a file, and return that lock
 func (sc *ServerConfig) acquireLock(keyName string) *sync.Mutex {
-	// Acquire read lock to check whether mutext exists
-	sc.mapLock.RLock()
-	if keyNameMtx, ok := sc.mtxMap[keyName]; !ok {
-		// release read lock so write lock can be acquired
-		sc.mapLock.RUnlock()
-
-		// acquire lock, then check if mutex exists again (double-checked locking)
-		sc.mapLock.Lock()
-		if _, ok := sc.mtxMap[keyName]; !ok {
-			newMutex := &sync.Mutex{}
-			newMutex.Lock()
-			sc.mtxMap[keyName] = newMutex
-		}
-		sc.mapLock.Unlock()
-	} else {
-		sc.mapLock.RUnlock()
-		keyNameMtx.Lock()
+	start := time.Now()
+	defer func() {
+		sc.metrics.lockWait.Observe(time.Since(start).Seconds())
+	}()
+
+	// count the reference before waiting so the mutex isn't removed while it's awaited
+	sc.mapLock.Lock()
+	keyNameMtx, ok := sc.mtxMap[keyName]
+	if !ok {
+		keyNameMtx = &sync.Mutex{}
+		sc.mtxMap[keyName] = keyNameMtx
 	}
+	sc.lockRefs[keyName]++
+	sc.mapLock.Unlock()
 
-	return sc.mtxMap[keyName]
+	keyNameMtx.Lock()
+	return keyNameMtx
+}
+
+// releaseLock releases a lock acquired for a file, the mutex is removed once
+// no one holds or waits on it
+func (sc *ServerConfig) releaseLock(keyName string, mutex *sync.Mutex) {
+	sc.mapLock.Lock()
+	if sc.lockRefs[keyName]--; sc.lockRefs[keyName] <= 0 {
+		delete(sc.lockRefs, keyName)
+		delete(sc.mtxMap, keyName)
+	}
+	sc.mapLock.Unlock()
+	mutex.Unlock()
 }
 
 // createFile creates a file at the given path
 func (sc *ServerConfig) createFile(fileName string, size int64, data io.Reader, overwrite bool) error {
-	// create file store
-	store := &FileStore{
-		Version:  DefaultVersion,
+	return sc.createFileStore(auditActor{}, &FileStore{
 		FileName: fileName,
 		Reader:   data,
 		DataSize: size,
+	}, overwrite)
+}
+
+// storeVersion returns the version new files are written in
+func (sc *ServerConfig) storeVersion() FSVersion {
+	if sc.Version == 0 {
+		return DefaultVersion
 	}
-	logrus.Info("acquire lock for ", fileName)
-	mutex := sc.acquireLock(fileName)
-	logrus.Info("release lock for ", fileName)
-	defer mutex.Unlock()
+	return sc.Version
+}
+
+// createFileStore creates a file from a file store using the configured
+// version, the upload is recorded in the audit log with the actor
+func (sc *ServerConfig) createFileStore(actor auditActor, store *FileStore, overwrite bool) error {
+	store.Version = sc.storeVersion()
+	store.CreatedAt = time.Now()
+	store.ModifiedAt = store.CreatedAt
+
+	sc.beginOp()
+	defer sc.endOp()
+	sc.metrics.activeUploads.Inc()
+	defer sc.metrics.activeUploads.Dec()
+
+	logrus.Info("acquire lock for ", store.FileName)
+	mutex := sc.acquireLock(store.FileName)
+	logrus.Info("release lock for ", store.FileName)
+	defer sc.releaseLock(store.FileName, mutex)
+
+	action := AuditUpload
+	var content *hashReader
+	if sc.Audit != nil {
+		content = &hashReader{Reader: store.Reader, hash: sha256.New()}
+		store.Reader = content
+	}
+	err := sc.putFileStore(store, overwrite, &action)
+	if err == nil {
+		sc.metrics.uploadedBytes.Add(float64(store.DataSize))
+	}
+	if content != nil {
+		var sum []byte
+		if err == nil {
+			sum = content.hash.Sum(nil)
+		}
+		sc.record(actor, action, store.FileName, store.DataSize, sum, err)
+	}
+	return err
+}
+
+// putFileStore stores the file store and its index entry, the action is set
+// to overwrite if the file existed
+func (sc *ServerConfig) putFileStore(store *FileStore, overwrite bool, action *AuditAction) error {
 	// After acquiring lock, check if file exists (double-checked locking)
-	if exists, err := fileExists(sc.DataDir, fileName); err != nil {
+	if prev, err := sc.Backend.Stat(store.FileName); err != nil && err != ErrFileDoesntExist {
 		return err
-	} else if exists && !overwrite {
+	} else if err == nil && !overwrite {
 		return ErrFileAlreadyExists
+	} else if err == nil {
+		*action = AuditOverwrite
+		if store.Version >= FSStoreV2 {
+			// keep the creation time of the overwritten file, V1 only stores a single time
+			store.CreatedAt = prev.CreatedAt
+		}
 	}
-	return store.createFileAt(sc.DataDir, overwrite)
-}
-
-// deleteFile deletes a file at the given path
-func (sc *ServerConfig) deleteFile(fileName string) error {
-	sc.mapLock.Lock()
-	delete(sc.mtxMap, fileName)
-	defer sc.mapLock.Unlock()
-
-	exists, err := fileExists(sc.DataDir, fileName)
-	if err != nil {
+	if err := sc.Backend.Put(store, overwrite); err != nil {
 		return err
-	} else if exists {
-		return deleteFileAt(sc.DataDir, fileName)
 	}
-	return ErrFileDoesntExist
+	return sc.index.put(store)
 }
 
-// getFileList returns a list of files in the given directory
-func (sc *ServerConfig) getFileList(limit int) ([]FileResponse, error) {
-	entries, err := os.ReadDir(sc.DataDir)
+// openFile opens a file for reading, the returned file must be closed by the caller
+func (sc *ServerConfig) openFile(fileName string) (FileReader, *FileStore, error) {
+	mutex := sc.acquireLock(fileName)
+	defer sc.releaseLock(fileName, mutex)
 
-	if err != nil {
-		return nil, err
+	return sc.Backend.Get(fileName)
+}
+
+// fileExists checks if a file exists in the backend
+func (sc *ServerConfig) fileExists(fileName string) (bool, error) {
+	_, err := sc.Backend.Stat(fileName)
+	if err == ErrFileDoesntExist {
+		return false, nil
 	}
+	return err == nil, err
+}
 
-	files := make([]FileResponse, 0)
-	for i, entry := range entries {
-		if i >= limit {
-			break
-		}
+// deleteFile deletes a file at the given path, the deletion is recorded in
+// the audit log with the actor
+func (sc *ServerConfig) deleteFile(actor auditActor, fileName string) error {
+	sc.beginOp()
+	defer sc.endOp()
 
-		entryName := entry.Name()
-		if entry.IsDir() || !strings.HasSuffix(entryName, ".fs") {
-			continue
-		}
+	// hold the lock of the file so an upload can't store its index entry after the delete
+	mutex := sc.acquireLock(fileName)
+	defer sc.releaseLock(fileName, mutex)
 
-		m := sc.acquireLock(entryName)
-		path := filepath.Join(sc.DataDir, entryName)
-		file, err := os.OpenFile(path, os.O_RDONLY, 0644)
+	file, _ := sc.index.get(fileName)
+	err := sc.Backend.Delete(fileName)
+	if err == nil {
+		sc.metrics.deletedBytes.Add(float64(file.FileSize))
+		err = sc.index.delete(fileName)
+	}
+	if err != ErrFileDoesntExist {
+		sc.record(actor, AuditDelete, fileName, file.FileSize, nil, err)
+	}
+	return err
+}
 
-		// We acquired lock after reading director,
-		// 	the file might not exist anymore, in this case we skip it
-		if os.IsNotExist(err) {
+// deleteFiles deletes every file starting with the prefix and returns the deleted names
+func (sc *ServerConfig) deleteFiles(actor auditActor, prefix string) ([]string, error) {
+	deleted := []string{}
+	for _, fileName := range sc.index.names(prefix) {
+		err := sc.deleteFile(actor, fileName)
+		if err == ErrFileDoesntExist {
+			// deleted in the meantime
 			continue
+		} else if err != nil {
+			return deleted, err
 		}
-		if err != nil {
-			m.Unlock()
-			return nil, err
-		}
-		store, err := parseFileStore(file)
-		if err != nil {
-			m.Unlock()
-			return nil, err
-		}
-
-		files = append(files, FileResponse{
-			FileName:  store.FileName,
-			FileSize:  store.DataSize,
-			CreatedAt: store.CreatedAt,
-		})
-
-		m.Unlock()
+		deleted = append(deleted, fileName)
 	}
+	return deleted, nil
+}
 
-	// release lock
+// getFileList returns a list of files from the index
+func (sc *ServerConfig) getFileList(limit int) ([]FileResponse, error) {
+	page, err := sc.listFiles(listOptions{Limit: limit})
+	if err != nil {
+		return nil, err
+	}
+	return page.Files, nil
+}
 
-	return files, nil
+// listFiles returns a page of files from the index
+func (sc *ServerConfig) listFiles(opts listOptions) (*FileListResponse, error) {
+	if err := opts.validate(sc.MaxListSize); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	defer func() {
+		sc.metrics.listDuration.Observe(time.Since(start).Seconds())
+	}()
+	return sc.index.list(opts)
 }
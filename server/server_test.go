@@ -8,6 +8,7 @@ import (
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -19,19 +20,31 @@ func getServerConfig(t *testing.T) *ServerConfig {
 	return sc
 }
 
+// mtxState returns the state of a sync.Mutex value, newer go versions wrap
+// the state in an internal mutex
+func mtxState(m reflect.Value) int64 {
+	if state := m.FieldByName("state"); state.IsValid() {
+		return state.Int()
+	}
+	return m.FieldByName("mu").FieldByName("state").Int()
+}
+
 func IsMtxLocked(m *sync.Mutex) bool {
-	state := reflect.ValueOf(m).Elem().FieldByName("state")
-	return state.Int()&1 == 1
+	return mtxState(reflect.ValueOf(m).Elem())&1 == 1
 }
 
 func IsMtxWriteLocked(rw *sync.RWMutex) bool {
 	// RWMutex has a "w" sync.Mutex field for write lock
-	state := reflect.ValueOf(rw).Elem().FieldByName("w").FieldByName("state")
-	return state.Int()&1 == 1
+	return mtxState(reflect.ValueOf(rw).Elem().FieldByName("w"))&1 == 1
 }
 
 func IsMtxReadLocked(rw *sync.RWMutex) bool {
-	return reflect.ValueOf(rw).Elem().FieldByName("readerCount").Int() > 0
+	readerCount := reflect.ValueOf(rw).Elem().FieldByName("readerCount")
+	if readerCount.Kind() == reflect.Struct {
+		// atomic.Int32 stores its value in "v"
+		readerCount = readerCount.FieldByName("v")
+	}
+	return readerCount.Int() > 0
 }
 
 // Test_Mutex tests the mutex test helper functions
@@ -115,6 +128,42 @@ func Test_AcquireLockExistingKey(t *testing.T) {
 	}
 }
 
+// Test_AcquireLockWaitingDelete tests that a waiter gets the mutex it waited on when the file is deleted
+func Test_AcquireLockWaitingDelete(t *testing.T) {
+	fileName := "test.txt"
+	sc := getServerConfig(t)
+	defer os.RemoveAll(sc.DataDir)
+
+	mtx := sc.acquireLock(fileName)
+	waited := make(chan *sync.Mutex, 1)
+	go func() {
+		waiter := sc.acquireLock(fileName)
+		waited <- waiter
+		sc.releaseLock(fileName, waiter)
+	}()
+	for i := 0; i < 100; i++ {
+		sc.mapLock.RLock()
+		refs := sc.lockRefs[fileName]
+		sc.mapLock.RUnlock()
+		if refs == 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	deleted := make(chan error, 1)
+	go func() {
+		deleted <- sc.deleteFile(auditActor{}, fileName)
+	}()
+	sc.releaseLock(fileName, mtx)
+
+	assert.Equal(t, mtx, <-waited, "Waiter didn't get the mutex it waited on")
+	assert.ErrorIs(t, <-deleted, ErrFileDoesntExist)
+	sc.mapLock.RLock()
+	defer sc.mapLock.RUnlock()
+	assert.Empty(t, sc.mtxMap, "Mutex is still in map after it was released")
+}
+
 // Test_ServerConfig_createFile test the creation of a file
 func Test_ServerConfig_createFile(t *testing.T) {
 	sc := getServerConfig(t)
@@ -219,10 +268,10 @@ func Test_ServerConfig_deleteFile(t *testing.T) {
 	}
 	defer os.RemoveAll(sc.DataDir)
 
-	err = sc.deleteFile(fn)
+	err = sc.deleteFile(auditActor{}, fn)
 	assert.NoError(t, err, "Error when deleting file")
 
-	exists, err := fileExists(sc.DataDir, fn)
+	exists, err := sc.fileExists(fn)
 	assert.NoError(t, err, "Error when checking if file exists")
 	assert.False(t, exists, "File was not deleted")
 
@@ -230,6 +279,31 @@ func Test_ServerConfig_deleteFile(t *testing.T) {
 	assert.False(t, hasMtx, "Mutex is still in map after file was deleted")
 }
 
+// Test_ServerConfig_deleteFileLock tests that a delete waits for the lock of the file
+func Test_ServerConfig_deleteFileLock(t *testing.T) {
+	sc := getServerConfig(t)
+	defer os.RemoveAll(sc.DataDir)
+
+	fn := "delete_lock_test.txt"
+	if !assert.NoError(t, sc.createFile(fn, 4, strings.NewReader("data"), false), "Error creating file") {
+		return
+	}
+
+	mtx := sc.acquireLock(fn)
+	deleted := make(chan error, 1)
+	go func() {
+		deleted <- sc.deleteFile(auditActor{}, fn)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	_, indexed := sc.index.get(fn)
+	assert.True(t, indexed, "File was deleted while its lock was held")
+	sc.releaseLock(fn, mtx)
+
+	assert.NoError(t, <-deleted, "Error when deleting file")
+	_, indexed = sc.index.get(fn)
+	assert.False(t, indexed, "File is still in the index after it was deleted")
+}
+
 // Test_ServerConfig_getFileList test the listing of files
 func Test_ServerConfig_getFileList(t *testing.T) {
 	// test list files + delete concurreny
@@ -257,3 +331,59 @@ func Test_ServerConfig_getFileList(t *testing.T) {
 		}
 	}
 }
+
+// Test_ServerConfig_openFile test the opening of a file for reading
+func Test_ServerConfig_openFile(t *testing.T) {
+	sc := getServerConfig(t)
+	defer os.RemoveAll(sc.DataDir)
+
+	fn := "open_file_test.txt"
+	data := "test data"
+
+	err := sc.createFile(fn, int64(len(data)), strings.NewReader(data), false)
+	if !assert.NoError(t, err, "Error creating file") {
+		return
+	}
+
+	file, store, err := sc.openFile(fn)
+	if assert.NoError(t, err, "Error opening file") {
+		defer file.Close()
+		assert.Equal(t, fn, store.FileName, "File name is not the same")
+		assert.Equal(t, int64(len(data)), store.DataSize, "File size is not the same")
+
+		dataBytes, err := io.ReadAll(store)
+		if assert.NoError(t, err, "Error reading content from file") {
+			assert.Equal(t, data, string(dataBytes), "File data is not the same")
+		}
+	}
+
+	_, _, err = sc.openFile("missing_file_test.txt")
+	assert.ErrorIs(t, err, ErrFileDoesntExist, "No error returned when opening a file that doesn't exist")
+}
+
+// Test_ServerConfig_createFile_Version test the creation of a file in a configured version
+func Test_ServerConfig_createFile_Version(t *testing.T) {
+	sc := getServerConfig(t)
+	defer os.RemoveAll(sc.DataDir)
+
+	for _, version := range SupportedVersions {
+		sc.Version = version
+		fn := "version_test.txt"
+		data := "test data"
+
+		err := sc.createFile(fn, int64(len(data)), strings.NewReader(data), true)
+		if !assert.NoError(t, err, "Error creating file") {
+			return
+		}
+
+		file, store, err := sc.openFile(fn)
+		if assert.NoError(t, err, "Error opening file") {
+			assert.Equal(t, version, store.Version, "File was not written in configured version")
+			dataBytes, err := io.ReadAll(store)
+			if assert.NoError(t, err, "Error reading content from file") {
+				assert.Equal(t, data, string(dataBytes), "File data is not the same")
+			}
+			file.Close()
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// drainPollInterval is how often shutdown checks for active operations
+	drainPollInterval = 10 * time.Millisecond
+
+	// abortTimeout is how long aborted operations get to clean up their
+	// temp files after their connections are closed
+	abortTimeout = 5 * time.Second
+
+	// stopTimeout is how long active requests get to finish when the server
+	// stops because a listener failed
+	stopTimeout = 5 * time.Second
+)
+
+// beginOp marks the start of an operation shutdown waits for
+func (sc *ServerConfig) beginOp() {
+	atomic.AddInt64(&sc.activeOps, 1)
+}
+
+// endOp marks the end of an operation started by beginOp
+func (sc *ServerConfig) endOp() {
+	atomic.AddInt64(&sc.activeOps, -1)
+}
+
+// isDraining checks if the server is shutting down
+func (sc *ServerConfig) isDraining() bool {
+	return atomic.LoadInt32(&sc.draining) == 1
+}
+
+// waitForOps waits until no upload or delete is active or the context is done
+func (sc *ServerConfig) waitForOps(ctx context.Context) error {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for atomic.LoadInt64(&sc.activeOps) > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// Shutdown stops accepting requests and waits for active requests, uploads
+// and deletes until the context is done, the connections of the remaining
+// ones are closed which aborts them and removes their temp files. The
+// context error is returned if requests had to be aborted
+func (sc *ServerConfig) Shutdown(ctx context.Context) error {
+	atomic.StoreInt32(&sc.draining, 1)
+	sc.runLock.Lock()
+	servers, grpcServer := sc.servers, sc.grpcServer
+	if sc.sweepStop != nil {
+		close(sc.sweepStop)
+		sc.sweepStop = nil
+	}
+	sc.runLock.Unlock()
+
+	logrus.WithField("activeOps", atomic.LoadInt64(&sc.activeOps)).Info("Shutting down server")
+
+	// stop the listeners and wait for active requests
+	wg := &sync.WaitGroup{}
+	for _, e := range servers {
+		wg.Add(1)
+		go func(e *echo.Echo) {
+			defer wg.Done()
+			if err := e.Shutdown(ctx); err != nil && ctx.Err() == nil {
+				logrus.Error("Error shutting down listener: ", err)
+			}
+		}(e)
+	}
+	if grpcServer != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stopped := make(chan struct{})
+			go func() {
+				grpcServer.GracefulStop()
+				close(stopped)
+			}()
+			select {
+			case <-stopped:
+			case <-ctx.Done():
+			}
+		}()
+	}
+	wg.Wait()
+
+	err := sc.waitForOps(ctx)
+	if err != nil {
+		logrus.WithField("activeOps", atomic.LoadInt64(&sc.activeOps)).
+			Warn("Drain timeout reached, aborting active requests")
+		for _, e := range servers {
+			e.Close()
+		}
+		if grpcServer != nil {
+			grpcServer.Stop()
+		}
+
+		// aborted uploads fail reading their body and remove their temp files
+		abortCtx, cancel := context.WithTimeout(context.Background(), abortTimeout)
+		defer cancel()
+		if sc.waitForOps(abortCtx) != nil {
+			logrus.Error("Operations still active after aborting: ", atomic.LoadInt64(&sc.activeOps))
+		}
+	}
+
+	// close the logs after the drain, later changes fail instead of being written after the last sync
+	if err := sc.index.close(); err != nil {
+		logrus.Error("Error closing the index: ", err)
+	}
+	if sc.Audit != nil {
+		if err := sc.Audit.Close(); err != nil {
+			logrus.Error("Error closing the audit log: ", err)
+		}
+	}
+
+	logrus.Info("Server shut down")
+	return err
+}
@@ -0,0 +1,219 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// startTestServer starts the server on a free port and returns its URL and
+// the channel StartServer returns on
+func startTestServer(t *testing.T, sc *ServerConfig) (string, chan error) {
+	sc.Address = "127.0.0.1:0"
+	errs := make(chan error, 1)
+	go func() {
+		errs <- sc.StartServer()
+	}()
+	for i := 0; i < 100; i++ {
+		sc.runLock.Lock()
+		servers := sc.servers
+		sc.runLock.Unlock()
+		if len(servers) > 0 && servers[0].ListenerAddr() != nil {
+			return "http://" + servers[0].ListenerAddr().String(), errs
+		} else if len(servers) > 0 && servers[0].TLSListenerAddr() != nil {
+			return "https://" + servers[0].TLSListenerAddr().String(), errs
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("Server not started")
+	return "", nil
+}
+
+// startSlowUpload starts uploading the file with a body written through the
+// returned pipe and returns the channel of the response status
+func startSlowUpload(t *testing.T, sc *ServerConfig, url, fileName string) (*io.PipeWriter, chan int) {
+	body, w := io.Pipe()
+	req, err := http.NewRequest("PUT", url+"/files/"+fileName, body)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	req.ContentLength = 10
+
+	status := make(chan int, 1)
+	go func() {
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			status <- 0
+			return
+		}
+		resp.Body.Close()
+		status <- resp.StatusCode
+	}()
+
+	// wait until the upload holds the lock of the file
+	w.Write([]byte("01234"))
+	for i := 0; i < 100; i++ {
+		sc.mapLock.RLock()
+		_, ok := sc.mtxMap[fileName]
+		sc.mapLock.RUnlock()
+		if ok {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return w, status
+}
+
+// Test_Shutdown_Drain tests that active uploads finish before shutting down
+func Test_Shutdown_Drain(t *testing.T) {
+	sc := getServerConfig(t)
+	defer os.RemoveAll(sc.DataDir)
+	url, errs := startTestServer(t, sc)
+
+	w, status := startSlowUpload(t, sc, url, "drain.txt")
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		assert.False(t, sc.readiness().Success, "Ready while shutting down")
+		w.Write([]byte("56789"))
+		w.Close()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.NoError(t, sc.Shutdown(ctx))
+	assert.NoError(t, <-errs)
+	assert.Equal(t, 200, <-status)
+
+	exists, err := sc.fileExists("drain.txt")
+	assert.NoError(t, err)
+	assert.True(t, exists, "Upload not finished")
+
+	_, err = http.Get(url + "/files")
+	assert.Error(t, err, "Request accepted after shutdown")
+}
+
+// Test_Shutdown_CloseLogs tests that the index log and the audit log are closed after the drain
+func Test_Shutdown_CloseLogs(t *testing.T) {
+	sc := getServerConfig(t)
+	defer os.RemoveAll(sc.DataDir)
+	var err error
+	if sc.Audit, err = OpenAuditLog(filepath.Join(sc.DataDir, "audit.log"), 0); !assert.NoError(t, err) {
+		return
+	}
+	_, errs := startTestServer(t, sc)
+
+	assert.NoError(t, sc.Shutdown(context.Background()))
+	assert.NoError(t, <-errs)
+
+	assert.ErrorIs(t, sc.createFile("closed.txt", 4, strings.NewReader("data"), false), errIndexClosed,
+		"Index changed after shutdown")
+	assert.ErrorIs(t, sc.Audit.append(AuditEntry{Action: AuditUpload}), errAuditClosed,
+		"Audit log appended after shutdown")
+	assert.NoError(t, sc.Audit.Close(), "Closing the audit log again failed")
+}
+
+// Test_Shutdown_Abort tests that uploads are aborted and cleaned up after the drain timeout
+func Test_Shutdown_Abort(t *testing.T) {
+	sc := getServerConfig(t)
+	defer os.RemoveAll(sc.DataDir)
+	url, errs := startTestServer(t, sc)
+
+	w, status := startSlowUpload(t, sc, url, "abort.txt")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, sc.Shutdown(ctx), context.DeadlineExceeded)
+	assert.NoError(t, <-errs)
+
+	// the client only notices the closed connection once its body ends
+	w.Close()
+	assert.NotEqual(t, 200, <-status, "Upload not aborted")
+	assert.Equal(t, int64(0), sc.activeOps)
+
+	exists, err := sc.fileExists("abort.txt")
+	assert.NoError(t, err)
+	assert.False(t, exists, "Aborted upload stored")
+	entries, err := os.ReadDir(sc.DataDir)
+	assert.NoError(t, err)
+	for _, entry := range entries {
+		assert.False(t, strings.HasPrefix(entry.Name(), tempFilePrefix), "Temp file left behind: %s", entry.Name())
+	}
+}
+
+// Test_Shutdown_TLS tests that TLS listeners are stopped
+func Test_Shutdown_TLS(t *testing.T) {
+	sc, _ := getTLSServerConfig(t)
+	defer os.RemoveAll(sc.DataDir)
+	url, errs := startTestServer(t, sc)
+	assert.True(t, strings.HasPrefix(url, "https://"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.NoError(t, sc.Shutdown(ctx))
+	select {
+	case err := <-errs:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("TLS listener not stopped")
+	}
+}
+
+// Test_StartServer_Timeouts tests that the timeouts are set on the listeners
+func Test_StartServer_Timeouts(t *testing.T) {
+	sc := getServerConfig(t)
+	defer os.RemoveAll(sc.DataDir)
+	sc.ReadTimeout, sc.WriteTimeout, sc.IdleTimeout = time.Minute, 2*time.Minute, 3*time.Minute
+	_, errs := startTestServer(t, sc)
+
+	sc.runLock.Lock()
+	s := sc.servers[0].Server
+	sc.runLock.Unlock()
+	assert.Equal(t, time.Minute, s.ReadTimeout)
+	assert.Equal(t, 2*time.Minute, s.WriteTimeout)
+	assert.Equal(t, 3*time.Minute, s.IdleTimeout)
+
+	assert.NoError(t, sc.Shutdown(context.Background()))
+	assert.NoError(t, <-errs)
+}
+
+// Test_StartServer_ListenerFails tests that the other listeners are stopped when one fails
+func Test_StartServer_ListenerFails(t *testing.T) {
+	sc := getServerConfig(t)
+	defer os.RemoveAll(sc.DataDir)
+
+	// the metrics address is taken
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer taken.Close()
+	sc.Address = "127.0.0.1:0"
+	sc.MetricsAddress = taken.Addr().String()
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- sc.StartServer()
+	}()
+	select {
+	case err := <-errs:
+		assert.Error(t, err, "Failed listener not reported")
+	case <-time.After(5 * time.Second):
+		t.Fatal("Server still running after a listener failed")
+	}
+
+	sc.runLock.Lock()
+	e := sc.servers[0]
+	sc.runLock.Unlock()
+	if addr := e.ListenerAddr(); addr != nil {
+		_, err := net.DialTimeout("tcp", addr.String(), time.Second)
+		assert.Error(t, err, "REST listener still accepting connections")
+	}
+}
@@ -0,0 +1,475 @@
+package server
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// sigV4Algorithm is the only signing algorithm supported
+	sigV4Algorithm = "AWS4-HMAC-SHA256"
+
+	// sigV4TimeFormat is the format of the signing time
+	sigV4TimeFormat = "20060102T150405Z"
+
+	// sigV4MaxSkew is the maximum difference between the signing time and now
+	sigV4MaxSkew = 15 * time.Minute
+
+	// payload hashes of requests that don't sign the payload in the header
+	unsignedPayload          = "UNSIGNED-PAYLOAD"
+	streamingPayload         = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	streamingUnsignedPayload = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+
+	// emptySHA256 is the hex encoded sha256 of no data
+	emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// sigV4Signature is the parsed signature of a request
+type sigV4Signature struct {
+	AccessKey     string
+	Date          string
+	Region        string
+	Service       string
+	SignedHeaders []string
+	Signature     string
+	Time          time.Time
+	PayloadHash   string
+
+	// Presigned is set for signatures in the query, which expire
+	Presigned bool
+	Expires   time.Duration
+}
+
+// scope returns the credential scope of the signature
+func (sig *sigV4Signature) scope() string {
+	return sig.Date + "/" + sig.Region + "/" + sig.Service + "/aws4_request"
+}
+
+// parseSigV4 parses the signature from the authorization header or the query
+func parseSigV4(r *http.Request) (*sigV4Signature, error) {
+	query := r.URL.Query()
+	if query.Get("X-Amz-Algorithm") != "" {
+		return parseSigV4Query(query)
+	}
+
+	auth := r.Header.Get("Authorization")
+	if auth == "" {
+		return nil, errS3AccessDenied
+	}
+	if !strings.HasPrefix(auth, sigV4Algorithm+" ") {
+		return nil, errS3AuthorizationMalformed
+	}
+
+	sig := &sigV4Signature{}
+	fields := make(map[string]string)
+	for _, field := range strings.Split(strings.TrimPrefix(auth, sigV4Algorithm+" "), ",") {
+		kv := strings.SplitN(strings.TrimSpace(field), "=", 2)
+		if len(kv) != 2 {
+			return nil, errS3AuthorizationMalformed
+		}
+		fields[kv[0]] = kv[1]
+	}
+	if err := sig.parseCredential(fields["Credential"]); err != nil {
+		return nil, err
+	}
+	sig.SignedHeaders = strings.Split(fields["SignedHeaders"], ";")
+	sig.Signature = fields["Signature"]
+
+	amzDate := r.Header.Get("X-Amz-Date")
+	if amzDate == "" {
+		return nil, errS3AccessDenied
+	}
+	if err := sig.parseTime(amzDate); err != nil {
+		return nil, err
+	}
+
+	sig.PayloadHash = r.Header.Get("X-Amz-Content-Sha256")
+	if sig.PayloadHash == "" {
+		return nil, errS3ContentSHA256Missing
+	}
+	return sig, nil
+}
+
+// parseSigV4Query parses the signature of a presigned url
+func parseSigV4Query(query url.Values) (*sigV4Signature, error) {
+	if query.Get("X-Amz-Algorithm") != sigV4Algorithm {
+		return nil, errS3AuthorizationMalformed
+	}
+
+	sig := &sigV4Signature{Presigned: true, PayloadHash: unsignedPayload}
+	if err := sig.parseCredential(query.Get("X-Amz-Credential")); err != nil {
+		return nil, err
+	}
+	if err := sig.parseTime(query.Get("X-Amz-Date")); err != nil {
+		return nil, err
+	}
+	sig.SignedHeaders = strings.Split(query.Get("X-Amz-SignedHeaders"), ";")
+	sig.Signature = query.Get("X-Amz-Signature")
+
+	expires, err := strconv.Atoi(query.Get("X-Amz-Expires"))
+	if err != nil || expires <= 0 || expires > 7*24*3600 {
+		return nil, errS3AuthorizationMalformed
+	}
+	sig.Expires = time.Duration(expires) * time.Second
+	return sig, nil
+}
+
+// parseCredential parses the access key and scope of the signature
+func (sig *sigV4Signature) parseCredential(credential string) error {
+	parts := strings.Split(credential, "/")
+	if len(parts) != 5 || parts[4] != "aws4_request" {
+		return errS3AuthorizationMalformed
+	}
+	sig.AccessKey, sig.Date, sig.Region, sig.Service = parts[0], parts[1], parts[2], parts[3]
+	return nil
+}
+
+// parseTime parses the signing time, which must be on the date of the scope
+func (sig *sigV4Signature) parseTime(amzDate string) error {
+	t, err := time.Parse(sigV4TimeFormat, amzDate)
+	if err != nil || t.Format("20060102") != sig.Date {
+		return errS3AuthorizationMalformed
+	}
+	sig.Time = t
+	return nil
+}
+
+// signingKey derives the key of a secret for a scope
+func signingKey(secret, date, region, service string) []byte {
+	key := hmacSHA256([]byte("AWS4"+secret), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	return hmacSHA256(key, "aws4_request")
+}
+
+// hmacSHA256 returns the hmac of data with the key
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// sha256Hex returns the hex encoded sha256 of data
+func sha256Hex(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+// canonicalRequest returns the canonical form of a request that is signed
+func (sig *sigV4Signature) canonicalRequest(r *http.Request) string {
+	query := r.URL.Query()
+	if sig.Presigned {
+		query.Del("X-Amz-Signature")
+	}
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	params := make([]string, 0, len(keys))
+	for _, key := range keys {
+		values := query[key]
+		sort.Strings(values)
+		for _, value := range values {
+			params = append(params, awsURIEncode(key, true)+"="+awsURIEncode(value, true))
+		}
+	}
+
+	var headers strings.Builder
+	for _, name := range sig.SignedHeaders {
+		var value string
+		switch name {
+		case "host":
+			value = r.Host
+		case "content-length":
+			value = r.Header.Get("Content-Length")
+			if value == "" {
+				value = strconv.FormatInt(r.ContentLength, 10)
+			}
+		default:
+			values := r.Header.Values(name)
+			for i := range values {
+				values[i] = strings.Join(strings.Fields(values[i]), " ")
+			}
+			value = strings.Join(values, ",")
+		}
+		headers.WriteString(name + ":" + value + "\n")
+	}
+
+	path := r.URL.Path
+	if path == "" {
+		path = "/"
+	}
+	return strings.Join([]string{
+		r.Method,
+		awsURIEncode(path, false),
+		strings.Join(params, "&"),
+		headers.String(),
+		strings.Join(sig.SignedHeaders, ";"),
+		sig.PayloadHash,
+	}, "\n")
+}
+
+// stringToSign returns the string signed for a canonical request
+func (sig *sigV4Signature) stringToSign(canonicalRequest string) string {
+	return strings.Join([]string{
+		sigV4Algorithm,
+		sig.Time.Format(sigV4TimeFormat),
+		sig.scope(),
+		sha256Hex(canonicalRequest),
+	}, "\n")
+}
+
+// awsURIEncode encodes a string like AWS does, every byte except the
+// unreserved characters is percent encoded, slashes are kept in paths
+func awsURIEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// verifySigV4 verifies the signature of a request and returns the body to
+// read the payload from, which verifies the payload while it's read
+func (s3 *S3Config) verifySigV4(r *http.Request) (io.ReadCloser, error) {
+	sig, err := parseSigV4(r)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, ok := s3.Credentials[sig.AccessKey]
+	if !ok {
+		return nil, errS3InvalidAccessKeyID
+	}
+	if sig.Service != "s3" || (s3.Region != "" && sig.Region != s3.Region) {
+		return nil, errS3AuthorizationMalformed
+	}
+
+	now := s3.now()
+	if sig.Presigned {
+		if now.Before(sig.Time.Add(-sigV4MaxSkew)) || now.After(sig.Time.Add(sig.Expires)) {
+			return nil, errS3RequestExpired
+		}
+	} else if now.Sub(sig.Time) > sigV4MaxSkew || sig.Time.Sub(now) > sigV4MaxSkew {
+		return nil, errS3RequestTimeTooSkewed
+	}
+
+	key := signingKey(secret, sig.Date, sig.Region, sig.Service)
+	expected := hex.EncodeToString(hmacSHA256(key, sig.stringToSign(sig.canonicalRequest(r))))
+	if !hmac.Equal([]byte(expected), []byte(sig.Signature)) {
+		return nil, errS3SignatureDoesNotMatch
+	}
+
+	switch sig.PayloadHash {
+	case unsignedPayload:
+		return r.Body, nil
+	case streamingPayload:
+		return newAWSChunkedReader(r.Body, sig, key), nil
+	case streamingUnsignedPayload:
+		return newAWSChunkedReader(r.Body, nil, nil), nil
+	}
+
+	expectedHash, err := hex.DecodeString(sig.PayloadHash)
+	if err != nil || len(expectedHash) != sha256.Size {
+		return nil, errS3ContentSHA256Mismatch
+	}
+	return newVerifyReader(r.Body, r.ContentLength, sha256.New(), expectedHash,
+		errS3ContentSHA256Mismatch), nil
+}
+
+// verifyReader compares the hash of the content with the expected hash once
+// the content length was read, an error is returned with the last bytes
+type verifyReader struct {
+	io.ReadCloser
+	remaining int64
+	hash      hash.Hash
+	expected  []byte
+	err       error
+}
+
+// newVerifyReader creates a reader verifying the hash of size bytes
+func newVerifyReader(r io.ReadCloser, size int64, h hash.Hash, expected []byte, err error) *verifyReader {
+	return &verifyReader{ReadCloser: r, remaining: size, hash: h, expected: expected, err: err}
+}
+
+// Read reads and hashes the content
+func (vr *verifyReader) Read(p []byte) (int, error) {
+	if vr.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > vr.remaining {
+		p = p[:vr.remaining]
+	}
+	n, err := vr.ReadCloser.Read(p)
+	vr.hash.Write(p[:n])
+	vr.remaining -= int64(n)
+	if vr.remaining == 0 && !hmac.Equal(vr.hash.Sum(nil), vr.expected) {
+		return n, vr.err
+	}
+	return n, err
+}
+
+// awsChunkedReader decodes an aws-chunked body, the chunk signatures are
+// verified for signed streams
+type awsChunkedReader struct {
+	r     *bufio.Reader
+	close io.Closer
+
+	sig     *sigV4Signature
+	key     []byte
+	prevSig string
+
+	remaining int64
+	chunkSig  string
+	chunkHash hash.Hash
+	done      bool
+}
+
+// newAWSChunkedReader creates a reader for an aws-chunked body, without a
+// signature the chunks aren't verified
+func newAWSChunkedReader(body io.ReadCloser, sig *sigV4Signature, key []byte) *awsChunkedReader {
+	cr := &awsChunkedReader{
+		r:         bufio.NewReader(body),
+		close:     body,
+		sig:       sig,
+		key:       key,
+		chunkHash: sha256.New(),
+	}
+	if sig != nil {
+		cr.prevSig = sig.Signature
+	}
+	return cr
+}
+
+// Read reads the decoded content of the chunks
+func (cr *awsChunkedReader) Read(p []byte) (int, error) {
+	for cr.remaining == 0 {
+		if cr.done {
+			return 0, io.EOF
+		}
+		if err := cr.nextChunk(); err != nil {
+			return 0, err
+		}
+	}
+
+	if int64(len(p)) > cr.remaining {
+		p = p[:cr.remaining]
+	}
+	n, err := cr.r.Read(p)
+	cr.chunkHash.Write(p[:n])
+	cr.remaining -= int64(n)
+	if err == io.EOF {
+		return n, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return n, err
+	}
+
+	if cr.remaining == 0 {
+		if err := cr.endChunk(); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// nextChunk reads the header of the next chunk, the last chunk is empty and
+// is followed by optional trailers
+func (cr *awsChunkedReader) nextChunk() error {
+	line, err := cr.readLine()
+	if err != nil {
+		return err
+	}
+	header := strings.SplitN(line, ";", 2)
+	size, err := strconv.ParseInt(header[0], 16, 64)
+	if err != nil || size < 0 {
+		return errS3IncompleteBody
+	}
+	cr.chunkSig = ""
+	if len(header) == 2 {
+		cr.chunkSig = strings.TrimPrefix(header[1], "chunk-signature=")
+	}
+	cr.remaining = size
+	cr.chunkHash.Reset()
+
+	if size > 0 {
+		return nil
+	}
+
+	// the final chunk is signed like the others
+	if err := cr.verifyChunk(); err != nil {
+		return err
+	}
+	for {
+		trailer, err := cr.readLine()
+		if err != nil || trailer == "" {
+			cr.done = true
+			return nil
+		}
+	}
+}
+
+// endChunk consumes the line break after the data and verifies the chunk
+func (cr *awsChunkedReader) endChunk() error {
+	if line, err := cr.readLine(); err != nil || line != "" {
+		return errS3IncompleteBody
+	}
+	return cr.verifyChunk()
+}
+
+// verifyChunk verifies the signature of the chunk, which chains the signature
+// of the previous chunk
+func (cr *awsChunkedReader) verifyChunk() error {
+	if cr.sig == nil {
+		return nil
+	}
+	stringToSign := strings.Join([]string{
+		sigV4Algorithm + "-PAYLOAD",
+		cr.sig.Time.Format(sigV4TimeFormat),
+		cr.sig.scope(),
+		cr.prevSig,
+		emptySHA256,
+		hex.EncodeToString(cr.chunkHash.Sum(nil)),
+	}, "\n")
+	expected := hex.EncodeToString(hmacSHA256(cr.key, stringToSign))
+	if !hmac.Equal([]byte(expected), []byte(cr.chunkSig)) {
+		return errS3SignatureDoesNotMatch
+	}
+	cr.prevSig = cr.chunkSig
+	return nil
+}
+
+// readLine reads a line without the line break
+func (cr *awsChunkedReader) readLine() (string, error) {
+	line, err := cr.r.ReadString('\n')
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return "", errS3IncompleteBody
+		}
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// Close closes the body
+func (cr *awsChunkedReader) Close() error {
+	return cr.close.Close()
+}
@@ -1,14 +1,23 @@
 package server
 
 import (
+	"bytes"
 	"crypto/md5"
+	"crypto/sha256"
 	"encoding/binary"
 	"encoding/hex"
 	"errors"
+	"hash"
+	"hash/crc32"
 	"io"
+	"math"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"time"
+
+	"github.com/sirupsen/logrus"
 )
 
 // FileStore stores metadata and content of a file
@@ -18,6 +27,16 @@ type FileStore struct {
 	FileName  string
 	DataSize  int64
 	CreatedAt time.Time
+
+	// V2 fields, ModifiedAt is set to CreatedAt when parsing V1
+	ModifiedAt  time.Time
+	ContentType string
+	Metadata    map[string]string
+	Checksum    []byte
+
+	// S3ETag is the ETag of the file in S3 responses, the md5 of the content
+	// computed while writing unless a multipart upload set it
+	S3ETag string
 }
 
 type FSVersion uint8
@@ -27,33 +46,190 @@ const (
 	// V1 Order: version, fileNameSize, filename, createdAt, file size, content
 	FSStoreV1 FSVersion = 1
 
-	DefaultVersion FSVersion = FSStoreV1
+	// V2 Order: version, fileNameSize, filename, createdAt, modifiedAt,
+	// contentTypeSize, contentType, metadataCount, metadata (keySize, key,
+	// valueSize, value), file size, header crc32, content, content sha256
+	FSStoreV2 FSVersion = 2
+
+	DefaultVersion FSVersion = FSStoreV2
+)
+
+// SupportedVersions are the versions that can be read and written
+var SupportedVersions = []FSVersion{FSStoreV1, FSStoreV2}
+
+// Supported checks whether the version can be read and written
+func (v FSVersion) Supported() bool {
+	for _, version := range SupportedVersions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// Define Errors
+var (
+	// ErrUnknownVersion is returned when parsing a file store of an unsupported version
+	ErrUnknownVersion = errors.New("unknown file store version")
+
+	// ErrHeaderChecksum is returned when the header checksum doesn't match the header
+	ErrHeaderChecksum = errors.New("header checksum mismatch")
+
+	// ErrContentChecksum is returned when the content checksum doesn't match the content
+	ErrContentChecksum = errors.New("content checksum mismatch")
 )
 
-// createFileAt creates file using file store at directory
-func (store *FileStore) createFileAt(dataDir string, overwrite bool) error {
-	fsFileName := generateFileName(store.FileName)
-	flag := os.O_CREATE | os.O_WRONLY
-	if overwrite {
-		flag = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+// tempFilePrefix is the prefix of files being written before they are renamed
+const tempFilePrefix = ".tmp-"
+
+// createFileAt creates file using file store at directory, the file store is
+// written to a temp file which is renamed over the target once it's complete
+func (store *FileStore) createFileAt(dataDir string, layout Layout, overwrite bool) error {
+	dir := layout.dir(dataDir, store.FileName)
+	target := layout.filePath(dataDir, store.FileName)
+	if !overwrite {
+		if _, err := os.Stat(target); err == nil {
+			return ErrFileAlreadyExists
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	if dir != dataDir {
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			return err
+		}
+	}
+
+	// temp files are created in the data directory so they are cleaned up on start
+	file, err := os.CreateTemp(dataDir, tempFilePrefix+"*")
+	if err != nil {
+		return err
 	}
-	file, err := os.OpenFile(filepath.Join(dataDir, fsFileName), flag, 0644)
+	tmpPath := file.Name()
+
+	err = writeTempFile(file, store)
 	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := os.Rename(tmpPath, target); err != nil {
+		os.Remove(tmpPath)
 		return err
 	}
+	syncDir(dir)
+	return nil
+}
+
+// writeTempFile writes the file store to a temp file, syncs and closes it
+func writeTempFile(file *os.File, store *FileStore) error {
 	defer file.Close()
-	return store.writeFileStore(file)
+	if err := file.Chmod(0644); err != nil {
+		return err
+	}
+	if err := store.writeFileStore(file); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// syncDir syncs a directory so renames in it are durable, errors are ignored
+// since not every platform supports syncing directories
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
+
+// cleanupTempFiles removes temp files left behind by writes that never completed
+func cleanupTempFiles(dataDir string) error {
+	entries, err := os.ReadDir(dataDir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasPrefix(entry.Name(), tempFilePrefix) {
+			continue
+		}
+		logrus.Warn("Removing orphaned temp file: ", entry.Name())
+		if err := os.Remove(filepath.Join(dataDir, entry.Name())); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// openFileAt opens a file using file store at directory, the returned file is
+// positioned at the start of the content and must be closed by the caller
+func openFileAt(dataDir string, layout Layout, fileName string) (*os.File, *FileStore, error) {
+	file, err := os.Open(layout.filePath(dataDir, fileName))
+	if err != nil {
+		return nil, nil, err
+	}
+	store, err := parseFileStore(file)
+	if err == nil && store.Version >= FSStoreV2 {
+		store.Checksum, err = store.readChecksumAt(file)
+	}
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return file, store, nil
+}
+
+// headerSize returns the size of the header preceding the content
+func (store *FileStore) headerSize() int64 {
+	header, err := store.encodeHeader()
+	if err != nil {
+		return 0
+	}
+	return int64(len(header))
+}
+
+// contentReader returns a reader for the content of a file store that supports seeking
+func (store *FileStore) contentReader(r io.ReaderAt) *io.SectionReader {
+	return io.NewSectionReader(r, store.headerSize(), store.DataSize)
+}
+
+// readChecksumAt reads the content checksum trailer of a V2 file store
+func (store *FileStore) readChecksumAt(r io.ReaderAt) ([]byte, error) {
+	checksum := make([]byte, sha256.Size)
+	_, err := r.ReadAt(checksum, store.headerSize()+store.DataSize)
+	if err == io.EOF {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return checksum, err
+}
+
+// ETag returns an entity tag identifying the content of the file store
+func (store *FileStore) ETag() string {
+	if len(store.Checksum) != 0 {
+		return `"` + hex.EncodeToString(store.Checksum) + `"`
+	}
+
+	h := md5.New()
+	binary.Write(h, binary.BigEndian, store.Version)
+	h.Write([]byte(store.FileName))
+	binary.Write(h, binary.BigEndian, store.CreatedAt.UnixMilli())
+	binary.Write(h, binary.BigEndian, store.DataSize)
+	return `"` + hex.EncodeToString(h.Sum(nil)) + `"`
 }
 
 // deleteFileAt deletes a file using file store at directory
-func deleteFileAt(dataDir, fileName string) error {
-	return os.Remove(filepath.Join(dataDir, generateFileName(fileName)))
+func deleteFileAt(dataDir string, layout Layout, fileName string) error {
+	return os.Remove(layout.filePath(dataDir, fileName))
 }
 
 // Exists checks if a file exists using the file store
-func fileExists(dataDir, fileName string) (bool, error) {
-	_, err := os.Stat(filepath.Join(dataDir,
-		generateFileName(fileName)))
+func fileExists(dataDir string, layout Layout, fileName string) (bool, error) {
+	_, err := os.Stat(layout.filePath(dataDir, fileName))
 	if err != nil {
 		if os.IsNotExist(err) {
 			return false, nil
@@ -82,27 +258,38 @@ func parseFileStore(r io.Reader) (*FileStore, error) {
 		return nil, err
 	}
 
+	switch store.Version {
+	case FSStoreV1:
+		err = store.parseHeaderV1(r)
+	case FSStoreV2:
+		err = store.parseHeaderV2(r)
+	default:
+		return nil, ErrUnknownVersion
+	}
+	if err == io.EOF {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &store, nil
+}
+
+// parseHeaderV1 parses the header after the version
+// V1 Order: version, fileNameSize, filename, createdAt, file size, content
+func (store *FileStore) parseHeaderV1(r io.Reader) error {
 	// Read the file name size (1 byte)
 	var fileNameSize uint8
-	err = binary.Read(r, binary.BigEndian, &fileNameSize)
+	err := binary.Read(r, binary.BigEndian, &fileNameSize)
 	if err != nil {
-		if err == io.EOF {
-			return nil, io.ErrUnexpectedEOF
-		}
-		return nil, err
+		return err
 	}
 
 	// Read the filename (max 255 bytes)
 	fileName := make([]byte, fileNameSize)
-	n, err := r.Read(fileName)
-	if err != nil {
-		if err == io.EOF {
-			return nil, io.ErrUnexpectedEOF
-		}
-		return nil, err
-	}
-	if n != int(fileNameSize) {
-		return nil, io.ErrUnexpectedEOF
+	if _, err := io.ReadFull(r, fileName); err != nil {
+		return err
 	}
 	store.FileName = string(fileName)
 
@@ -110,83 +297,275 @@ func parseFileStore(r io.Reader) (*FileStore, error) {
 	var ts int64 = 0
 	err = binary.Read(r, binary.BigEndian, &ts)
 	if err != nil {
-		if err == io.EOF {
-			return nil, io.ErrUnexpectedEOF
-		}
-		return nil, err
+		return err
 	}
 	store.CreatedAt = time.UnixMilli(ts)
+	store.ModifiedAt = store.CreatedAt
 
 	// Read the size (8 bytes)
 	err = binary.Read(r, binary.BigEndian, &store.DataSize)
 	if err != nil {
-		if err == io.EOF {
-			return nil, io.ErrUnexpectedEOF
-		}
-		return nil, err
+		return err
 	}
 	if store.DataSize == 0 {
-		return nil, errors.New("invalid file store size")
+		return errors.New("invalid file store size")
 	}
 
 	store.Reader = r
 
-	return &store, nil
+	return nil
 }
 
-// WriteFileStore writes the file store to an io.Writer
-// V1 Order: version, fileNameSize, filename, createdAt, file size, content
-func (store *FileStore) writeFileStore(w io.Writer) error {
-	// Set default version if not set
-	if store.Version == 0 {
-		store.Version = DefaultVersion
-	}
+// parseHeaderV2 parses the header after the version and verifies the header checksum
+// V2 Order: version, fileNameSize, filename, createdAt, modifiedAt, contentTypeSize,
+// contentType, metadataCount, metadata, file size, header crc32, content, content sha256
+func (store *FileStore) parseHeaderV2(r io.Reader) error {
+	// The crc covers every header field including the version
+	crc := crc32.NewIEEE()
+	crc.Write([]byte{byte(store.Version)})
+	hr := io.TeeReader(r, crc)
 
-	// Write the version (1 byte)
-	err := binary.Write(w, binary.BigEndian, store.Version)
+	// Read the filename (max 255 bytes)
+	fileName, err := readString8(hr)
 	if err != nil {
 		return err
 	}
+	store.FileName = fileName
 
-	// Write the file name size (1 byte)
-	err = binary.Write(w, binary.BigEndian, uint8(len(store.FileName)))
-	if err != nil {
+	// Read the createdAt and modifiedAt (8 bytes each)
+	var ts int64 = 0
+	if err := binary.Read(hr, binary.BigEndian, &ts); err != nil {
+		return err
+	}
+	store.CreatedAt = time.UnixMilli(ts)
+	if err := binary.Read(hr, binary.BigEndian, &ts); err != nil {
 		return err
 	}
+	store.ModifiedAt = time.UnixMilli(ts)
 
-	// Write the filename (max 255 bytes)
-	_, err = w.Write([]byte(store.FileName))
-	if err != nil {
+	// Read the content type (max 255 bytes)
+	if store.ContentType, err = readString8(hr); err != nil {
 		return err
 	}
 
-	// Write the created
-	err = binary.Write(w, binary.BigEndian, store.CreatedAt.UnixMilli())
-	if err != nil {
+	// Read the metadata count (2 bytes) and key/value pairs
+	var metadataCount uint16
+	if err := binary.Read(hr, binary.BigEndian, &metadataCount); err != nil {
+		return err
+	}
+	if metadataCount > 0 {
+		store.Metadata = make(map[string]string, metadataCount)
+	}
+	for i := 0; i < int(metadataCount); i++ {
+		key, err := readString8(hr)
+		if err != nil {
+			return err
+		}
+		var valueSize uint16
+		if err := binary.Read(hr, binary.BigEndian, &valueSize); err != nil {
+			return err
+		}
+		value := make([]byte, valueSize)
+		if _, err := io.ReadFull(hr, value); err != nil {
+			return err
+		}
+		store.Metadata[key] = string(value)
+	}
+
+	// Read the size (8 bytes)
+	if err := binary.Read(hr, binary.BigEndian, &store.DataSize); err != nil {
 		return err
 	}
+	if store.DataSize < 0 {
+		return errors.New("invalid file store size")
+	}
+
+	// Read and verify the header crc (4 bytes)
+	var headerCRC uint32
+	if err := binary.Read(r, binary.BigEndian, &headerCRC); err != nil {
+		return err
+	}
+	if headerCRC != crc.Sum32() {
+		return ErrHeaderChecksum
+	}
+
+	store.Reader = &checksumReader{
+		r:       r,
+		content: io.LimitReader(r, store.DataSize),
+		hash:    sha256.New(),
+		store:   store,
+	}
+
+	return nil
+}
+
+// readString8 reads a string prefixed with its size (1 byte)
+func readString8(r io.Reader) (string, error) {
+	var size uint8
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return "", err
+	}
+	b := make([]byte, size)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// encodeHeader encodes the header preceding the content
+func (store *FileStore) encodeHeader() ([]byte, error) {
+	header := &bytes.Buffer{}
+
+	// Write the version (1 byte)
+	binary.Write(header, binary.BigEndian, store.Version)
+
+	switch store.Version {
+	case FSStoreV1:
+		// Write the file name size (1 byte) and the filename (max 255 bytes)
+		if len(store.FileName) > 255 {
+			return nil, errors.New("file name too long")
+		}
+		binary.Write(header, binary.BigEndian, uint8(len(store.FileName)))
+		header.WriteString(store.FileName)
+
+		// Write the created
+		binary.Write(header, binary.BigEndian, store.CreatedAt.UnixMilli())
+
+		// Write the real size, V1 can't store empty files
+		if store.DataSize == 0 {
+			return nil, ErrEmptyFile
+		}
+		binary.Write(header, binary.BigEndian, store.DataSize)
+	case FSStoreV2:
+		if len(store.FileName) > 255 {
+			return nil, errors.New("file name too long")
+		}
+		if len(store.ContentType) > 255 {
+			return nil, errors.New("content type too long")
+		}
+		if len(store.Metadata) > math.MaxUint16 {
+			return nil, errors.New("too many metadata entries")
+		}
+
+		binary.Write(header, binary.BigEndian, uint8(len(store.FileName)))
+		header.WriteString(store.FileName)
+		binary.Write(header, binary.BigEndian, store.CreatedAt.UnixMilli())
+		binary.Write(header, binary.BigEndian, store.ModifiedAt.UnixMilli())
+		binary.Write(header, binary.BigEndian, uint8(len(store.ContentType)))
+		header.WriteString(store.ContentType)
+
+		// Write the metadata sorted by key, so the header is deterministic
+		keys := make([]string, 0, len(store.Metadata))
+		for key := range store.Metadata {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		binary.Write(header, binary.BigEndian, uint16(len(keys)))
+		for _, key := range keys {
+			value := store.Metadata[key]
+			if len(key) > 255 || len(value) > math.MaxUint16 {
+				return nil, errors.New("metadata entry too long")
+			}
+			binary.Write(header, binary.BigEndian, uint8(len(key)))
+			header.WriteString(key)
+			binary.Write(header, binary.BigEndian, uint16(len(value)))
+			header.WriteString(value)
+		}
+
+		binary.Write(header, binary.BigEndian, store.DataSize)
+
+		// Write the header crc (4 bytes)
+		binary.Write(header, binary.BigEndian, crc32.ChecksumIEEE(header.Bytes()))
+	default:
+		return nil, ErrUnknownVersion
+	}
+
+	return header.Bytes(), nil
+}
+
+// WriteFileStore writes the file store to an io.Writer
+func (store *FileStore) writeFileStore(w io.Writer) error {
+	// Set default version if not set
+	if store.Version == 0 {
+		store.Version = DefaultVersion
+	}
 
-	// Write the real size
-	err = binary.Write(w, binary.BigEndian, store.DataSize)
+	header, err := store.encodeHeader()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(header)
 	if err != nil {
 		return err
 	}
 
+	// V2 stores the sha256 of the content after the content
+	h := sha256.New()
+	contentMD5 := md5.New()
+	cw := io.MultiWriter(w, contentMD5)
+	if store.Version >= FSStoreV2 {
+		cw = io.MultiWriter(w, h, contentMD5)
+	}
+
 	// buffer for storing the data
 	buffer := make([]byte, 1<<16-1)
 
 	// Write the content
-	_, err = io.CopyBuffer(w, store, buffer)
+	n, err := io.CopyBuffer(cw, io.LimitReader(store, store.DataSize), buffer)
 	if err != nil {
 		return err
 	}
-	if err == io.EOF {
-		return nil
+	if n != store.DataSize {
+		return io.ErrUnexpectedEOF
+	}
+	if store.S3ETag == "" {
+		store.S3ETag = `"` + hex.EncodeToString(contentMD5.Sum(nil)) + `"`
+	}
+
+	if store.Version >= FSStoreV2 {
+		store.Checksum = h.Sum(nil)
+		_, err = w.Write(store.Checksum)
+		return err
 	}
 
 	return nil
 }
 
+// checksumReader reads the content of a V2 file store and verifies the
+// checksum stored after the content once the content is read
+type checksumReader struct {
+	r       io.Reader
+	content io.Reader
+	hash    hash.Hash
+	store   *FileStore
+	err     error
+}
+
+// Read reads the content, returning ErrContentChecksum instead of io.EOF when
+// the content doesn't match the checksum
+func (cr *checksumReader) Read(p []byte) (int, error) {
+	if cr.err != nil {
+		return 0, cr.err
+	}
+
+	n, err := cr.content.Read(p)
+	cr.hash.Write(p[:n])
+	if err != io.EOF {
+		return n, err
+	}
+
+	checksum := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(cr.r, checksum); err != nil {
+		cr.err = io.ErrUnexpectedEOF
+	} else if !bytes.Equal(checksum, cr.hash.Sum(nil)) {
+		cr.err = ErrContentChecksum
+	} else {
+		cr.store.Checksum = checksum
+		cr.err = io.EOF
+	}
+	return n, cr.err
+}
+
 // Read reads the file store
 func (r *FileStore) Read(p []byte) (n int, err error) {
 	return r.Reader.Read(p)
@@ -2,8 +2,10 @@ package server
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"io"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -43,7 +45,7 @@ func Test_FileStoreCreate(t *testing.T) {
 		Reader:    strings.NewReader("data"),
 	}
 
-	err := store.createFileAt(dir, false)
+	err := store.createFileAt(dir, LayoutFlat, false)
 	if err != nil {
 		t.Error(err)
 	}
@@ -61,7 +63,7 @@ func Test_FileStoreOverwrite(t *testing.T) {
 		Reader:    strings.NewReader("data"),
 	}
 
-	err := store.createFileAt(dir, false)
+	err := store.createFileAt(dir, LayoutFlat, false)
 	if assert.NoError(t, err, "Error creating file") {
 
 		store2 := &FileStore{
@@ -72,11 +74,79 @@ func Test_FileStoreOverwrite(t *testing.T) {
 			Reader:    strings.NewReader("new data"),
 		}
 
-		err := store2.createFileAt(dir, true)
+		err := store2.createFileAt(dir, LayoutFlat, true)
 		assert.NoError(t, err, "Error overwriting file")
 	}
 }
 
+// Test_FileStoreOverwriteFailed tests that a failed overwrite keeps the previous file.
+func Test_FileStoreOverwriteFailed(t *testing.T) {
+	dir := createTestDir(t)
+	defer cleanUpTestDir(t, dir)
+
+	store := &FileStore{
+		Version:   DefaultVersion,
+		FileName:  "test.txt",
+		DataSize:  4,
+		CreatedAt: time.Now(),
+		Reader:    strings.NewReader("data"),
+	}
+
+	err := store.createFileAt(dir, LayoutFlat, false)
+	if !assert.NoError(t, err, "Error creating file") {
+		return
+	}
+
+	// the content is shorter than the size, so writing fails
+	store2 := &FileStore{
+		Version:   DefaultVersion,
+		FileName:  "test.txt",
+		DataSize:  8,
+		CreatedAt: time.Now(),
+		Reader:    strings.NewReader("new"),
+	}
+	err = store2.createFileAt(dir, LayoutFlat, true)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF, "Incomplete overwrite succeeded")
+
+	file, testStore, err := openFileAt(dir, LayoutFlat, "test.txt")
+	if assert.NoError(t, err, "Error opening previous file") {
+		defer file.Close()
+		data, err := io.ReadAll(testStore)
+		if assert.NoError(t, err, "could not read test store") {
+			assert.Equal(t, "data", string(data), "Previous file was modified")
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if assert.NoError(t, err, "Error reading directory") {
+		assert.Len(t, entries, 1, "Temp file was not removed")
+	}
+
+	err = store.createFileAt(dir, LayoutFlat, false)
+	assert.ErrorIs(t, err, ErrFileAlreadyExists, "Existing file was overwritten")
+}
+
+// Test_CleanupTempFiles tests the removal of orphaned temp files.
+func Test_CleanupTempFiles(t *testing.T) {
+	dir := createTestDir(t)
+	defer cleanUpTestDir(t, dir)
+
+	tmpPath := filepath.Join(dir, tempFilePrefix+"orphan")
+	err := os.WriteFile(tmpPath, []byte("partial"), 0644)
+	if !assert.NoError(t, err, "Error creating temp file") {
+		return
+	}
+	keepPath := filepath.Join(dir, generateFileName("keep.txt"))
+	err = os.WriteFile(keepPath, []byte("keep"), 0644)
+	if !assert.NoError(t, err, "Error creating file") {
+		return
+	}
+
+	assert.NoError(t, cleanupTempFiles(dir), "Error cleaning up temp files")
+	assert.NoFileExists(t, tmpPath, "Temp file was not removed")
+	assert.FileExists(t, keepPath, "File was removed")
+}
+
 // Test_FileStoreParse tests the parsing of a file store.
 func Test_FileStoreParse(t *testing.T) {
 	data := "zxcasd asdaxz"
@@ -112,4 +182,113 @@ func Test_FileStoreParse(t *testing.T) {
 	}
 }
 
+// Test_FileStoreContentReader tests reading the content at the header offset.
+func Test_FileStoreContentReader(t *testing.T) {
+	data := "zxcasd asdaxz"
+	for _, test := range []struct {
+		version     FSVersion
+		trailerSize int64
+	}{
+		{FSStoreV1, 0},
+		{FSStoreV2, sha256.Size},
+	} {
+		store := &FileStore{
+			Version:   test.version,
+			FileName:  "test.txt",
+			DataSize:  int64(len(data)),
+			CreatedAt: time.Now(),
+			Reader:    strings.NewReader(data),
+		}
+
+		writer := bytes.NewBuffer(make([]byte, 0))
+		err := store.writeFileStore(writer)
+		if !assert.NoError(t, err, "could not write to buffer") {
+			return
+		}
+		assert.Equal(t, int64(writer.Len())-store.DataSize-test.trailerSize, store.headerSize(),
+			"Header size does not match written header")
+
+		content, err := io.ReadAll(store.contentReader(bytes.NewReader(writer.Bytes())))
+		if assert.NoError(t, err, "could not read content") {
+			assert.Equal(t, data, string(content), "Data not equal to content")
+		}
+	}
+}
+
+// Test_FileStoreParseV2 tests writing and parsing the V2 fields.
+func Test_FileStoreParseV2(t *testing.T) {
+	data := "zxcasd asdaxz"
+	store := &FileStore{
+		Version:     FSStoreV2,
+		FileName:    "test.txt",
+		DataSize:    int64(len(data)),
+		CreatedAt:   time.Now().Add(-time.Hour),
+		ModifiedAt:  time.Now(),
+		ContentType: "text/plain",
+		Metadata:    map[string]string{"build": "42", "branch": "main"},
+		Reader:      strings.NewReader(data),
+	}
+
+	writer := bytes.NewBuffer(make([]byte, 0))
+	err := store.writeFileStore(writer)
+	if !assert.NoError(t, err, "could not write to buffer") {
+		return
+	}
+	checksum := sha256.Sum256([]byte(data))
+	assert.Equal(t, checksum[:], store.Checksum, "Checksum not set after writing")
+
+	testStore, err := parseFileStore(bytes.NewReader(writer.Bytes()))
+	if assert.NoError(t, err, "could not parse buffer") {
+		assert.Equal(t, store.FileName, testStore.FileName)
+		assert.Equal(t, store.CreatedAt.UnixMilli(), testStore.CreatedAt.UnixMilli())
+		assert.Equal(t, store.ModifiedAt.UnixMilli(), testStore.ModifiedAt.UnixMilli())
+		assert.Equal(t, store.ContentType, testStore.ContentType)
+		assert.Equal(t, store.Metadata, testStore.Metadata)
+		testStoreData, err := io.ReadAll(testStore)
+		if assert.NoError(t, err, "could not read test store") {
+			assert.Equal(t, data, string(testStoreData), "Data not equal to content")
+			assert.Equal(t, checksum[:], testStore.Checksum, "Checksum not set after reading")
+		}
+	}
+}
+
+// Test_FileStoreParseV2Corrupt tests the detection of corrupt V2 file stores.
+func Test_FileStoreParseV2Corrupt(t *testing.T) {
+	data := "zxcasd asdaxz"
+	store := &FileStore{
+		Version:   FSStoreV2,
+		FileName:  "test.txt",
+		DataSize:  int64(len(data)),
+		CreatedAt: time.Now(),
+		Reader:    strings.NewReader(data),
+	}
+
+	writer := bytes.NewBuffer(make([]byte, 0))
+	err := store.writeFileStore(writer)
+	if !assert.NoError(t, err, "could not write to buffer") {
+		return
+	}
+	headerSize := store.headerSize()
+
+	// Corrupt the file name in the header
+	corrupt := append([]byte{}, writer.Bytes()...)
+	corrupt[2] ^= 0xff
+	_, err = parseFileStore(bytes.NewReader(corrupt))
+	assert.ErrorIs(t, err, ErrHeaderChecksum, "Corrupt header was parsed")
+
+	// Corrupt the content
+	corrupt = append([]byte{}, writer.Bytes()...)
+	corrupt[headerSize] ^= 0xff
+	testStore, err := parseFileStore(bytes.NewReader(corrupt))
+	if assert.NoError(t, err, "could not parse buffer") {
+		_, err = io.ReadAll(testStore)
+		assert.ErrorIs(t, err, ErrContentChecksum, "Corrupt content was read")
+	}
+
+	// Unknown version
+	corrupt[0] = 0xff
+	_, err = parseFileStore(bytes.NewReader(corrupt))
+	assert.ErrorIs(t, err, ErrUnknownVersion, "Unknown version was parsed")
+}
+
 // TODO: check on a lower level write and parse
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"strings"
+
+	"fs-store/certs"
+
+	"github.com/labstack/echo/v4"
+)
+
+// TLSConfig is the configuration for serving over TLS, the certificate
+// files are reloaded when they change
+type TLSConfig struct {
+	CertFile string
+	KeyFile  string
+
+	// ClientCAFile requires clients to present a certificate signed by the CAs if set
+	ClientCAFile string
+
+	// Identities maps the subjects or common names of client certificates to
+	// the API key names they authenticate as, the common name is used if not mapped
+	Identities map[string]string
+
+	reloader *certs.Reloader
+}
+
+// NewTLSConfig creates the TLS configuration and loads the certificate files
+func NewTLSConfig(certFile, keyFile, clientCAFile string, identities map[string]string) (*TLSConfig, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("tls certificate and key files are required")
+	}
+	reloader, err := certs.NewReloader(certFile, keyFile, clientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	return &TLSConfig{
+		CertFile:     certFile,
+		KeyFile:      keyFile,
+		ClientCAFile: clientCAFile,
+		Identities:   identities,
+		reloader:     reloader,
+	}, nil
+}
+
+// serverConfig returns the tls config for listeners, the certificate and
+// client CAs are looked up on every handshake so reloads apply to new connections
+func (t *TLSConfig) serverConfig() *tls.Config {
+	base := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"h2", "http/1.1"},
+	}
+	base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		config := base.Clone()
+		config.GetConfigForClient = nil
+		config.Certificates = []tls.Certificate{*t.reloader.Certificate()}
+		if t.ClientCAFile != "" {
+			config.ClientCAs = t.reloader.CertPool()
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+		return config, nil
+	}
+	return base
+}
+
+// identity returns the identity of the verified client certificate of a
+// connection, it is empty without a verified certificate
+func (t *TLSConfig) identity(state *tls.ConnectionState) string {
+	if t == nil || state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	subject := state.VerifiedChains[0][0].Subject
+	if name, ok := t.Identities[subject.String()]; ok {
+		return name
+	}
+	if name, ok := t.Identities[subject.CommonName]; ok {
+		return name
+	}
+	return subject.CommonName
+}
+
+// ParseIdentities parses SUBJECT=NAME mappings of client certificates to key
+// names, the subject is split at the last = as subjects contain = themselves
+func ParseIdentities(mappings []string) (map[string]string, error) {
+	identities := make(map[string]string, len(mappings))
+	for _, mapping := range mappings {
+		i := strings.LastIndex(mapping, "=")
+		if i <= 0 || i == len(mapping)-1 {
+			return nil, errors.New("client identity must be SUBJECT=NAME: " + mapping)
+		}
+		identities[mapping[:i]] = mapping[i+1:]
+	}
+	return identities, nil
+}
+
+// startEcho starts the echo instance on the address, over TLS if configured,
+// the TLS server of the instance is used so Shutdown stops it
+func (sc *ServerConfig) startEcho(e *echo.Echo, address string) error {
+	for _, s := range []*http.Server{e.Server, e.TLSServer} {
+		s.ReadTimeout = sc.ReadTimeout
+		s.WriteTimeout = sc.WriteTimeout
+		s.IdleTimeout = sc.IdleTimeout
+	}
+	if sc.TLS == nil {
+		return e.Start(address)
+	}
+	e.TLSServer.Addr = address
+	e.TLSServer.TLSConfig = sc.TLS.serverConfig()
+	return e.StartServer(e.TLSServer)
+}
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"fs-store/certs"
+	"fs-store/client"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeTestCert writes a certificate and key for the common name to the
+// directory, signed by the parent or self-signed as a CA without a parent
+func writeTestCert(t *testing.T, dir, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name, Organization: []string{"fs-store"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost", "bufnet"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+
+	certFile, keyFile := filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	assert.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	cert, _ := x509.ParseCertificate(der)
+	return cert, key
+}
+
+// getTLSServerConfig returns a server config requiring client certificates
+// signed by a test CA, the certificates are written to the data directory
+func getTLSServerConfig(t *testing.T) (*ServerConfig, map[string]string) {
+	sc, tokens := getAuthServerConfig(t)
+	dir := sc.DataDir
+	ca, caKey := writeTestCert(t, dir, "ca", nil, nil)
+	writeTestCert(t, dir, "server", ca, caKey)
+	writeTestCert(t, dir, "reader", ca, caKey)
+	writeTestCert(t, dir, "ci", ca, caKey)
+	writeTestCert(t, dir, "unknown", ca, caKey)
+
+	var err error
+	sc.TLS, err = NewTLSConfig(filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key"),
+		filepath.Join(dir, "ca.crt"), map[string]string{"CN=ci,O=fs-store": "builds"})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return sc, tokens
+}
+
+// Test_TLS_ClientCertificates tests mutual TLS and authenticating with client certificates
+func Test_TLS_ClientCertificates(t *testing.T) {
+	sc, _ := getTLSServerConfig(t)
+	defer os.RemoveAll(sc.DataDir)
+	ts := httptest.NewUnstartedServer(sc.newEcho())
+	ts.TLS = sc.TLS.serverConfig()
+	ts.StartTLS()
+	defer ts.Close()
+
+	newClient := func(cert string) *client.FSClientConfig {
+		conf, err := client.NewFSClientConfig(ts.URL, false)
+		assert.NoError(t, err)
+		opts := client.TLSOptions{CAFile: filepath.Join(sc.DataDir, "ca.crt")}
+		if cert != "" {
+			opts.CertFile = filepath.Join(sc.DataDir, cert+".crt")
+			opts.KeyFile = filepath.Join(sc.DataDir, cert+".key")
+		}
+		if !assert.NoError(t, conf.SetTLS(opts)) {
+			t.FailNow()
+		}
+		return conf
+	}
+	status := func(conf *client.FSClientConfig, method, target string) int {
+		resp, err := conf.Client.R().Execute(method, target)
+		if err != nil {
+			return 0
+		}
+		return resp.StatusCode()
+	}
+
+	assert.Equal(t, 0, status(newClient(""), "GET", "/files"), "Connection without a client certificate accepted")
+
+	// the common name is the key name unless the subject is mapped
+	reader := newClient("reader")
+	assert.Equal(t, 200, status(reader, "GET", "/files"))
+	assert.Equal(t, 403, status(reader, "PUT", "/files/a.txt"), "Write without the write scope")
+	ci := newClient("ci")
+	assert.Equal(t, 200, status(ci, "GET", "/files?prefix=builds/"))
+	assert.Equal(t, 403, status(ci, "GET", "/files"), "List outside the prefix of the mapped key")
+	assert.Equal(t, 401, status(newClient("unknown"), "GET", "/files"), "Certificate without a key accepted")
+}
+
+// Test_TLS_Reload tests that changed certificate files are used for new connections
+func Test_TLS_Reload(t *testing.T) {
+	sc, _ := getTLSServerConfig(t)
+	defer os.RemoveAll(sc.DataDir)
+	defer func(interval time.Duration) { certs.CheckInterval = interval }(certs.CheckInterval)
+	certs.CheckInterval = 0
+
+	ts := httptest.NewUnstartedServer(sc.newEcho())
+	ts.TLS = sc.TLS.serverConfig()
+	ts.StartTLS()
+	defer ts.Close()
+
+	serverName := func() string {
+		config, err := client.TLSOptions{
+			CAFile:   filepath.Join(sc.DataDir, "ca.crt"),
+			CertFile: filepath.Join(sc.DataDir, "reader.crt"),
+			KeyFile:  filepath.Join(sc.DataDir, "reader.key"),
+		}.Config()
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		resp, err := (&http.Client{Transport: &http.Transport{TLSClientConfig: config}}).Get(ts.URL + "/files")
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		resp.Body.Close()
+		return resp.TLS.PeerCertificates[0].Subject.CommonName
+	}
+	assert.Equal(t, "server", serverName())
+
+	// replace the server certificate by one with another name, the mtime is
+	// moved forward as the files may be written within the mtime resolution
+	caCert, _ := os.ReadFile(filepath.Join(sc.DataDir, "ca.crt"))
+	ca, _ := x509.ParseCertificate(mustDecodePEM(t, caCert))
+	caKeyPEM, _ := os.ReadFile(filepath.Join(sc.DataDir, "ca.key"))
+	caKey, _ := x509.ParseECPrivateKey(mustDecodePEM(t, caKeyPEM))
+	writeTestCert(t, sc.DataDir, "renewed", ca, caKey)
+	later := time.Now().Add(time.Minute)
+	for _, ext := range []string{".crt", ".key"} {
+		assert.NoError(t, os.Rename(filepath.Join(sc.DataDir, "renewed"+ext), filepath.Join(sc.DataDir, "server"+ext)))
+		assert.NoError(t, os.Chtimes(filepath.Join(sc.DataDir, "server"+ext), later, later))
+	}
+	assert.Equal(t, "renewed", serverName(), "Certificate not reloaded")
+
+	// invalid files keep the loaded certificate
+	assert.NoError(t, os.WriteFile(filepath.Join(sc.DataDir, "server.crt"), []byte("invalid"), 0600))
+	assert.Equal(t, "renewed", serverName(), "Invalid certificate loaded")
+}
+
+// mustDecodePEM returns the content of the first PEM block
+func mustDecodePEM(t *testing.T, data []byte) []byte {
+	block, _ := pem.Decode(data)
+	if !assert.NotNil(t, block, "Invalid PEM") {
+		t.FailNow()
+	}
+	return block.Bytes
+}
+
+// Test_TLS_GRPC tests the gRPC API over mutual TLS
+func Test_TLS_GRPC(t *testing.T) {
+	sc, _ := getTLSServerConfig(t)
+	defer os.RemoveAll(sc.DataDir)
+
+	creds, err := client.WithTLS(client.TLSOptions{
+		CAFile:   filepath.Join(sc.DataDir, "ca.crt"),
+		CertFile: filepath.Join(sc.DataDir, "reader.crt"),
+		KeyFile:  filepath.Join(sc.DataDir, "reader.key"),
+	})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	conf := getGRPCClient(t, sc, creds)
+	_, err = conf.ListAllFiles(client.ListOptions{})
+	assert.NoError(t, err, "Error listing files with a client certificate")
+	_, err = conf.StatFile("a.txt")
+	assert.EqualError(t, err, "File doesn't exist")
+	assert.EqualError(t, conf.DeleteFile("a.txt"), "Permission denied")
+}
@@ -0,0 +1,401 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	. "fs-store/types"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// uploadDirName is the staging directory for upload sessions in the data directory
+	uploadDirName = ".uploads"
+
+	// DefaultChunkSize is the chunk size used when a session doesn't specify one
+	DefaultChunkSize int64 = 8 << 20
+
+	// DefaultUploadTTL is how long uploads without activity are kept
+	DefaultUploadTTL = 24 * time.Hour
+)
+
+// uploadSweepInterval is how often expired uploads are removed, at most the upload TTL
+var uploadSweepInterval = time.Hour
+
+// Define Errors
+var (
+	// ErrUploadDoesntExist is returned when an upload session doesn't exist
+	ErrUploadDoesntExist = errors.New("upload session doesn't exist")
+
+	// ErrUploadIncomplete is returned when committing a session with missing chunks
+	ErrUploadIncomplete = errors.New("upload session is incomplete")
+
+	// ErrInvalidChunk is returned when a chunk number or size doesn't match the session
+	ErrInvalidChunk = errors.New("invalid chunk")
+
+	// ErrFileTooLarge is returned when content of unknown size exceeds the max file size
+	ErrFileTooLarge = errors.New("file too large")
+)
+
+// spoolUpload copies content of unknown size to a temp file in the staging
+// directory, up to the max file size. The file is returned at its start with
+// the size of the content, the caller closes and removes it
+func (sc *ServerConfig) spoolUpload(r io.Reader) (*os.File, int64, error) {
+	dir := filepath.Join(sc.DataDir, uploadDirName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, 0, err
+	}
+	file, err := os.CreateTemp(dir, tempFilePrefix+"*")
+	if err != nil {
+		return nil, 0, err
+	}
+	size, err := io.Copy(file, io.LimitReader(r, sc.MaxFileSize+1))
+	if err == nil && size > sc.MaxFileSize {
+		err = ErrFileTooLarge
+	}
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, 0, err
+	}
+	return file, size, nil
+}
+
+// uploadDir returns the staging directory of an upload session
+func (sc *ServerConfig) uploadDir(uploadID string) (string, error) {
+	// upload ids are hex encoded, this prevents escaping the staging directory
+	if b, err := hex.DecodeString(uploadID); err != nil || len(b) != 16 {
+		return "", ErrUploadDoesntExist
+	}
+	return filepath.Join(sc.DataDir, uploadDirName, uploadID), nil
+}
+
+// createUploadSession creates an upload session owned by the API key name with
+// a staging file for the content
+func (sc *ServerConfig) createUploadSession(owner string, req UploadSessionRequest) (*UploadSessionResponse, error) {
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		return nil, err
+	}
+
+	chunkSize := req.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	// a chunk is at most the whole file, so chunk offsets stay within the file size
+	if req.FileSize > 0 && chunkSize > req.FileSize {
+		chunkSize = req.FileSize
+	}
+
+	session := &UploadSessionResponse{
+		UploadID:  hex.EncodeToString(idBytes),
+		FileName:  req.FileName,
+		FileSize:  req.FileSize,
+		ChunkSize: chunkSize,
+		Overwrite: req.Overwrite,
+		CreatedAt: time.Now(),
+		Received:  []ByteRange{},
+
+		ContentType: req.ContentType,
+		Metadata:    req.Metadata,
+
+		Owner: owner,
+	}
+
+	dir := filepath.Join(sc.DataDir, uploadDirName, session.UploadID)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	// create a sparse file for chunks to be written at their offsets
+	data, err := os.Create(filepath.Join(dir, "data"))
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	err = data.Truncate(session.FileSize)
+	data.Close()
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	sc.uploadLock.Lock()
+	defer sc.uploadLock.Unlock()
+	if err := writeUploadSession(dir, session); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	return session, nil
+}
+
+// getUploadSession returns an upload session of the owner, sessions of other
+// owners don't exist for it
+func (sc *ServerConfig) getUploadSession(owner, uploadID string) (*UploadSessionResponse, error) {
+	dir, err := sc.uploadDir(uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	sc.uploadLock.Lock()
+	defer sc.uploadLock.Unlock()
+	session, err := readUploadSession(dir)
+	if err != nil {
+		return nil, err
+	}
+	if session.Owner != owner {
+		return nil, ErrUploadDoesntExist
+	}
+	return session, nil
+}
+
+// writeUploadChunk writes a numbered chunk at its offset in the staging file
+func (sc *ServerConfig) writeUploadChunk(owner, uploadID string, number, size int64, r io.Reader) (*UploadSessionResponse, error) {
+	session, err := sc.getUploadSession(owner, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	// check the number before computing the offset so it can't overflow
+	if session.ChunkSize <= 0 || number < 0 || number > (session.FileSize-1)/session.ChunkSize {
+		return nil, ErrInvalidChunk
+	}
+	offset := number * session.ChunkSize
+
+	// every chunk has the chunk size except the last one
+	length := session.ChunkSize
+	if offset+length > session.FileSize {
+		length = session.FileSize - offset
+	}
+	if size != length {
+		return nil, ErrInvalidChunk
+	}
+
+	dir, _ := sc.uploadDir(uploadID)
+	data, err := os.OpenFile(filepath.Join(dir, "data"), os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer data.Close()
+
+	if _, err := data.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if _, err := io.CopyN(data, r, length); err != nil {
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if err := data.Sync(); err != nil {
+		return nil, err
+	}
+
+	// record the range after the chunk was written, re-read the session since
+	// other chunks might have been recorded in the meantime
+	sc.uploadLock.Lock()
+	defer sc.uploadLock.Unlock()
+	session, err = readUploadSession(dir)
+	if err != nil {
+		return nil, err
+	}
+	session.Received = addByteRange(session.Received,
+		ByteRange{Start: offset, End: offset + length})
+	if err := writeUploadSession(dir, session); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// commitUploadSession creates the file from a complete upload session and removes the session
+func (sc *ServerConfig) commitUploadSession(actor auditActor, owner, uploadID string) error {
+	session, err := sc.getUploadSession(owner, uploadID)
+	if err != nil {
+		return err
+	}
+
+	if len(session.Received) != 1 || session.Received[0].Start != 0 ||
+		session.Received[0].End != session.FileSize {
+		return ErrUploadIncomplete
+	}
+
+	dir, _ := sc.uploadDir(uploadID)
+	data, err := os.Open(filepath.Join(dir, "data"))
+	if err != nil {
+		return err
+	}
+	err = sc.createFileStore(actor, &FileStore{
+		FileName:    session.FileName,
+		DataSize:    session.FileSize,
+		Reader:      data,
+		ContentType: session.ContentType,
+		Metadata:    session.Metadata,
+	}, session.Overwrite)
+	data.Close()
+	if err != nil {
+		return err
+	}
+
+	return sc.abortUploadSession(owner, uploadID)
+}
+
+// abortUploadSession removes an upload session of the owner and its staged content
+func (sc *ServerConfig) abortUploadSession(owner, uploadID string) error {
+	dir, err := sc.uploadDir(uploadID)
+	if err != nil {
+		return err
+	}
+
+	sc.uploadLock.Lock()
+	defer sc.uploadLock.Unlock()
+	session, err := readUploadSession(dir)
+	if err != nil {
+		return err
+	}
+	if session.Owner != owner {
+		return ErrUploadDoesntExist
+	}
+	return os.RemoveAll(dir)
+}
+
+// sweepUploads removes the upload sessions, S3 multipart uploads and temp
+// files of the staging directory without activity for longer than the upload TTL
+func (sc *ServerConfig) sweepUploads() error {
+	if sc.UploadTTL <= 0 {
+		return nil
+	}
+	dir := filepath.Join(sc.DataDir, uploadDirName)
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	expired := time.Now().Add(-sc.UploadTTL)
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		active, err := lastActivity(path)
+		if err != nil || active.After(expired) {
+			continue
+		}
+
+		sc.uploadLock.Lock()
+		err = os.RemoveAll(path)
+		sc.uploadLock.Unlock()
+		if err != nil {
+			logrus.Warn("Error while removing expired upload ", err)
+			continue
+		}
+		logrus.Info("Removed expired upload: ", entry.Name())
+	}
+	return nil
+}
+
+// sweepUploadsUntil removes expired uploads on start and then periodically until stop is closed
+func (sc *ServerConfig) sweepUploadsUntil(stop chan struct{}) {
+	interval := uploadSweepInterval
+	if sc.UploadTTL < interval {
+		interval = sc.UploadTTL
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := sc.sweepUploads(); err != nil {
+			logrus.Warn("Error while removing expired uploads ", err)
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// lastActivity returns the latest modification time of a staging entry and
+// the files in it, chunks and parts update it when they are written
+func lastActivity(path string) (time.Time, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	latest := info.ModTime()
+	if !info.IsDir() {
+		return latest, nil
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, entry := range entries {
+		if info, err := entry.Info(); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// readUploadSession reads the session metadata from a staging directory
+func readUploadSession(dir string) (*UploadSessionResponse, error) {
+	b, err := os.ReadFile(filepath.Join(dir, "session.json"))
+	if os.IsNotExist(err) {
+		return nil, ErrUploadDoesntExist
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var session UploadSessionResponse
+	if err := json.Unmarshal(b, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// writeUploadSession writes the session metadata to a staging directory
+func writeUploadSession(dir string, session *UploadSessionResponse) error {
+	b, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+
+	// write and rename so a crash never leaves a partial session file
+	tmpPath := filepath.Join(dir, "session.json.tmp")
+	if err := os.WriteFile(tmpPath, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, filepath.Join(dir, "session.json"))
+}
+
+// addByteRange adds a range to a list of ranges, merging overlapping and adjacent ranges
+func addByteRange(ranges []ByteRange, r ByteRange) []ByteRange {
+	ranges = append(ranges, r)
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].Start < ranges[j].Start
+	})
+
+	merged := ranges[:1]
+	for _, cur := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if cur.Start <= last.End {
+			if cur.End > last.End {
+				last.End = cur.End
+			}
+			continue
+		}
+		merged = append(merged, cur)
+	}
+	return merged
+}
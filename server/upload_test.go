@@ -0,0 +1,213 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "fs-store/types"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test_UploadSession tests uploading a file in chunks and committing it
+func Test_UploadSession(t *testing.T) {
+	sc := getServerConfig(t)
+	defer os.RemoveAll(sc.DataDir)
+
+	fn := "session_test.txt"
+	data := "0123456789"
+
+	session, err := sc.createUploadSession("", UploadSessionRequest{
+		FileName:  fn,
+		FileSize:  int64(len(data)),
+		ChunkSize: 4,
+	})
+	if !assert.NoError(t, err, "Error creating upload session") {
+		return
+	}
+
+	// upload the chunks out of order
+	for _, number := range []int64{2, 0} {
+		chunk := data[number*4 : min64(number*4+4, int64(len(data)))]
+		_, err := sc.writeUploadChunk("", session.UploadID, number,
+			int64(len(chunk)), strings.NewReader(chunk))
+		assert.NoError(t, err, "Error writing chunk")
+	}
+
+	err = sc.commitUploadSession(auditActor{}, "", session.UploadID)
+	assert.ErrorIs(t, err, ErrUploadIncomplete, "Incomplete session was committed")
+
+	session, err = sc.writeUploadChunk("", session.UploadID, 1, 4, strings.NewReader("4567"))
+	if assert.NoError(t, err, "Error writing chunk") {
+		assert.Equal(t, []ByteRange{{Start: 0, End: 10}}, session.Received,
+			"Received ranges are not merged")
+	}
+
+	err = sc.commitUploadSession(auditActor{}, "", session.UploadID)
+	if !assert.NoError(t, err, "Error committing upload session") {
+		return
+	}
+
+	file, store, err := sc.openFile(fn)
+	if assert.NoError(t, err, "Error opening file") {
+		defer file.Close()
+		dataBytes, err := io.ReadAll(store)
+		if assert.NoError(t, err, "Error reading content from file") {
+			assert.Equal(t, data, string(dataBytes), "File data is not the same")
+		}
+	}
+
+	_, err = sc.getUploadSession("", session.UploadID)
+	assert.ErrorIs(t, err, ErrUploadDoesntExist, "Session was not removed after commit")
+}
+
+// Test_UploadSession_InvalidChunk tests the rejection of chunks not matching the session
+func Test_UploadSession_InvalidChunk(t *testing.T) {
+	sc := getServerConfig(t)
+	defer os.RemoveAll(sc.DataDir)
+
+	session, err := sc.createUploadSession("", UploadSessionRequest{
+		FileName:  "invalid_chunk_test.txt",
+		FileSize:  10,
+		ChunkSize: 4,
+	})
+	if !assert.NoError(t, err, "Error creating upload session") {
+		return
+	}
+
+	_, err = sc.writeUploadChunk("", session.UploadID, 3, 4, strings.NewReader("0123"))
+	assert.ErrorIs(t, err, ErrInvalidChunk, "Chunk after end was accepted")
+
+	_, err = sc.writeUploadChunk("", session.UploadID, 2, 4, strings.NewReader("0123"))
+	assert.ErrorIs(t, err, ErrInvalidChunk, "Chunk with wrong size was accepted")
+
+	_, err = sc.writeUploadChunk("", session.UploadID, 0, 4, strings.NewReader("01"))
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF, "Short chunk was accepted")
+
+	_, err = sc.writeUploadChunk("", session.UploadID, math.MaxInt64/2, 4, strings.NewReader("0123"))
+	assert.ErrorIs(t, err, ErrInvalidChunk, "Chunk with an overflowing offset was accepted")
+
+	_, err = sc.getUploadSession("", "../../etc")
+	assert.ErrorIs(t, err, ErrUploadDoesntExist, "Invalid upload id was accepted")
+
+	// chunks are at most the file size
+	session, err = sc.createUploadSession("", UploadSessionRequest{
+		FileName:  "huge_chunk_test.txt",
+		FileSize:  10,
+		ChunkSize: math.MaxInt64,
+	})
+	if assert.NoError(t, err, "Error creating upload session") {
+		assert.Equal(t, int64(10), session.ChunkSize, "Chunk size is larger than the file")
+		_, err = sc.writeUploadChunk("", session.UploadID, 1, 0, strings.NewReader(""))
+		assert.ErrorIs(t, err, ErrInvalidChunk, "Chunk after the file was accepted")
+	}
+}
+
+// Test_UploadSession_Owner tests that upload sessions are only accessible by the key which created them
+func Test_UploadSession_Owner(t *testing.T) {
+	sc, tokens := getAuthServerConfig(t)
+	defer os.RemoveAll(sc.DataDir)
+
+	request := func(token, method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		if strings.HasPrefix(body, "{") {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		return doRequest(sc, req)
+	}
+
+	rec := request(tokens["builds"], "POST", "/uploads", `{"fileName":"builds/a.txt","fileSize":4,"chunkSize":4}`)
+	if !assert.Equal(t, 201, rec.Code, "Error creating upload session") {
+		return
+	}
+	session := UploadSessionResponse{}
+	if !assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &session)) {
+		return
+	}
+	assert.Equal(t, "builds", session.Owner, "Owner is not the key of the session")
+
+	id := session.UploadID
+	assert.Equal(t, 404, request(tokens["admin"], "GET", "/uploads/"+id, "").Code, "Session of another key was returned")
+	assert.Equal(t, 404, request(tokens["admin"], "PUT", "/uploads/"+id+"/chunks/0", "data").Code, "Chunk of another key was written")
+	assert.Equal(t, 404, request(tokens["admin"], "POST", "/uploads/"+id+"/commit", "").Code, "Session of another key was committed")
+	assert.Equal(t, 404, request(tokens["admin"], "DELETE", "/uploads/"+id, "").Code, "Session of another key was aborted")
+
+	assert.Equal(t, 200, request(tokens["builds"], "PUT", "/uploads/"+id+"/chunks/0", "data").Code)
+	assert.Equal(t, 200, request(tokens["builds"], "POST", "/uploads/"+id+"/commit", "").Code)
+}
+
+// Test_sweepUploads tests the removal of uploads without activity for longer than the upload TTL
+func Test_sweepUploads(t *testing.T) {
+	sc := getServerConfig(t)
+	defer os.RemoveAll(sc.DataDir)
+	sc.UploadTTL = time.Hour
+
+	expired, err := sc.createUploadSession("", UploadSessionRequest{FileName: "expired.txt", FileSize: 4})
+	if !assert.NoError(t, err, "Error creating upload session") {
+		return
+	}
+	active, err := sc.createUploadSession("", UploadSessionRequest{FileName: "active.txt", FileSize: 4})
+	if !assert.NoError(t, err, "Error creating upload session") {
+		return
+	}
+
+	// age every file of the expired session and only the directory of the active one
+	old := time.Now().Add(-2 * time.Hour)
+	for _, id := range []string{expired.UploadID, active.UploadID} {
+		dir := filepath.Join(sc.DataDir, uploadDirName, id)
+		if id == expired.UploadID {
+			entries, _ := os.ReadDir(dir)
+			for _, entry := range entries {
+				assert.NoError(t, os.Chtimes(filepath.Join(dir, entry.Name()), old, old))
+			}
+		}
+		assert.NoError(t, os.Chtimes(dir, old, old))
+	}
+
+	if !assert.NoError(t, sc.sweepUploads(), "Error removing expired uploads") {
+		return
+	}
+	_, err = sc.getUploadSession("", expired.UploadID)
+	assert.ErrorIs(t, err, ErrUploadDoesntExist, "Expired session was not removed")
+	_, err = sc.getUploadSession("", active.UploadID)
+	assert.NoError(t, err, "Session with recent chunks was removed")
+}
+
+// Test_addByteRange tests the merging of received ranges
+func Test_addByteRange(t *testing.T) {
+	for _, test := range []struct {
+		description string
+		ranges      []ByteRange
+		add         ByteRange
+		expected    []ByteRange
+	}{
+		{"Empty", []ByteRange{}, br(0, 4), []ByteRange{br(0, 4)}},
+		{"Adjacent", []ByteRange{br(0, 4)}, br(4, 8), []ByteRange{br(0, 8)}},
+		{"Gap", []ByteRange{br(0, 4)}, br(8, 12), []ByteRange{br(0, 4), br(8, 12)}},
+		{"Fill gap", []ByteRange{br(0, 4), br(8, 12)}, br(4, 8), []ByteRange{br(0, 12)}},
+		{"Duplicate", []ByteRange{br(0, 4)}, br(0, 4), []ByteRange{br(0, 4)}},
+	} {
+		t.Run(test.description, func(t *testing.T) {
+			assert.Equal(t, test.expected, addByteRange(test.ranges, test.add))
+		})
+	}
+}
+
+func br(start, end int64) ByteRange {
+	return ByteRange{Start: start, End: end}
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,571 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	. "fs-store/types"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/webdav"
+)
+
+// davMetadataSpace is the XML namespace of the file metadata properties
+const davMetadataSpace = "urn:fs-store:metadata"
+
+var (
+	// errDavFileTooLarge is returned when a file written over WebDAV exceeds the max file size
+	errDavFileTooLarge = errors.New("file too large")
+
+	// errDavNotReadable is returned when reading a file opened for writing or a directory
+	errDavNotReadable = errors.New("file is not readable")
+
+	// errDavNotWritable is returned when writing a file opened for reading or a directory
+	errDavNotWritable = errors.New("file is not writable")
+
+	// davPropName matches metadata keys which are valid XML names
+	davPropName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9._-]*$`)
+)
+
+// davAPIKey is the context key of the API key of WebDAV requests
+type davAPIKey struct{}
+
+// davBody is a request body read through a buffer
+type davBody struct {
+	io.Reader
+	io.Closer
+}
+
+// webdavMiddleware serves the WebDAV handler for requests under the WebDAV path,
+// echo can't route methods like MOVE and MKCOL so the handler is served before routing
+func (sc *ServerConfig) webdavMiddleware() echo.MiddlewareFunc {
+	prefix := path.Clean("/" + sc.WebDAVPath)
+	handler := &webdav.Handler{
+		Prefix:     prefix,
+		FileSystem: newDavFS(sc),
+		LockSystem: webdav.NewMemLS(),
+		Logger: func(r *http.Request, err error) {
+			if err != nil {
+				logrus.Debug("WebDAV ", r.Method, " ", r.URL.Path, ": ", err)
+			}
+		},
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			p := c.Request().URL.Path
+			if p != prefix && !strings.HasPrefix(p, prefix+"/") {
+				return next(c)
+			}
+			// V1 can't store empty files, the handler would answer 405 for the failed write
+			if c.Request().Method == http.MethodPut && sc.storeVersion() == FSStoreV1 {
+				body := bufio.NewReader(c.Request().Body)
+				if _, err := body.Peek(1); err == io.EOF {
+					http.Error(c.Response(), "File is empty", 400)
+					return nil
+				}
+				c.Request().Body = davBody{Reader: body, Closer: c.Request().Body}
+			}
+			ctx := context.WithValue(c.Request().Context(), auditActorKey{}, sc.echoActor(c))
+			if key, ok := c.Get(apiKeyContextKey).(*APIKey); ok {
+				ctx = context.WithValue(ctx, davAPIKey{}, key)
+			}
+			handler.ServeHTTP(c.Response(), c.Request().WithContext(ctx))
+			return nil
+		}
+	}
+}
+
+// davFS is a WebDAV file system reading and writing through the server config,
+// directories are the virtual directories of the file names, empty directories
+// created with MKCOL are only kept in memory until a file is put into them
+type davFS struct {
+	sc *ServerConfig
+
+	mtx  *sync.Mutex
+	dirs map[string]bool
+}
+
+// newDavFS creates a WebDAV file system for the server config
+func newDavFS(sc *ServerConfig) *davFS {
+	return &davFS{
+		sc:   sc,
+		mtx:  &sync.Mutex{},
+		dirs: make(map[string]bool),
+	}
+}
+
+// davName returns the file name of a WebDAV path, the root is the empty name
+func davName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// dirExists checks if a directory exists, either as a prefix of a file name or created empty
+func (fs *davFS) dirExists(name string) bool {
+	if name == "" {
+		return true
+	}
+	fs.mtx.Lock()
+	created := fs.dirs[name]
+	fs.mtx.Unlock()
+	return created || fs.sc.index.hasPrefix(name+"/")
+}
+
+// removeDirs forgets the empty directories at or under the name
+func (fs *davFS) removeDirs(name string) {
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+	for dir := range fs.dirs {
+		if dir == name || strings.HasPrefix(dir, name+"/") {
+			delete(fs.dirs, dir)
+		}
+	}
+}
+
+// Mkdir creates an empty directory, the parent directory must exist
+func (fs *davFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	name = davName(name)
+	if name == "" || len(name) > 255 {
+		return os.ErrExist
+	}
+	if _, ok := fs.sc.index.get(name); ok || fs.dirExists(name) {
+		return os.ErrExist
+	}
+	if parent := path.Dir(name); parent != "." && !fs.dirExists(parent) {
+		return os.ErrNotExist
+	}
+
+	fs.mtx.Lock()
+	fs.dirs[name] = true
+	fs.mtx.Unlock()
+	return nil
+}
+
+// OpenFile opens a file or directory, files opened for writing are staged in
+// a temp file and stored once they are closed
+func (fs *davFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	name = davName(name)
+	file, exists := fs.sc.index.get(name)
+
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC) != 0 {
+		if name == "" || len(name) > 255 || (!exists && fs.dirExists(name)) {
+			return nil, os.ErrInvalid
+		}
+		if exists && flag&os.O_EXCL != 0 {
+			return nil, os.ErrExist
+		}
+		if !exists && flag&os.O_CREATE == 0 {
+			return nil, os.ErrNotExist
+		}
+		return fs.createTemp(contextActor(ctx), name)
+	}
+
+	if exists {
+		return &davFile{fs: fs, info: file}, nil
+	}
+	if fs.dirExists(name) {
+		dir := &davDir{fs: fs, name: name}
+		if key, ok := ctx.Value(davAPIKey{}).(*APIKey); ok {
+			dir.visible = fs.sc.listFilter(key)
+		}
+		return dir, nil
+	}
+	return nil, os.ErrNotExist
+}
+
+// createTemp creates the staging file for writing a file
+func (fs *davFS) createTemp(actor auditActor, name string) (*davWriteFile, error) {
+	dir := filepath.Join(fs.sc.DataDir, uploadDirName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	temp, err := os.CreateTemp(dir, tempFilePrefix+"*")
+	if err != nil {
+		return nil, err
+	}
+	return &davWriteFile{fs: fs, actor: actor, name: name, temp: temp, modifiedAt: time.Now()}, nil
+}
+
+// RemoveAll deletes a file or a directory with every file in it
+func (fs *davFS) RemoveAll(ctx context.Context, name string) error {
+	name = davName(name)
+	if name == "" {
+		return os.ErrPermission
+	}
+
+	if _, ok := fs.sc.index.get(name); ok {
+		err := fs.sc.deleteFile(contextActor(ctx), name)
+		if err == ErrFileDoesntExist {
+			return os.ErrNotExist
+		}
+		return err
+	}
+	if !fs.dirExists(name) {
+		return os.ErrNotExist
+	}
+	fs.removeDirs(name)
+	_, err := fs.sc.deleteFiles(contextActor(ctx), name+"/")
+	return err
+}
+
+// Rename moves a file or every file in a directory to the new name
+func (fs *davFS) Rename(ctx context.Context, oldName, newName string) error {
+	oldName, newName = davName(oldName), davName(newName)
+	if oldName == "" || newName == "" || len(newName) > 255 {
+		return os.ErrPermission
+	}
+
+	if _, ok := fs.sc.index.get(oldName); ok {
+		return fs.moveFile(contextActor(ctx), oldName, newName)
+	}
+	if !fs.dirExists(oldName) {
+		return os.ErrNotExist
+	}
+	if strings.HasPrefix(newName, oldName+"/") {
+		return os.ErrInvalid
+	}
+
+	for _, fileName := range fs.sc.index.names(oldName + "/") {
+		if err := fs.moveFile(contextActor(ctx), fileName, newName+strings.TrimPrefix(fileName, oldName)); err != nil {
+			return err
+		}
+	}
+
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+	for dir := range fs.dirs {
+		if dir == oldName || strings.HasPrefix(dir, oldName+"/") {
+			delete(fs.dirs, dir)
+			fs.dirs[newName+strings.TrimPrefix(dir, oldName)] = true
+		}
+	}
+	return nil
+}
+
+// moveFile copies a file to the new name and deletes the old file
+func (fs *davFS) moveFile(actor auditActor, oldName, newName string) error {
+	if len(newName) > 255 {
+		return os.ErrInvalid
+	}
+	file, store, err := fs.sc.openFile(oldName)
+	if err == ErrFileDoesntExist {
+		return os.ErrNotExist
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	err = fs.sc.createFileStore(actor, &FileStore{
+		FileName:    newName,
+		DataSize:    store.DataSize,
+		Reader:      file,
+		ContentType: store.ContentType,
+		Metadata:    store.Metadata,
+	}, true)
+	if err != nil {
+		return err
+	}
+	if err := fs.sc.deleteFile(actor, oldName); err != nil && err != ErrFileDoesntExist {
+		return err
+	}
+	return nil
+}
+
+// Stat returns the information of a file or directory
+func (fs *davFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	name = davName(name)
+	if file, ok := fs.sc.index.get(name); ok {
+		return &davFileInfo{file: file}, nil
+	}
+	if fs.dirExists(name) {
+		return &davFileInfo{file: FileResponse{FileName: name}, dir: true}, nil
+	}
+	return nil, os.ErrNotExist
+}
+
+// davFileInfo maps the information of a file to a file info
+type davFileInfo struct {
+	file FileResponse
+	dir  bool
+}
+
+func (fi *davFileInfo) Name() string       { return path.Base("/" + fi.file.FileName) }
+func (fi *davFileInfo) Size() int64        { return fi.file.FileSize }
+func (fi *davFileInfo) ModTime() time.Time { return fi.file.ModifiedAt }
+func (fi *davFileInfo) IsDir() bool        { return fi.dir }
+func (fi *davFileInfo) Sys() interface{}   { return nil }
+
+func (fi *davFileInfo) Mode() os.FileMode {
+	if fi.dir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+
+// ContentType returns the stored content type, falling back to detecting it
+func (fi *davFileInfo) ContentType(ctx context.Context) (string, error) {
+	if fi.file.ContentType == "" {
+		return "", webdav.ErrNotImplemented
+	}
+	return fi.file.ContentType, nil
+}
+
+// davFile is a file opened for reading, the content is only opened once it's read
+type davFile struct {
+	fs     *davFS
+	info   FileResponse
+	reader FileReader
+}
+
+// open opens the content of the file
+func (f *davFile) open() error {
+	if f.reader != nil {
+		return nil
+	}
+	reader, _, err := f.fs.sc.openFile(f.info.FileName)
+	if err == ErrFileDoesntExist {
+		return os.ErrNotExist
+	} else if err != nil {
+		return err
+	}
+	f.reader = reader
+	return nil
+}
+
+func (f *davFile) Read(p []byte) (int, error) {
+	if err := f.open(); err != nil {
+		return 0, err
+	}
+	return f.reader.Read(p)
+}
+
+func (f *davFile) Seek(offset int64, whence int) (int64, error) {
+	if err := f.open(); err != nil {
+		return 0, err
+	}
+	return f.reader.Seek(offset, whence)
+}
+
+func (f *davFile) Close() error {
+	if f.reader == nil {
+		return nil
+	}
+	return f.reader.Close()
+}
+
+func (f *davFile) Write(p []byte) (int, error) {
+	return 0, errDavNotWritable
+}
+
+func (f *davFile) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, errDavNotReadable
+}
+
+func (f *davFile) Stat() (os.FileInfo, error) {
+	return &davFileInfo{file: f.info}, nil
+}
+
+// DeadProps returns the creation time and the metadata of the file as properties
+func (f *davFile) DeadProps() (map[xml.Name]webdav.Property, error) {
+	props := make(map[xml.Name]webdav.Property)
+	addProp := func(name xml.Name, value string) {
+		var b bytes.Buffer
+		xml.EscapeText(&b, []byte(value))
+		props[name] = webdav.Property{XMLName: name, InnerXML: b.Bytes()}
+	}
+
+	if !f.info.CreatedAt.IsZero() {
+		addProp(xml.Name{Space: "DAV:", Local: "creationdate"}, f.info.CreatedAt.UTC().Format(time.RFC3339))
+	}
+	for key, value := range f.info.Metadata {
+		if davPropName.MatchString(key) {
+			addProp(xml.Name{Space: davMetadataSpace, Local: key}, value)
+		}
+	}
+	return props, nil
+}
+
+// Patch rejects changing properties, the metadata is only set when uploading
+func (f *davFile) Patch(patches []webdav.Proppatch) ([]webdav.Propstat, error) {
+	pstat := webdav.Propstat{Status: http.StatusForbidden}
+	for _, patch := range patches {
+		for _, prop := range patch.Props {
+			pstat.Props = append(pstat.Props, webdav.Property{XMLName: prop.XMLName})
+		}
+	}
+	return []webdav.Propstat{pstat}, nil
+}
+
+// davDir is an opened directory, the entries are listed on the first read
+type davDir struct {
+	fs      *davFS
+	name    string
+	entries []os.FileInfo
+	listed  bool
+
+	// visible filters the entries by the ACL policy, every entry is listed without it
+	visible func(name string, commonPrefix bool) bool
+}
+
+// list lists the files and directories in the directory from the index
+func (d *davDir) list() error {
+	prefix := ""
+	if d.name != "" {
+		prefix = d.name + "/"
+	}
+
+	seen := make(map[string]bool)
+	opts := listOptions{Prefix: prefix, Delimiter: "/", Limit: d.fs.sc.MaxListSize, visible: d.visible}
+	for {
+		page, err := d.fs.sc.listFiles(opts)
+		if err != nil {
+			return err
+		}
+		for _, file := range page.Files {
+			d.entries = append(d.entries, &davFileInfo{file: file})
+		}
+		for _, dir := range page.CommonPrefixes {
+			dir = strings.TrimSuffix(dir, "/")
+			seen[dir] = true
+			d.entries = append(d.entries, &davFileInfo{file: FileResponse{FileName: dir}, dir: true})
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		opts.Cursor = page.NextCursor
+	}
+
+	d.fs.mtx.Lock()
+	defer d.fs.mtx.Unlock()
+	for dir := range d.fs.dirs {
+		if path.Dir("/"+dir) == path.Clean("/"+d.name) && !seen[dir] && (d.visible == nil || d.visible(dir+"/", true)) {
+			d.entries = append(d.entries, &davFileInfo{file: FileResponse{FileName: dir}, dir: true})
+		}
+	}
+	return nil
+}
+
+func (d *davDir) Readdir(count int) ([]os.FileInfo, error) {
+	if !d.listed {
+		if err := d.list(); err != nil {
+			return nil, err
+		}
+		d.listed = true
+	}
+
+	if count <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(d.entries) {
+		count = len(d.entries)
+	}
+	entries := d.entries[:count]
+	d.entries = d.entries[count:]
+	return entries, nil
+}
+
+func (d *davDir) Stat() (os.FileInfo, error) {
+	return &davFileInfo{file: FileResponse{FileName: d.name}, dir: true}, nil
+}
+
+func (d *davDir) Read(p []byte) (int, error) {
+	return 0, errDavNotReadable
+}
+
+func (d *davDir) Seek(offset int64, whence int) (int64, error) {
+	return 0, errDavNotReadable
+}
+
+func (d *davDir) Write(p []byte) (int, error) {
+	return 0, errDavNotWritable
+}
+
+func (d *davDir) Close() error {
+	return nil
+}
+
+// davWriteFile is a file opened for writing, the content is staged in a temp
+// file which is stored through createFileStore once it's closed
+type davWriteFile struct {
+	fs         *davFS
+	actor      auditActor
+	name       string
+	temp       *os.File
+	size       int64
+	modifiedAt time.Time
+
+	// err is the first write error, the file isn't stored if writing failed
+	err error
+}
+
+func (f *davWriteFile) Write(p []byte) (int, error) {
+	if f.err != nil {
+		return 0, f.err
+	}
+	if f.size+int64(len(p)) > f.fs.sc.MaxFileSize {
+		f.err = errDavFileTooLarge
+		return 0, f.err
+	}
+	n, err := f.temp.Write(p)
+	f.size += int64(n)
+	f.err = err
+	return n, err
+}
+
+func (f *davWriteFile) Close() error {
+	defer os.Remove(f.temp.Name())
+	defer f.temp.Close()
+
+	if f.err != nil {
+		return f.err
+	}
+	if _, err := f.temp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	logrus.Info("Uploading file over WebDAV: ", f.name)
+	return f.fs.sc.createFileStore(f.actor, &FileStore{
+		FileName:    f.name,
+		DataSize:    f.size,
+		Reader:      f.temp,
+		ContentType: mime.TypeByExtension(path.Ext(f.name)),
+	}, true)
+}
+
+func (f *davWriteFile) Stat() (os.FileInfo, error) {
+	return &davFileInfo{file: FileResponse{
+		FileName:   f.name,
+		FileSize:   f.size,
+		ModifiedAt: f.modifiedAt,
+	}}, nil
+}
+
+func (f *davWriteFile) Read(p []byte) (int, error) {
+	return 0, errDavNotReadable
+}
+
+func (f *davWriteFile) Seek(offset int64, whence int) (int64, error) {
+	return 0, errDavNotReadable
+}
+
+func (f *davWriteFile) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, errDavNotReadable
+}
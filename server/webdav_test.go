@@ -0,0 +1,156 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// davRequest creates a WebDAV request with the headers
+func davRequest(method, target, body string, headers ...string) *http.Request {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	return req
+}
+
+// Test_WebDAV_Files tests writing, reading and listing files over WebDAV
+func Test_WebDAV_Files(t *testing.T) {
+	sc := getServerConfig(t)
+	defer os.RemoveAll(sc.DataDir)
+	sc.WebDAVPath = "/dav"
+	e := sc.newEcho()
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, davRequest("PUT", "/dav/dir/a.txt", "0123"))
+	assert.Equal(t, 201, rec.Code, "Unexpected status code")
+
+	// Files written over WebDAV are regular files
+	file, ok := sc.index.get("dir/a.txt")
+	if assert.True(t, ok, "File not in the index") {
+		assert.Equal(t, int64(4), file.FileSize)
+		assert.Equal(t, "text/plain; charset=utf-8", file.ContentType)
+	}
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, davRequest("GET", "/files/dir/a.txt", ""))
+	assert.Equal(t, "0123", rec.Body.String(), "File data is not the same")
+
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, davRequest("GET", "/dav/dir/a.txt", "", "Range", "bytes=1-2"))
+	assert.Equal(t, 206, rec.Code, "Unexpected status code")
+	assert.Equal(t, "12", rec.Body.String(), "Unexpected range content")
+
+	err := sc.createFileStore(auditActor{}, &FileStore{
+		FileName: "dir/sub/b.txt",
+		DataSize: 2,
+		Reader:   strings.NewReader("ab"),
+		Metadata: map[string]string{"owner": "me"},
+	}, false)
+	if !assert.NoError(t, err, "Error creating file") {
+		return
+	}
+
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, davRequest("PROPFIND", "/dav/dir/", "", "Depth", "1"))
+	assert.Equal(t, 207, rec.Code, "Unexpected status code")
+	body := rec.Body.String()
+	assert.Contains(t, body, "<D:href>/dav/dir/a.txt</D:href>")
+	assert.Contains(t, body, "<D:getcontentlength>4</D:getcontentlength>")
+	assert.Contains(t, body, "<D:href>/dav/dir/sub/</D:href>")
+	assert.NotContains(t, body, "b.txt", "Depth 1 listed a nested file")
+
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, davRequest("PROPFIND", "/dav/dir/sub/b.txt", "", "Depth", "0"))
+	assert.Contains(t, rec.Body.String(), "<owner xmlns=\"urn:fs-store:metadata\">me</owner>", "Metadata not a property")
+	assert.Contains(t, rec.Body.String(), "<D:creationdate>", "Creation time not a property")
+
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, davRequest("PUT", "/dav/big.bin", "01234567890"))
+	assert.NotEqual(t, 201, rec.Code, "File larger than the max file size was stored")
+	_, ok = sc.index.get("big.bin")
+	assert.False(t, ok, "File larger than the max file size was stored")
+
+	// Other paths are still routed
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, davRequest("GET", "/files", ""))
+	assert.Equal(t, 200, rec.Code, "Unexpected status code")
+}
+
+// Test_WebDAV_EmptyFiles tests that empty files are only stored in versions which can store them
+func Test_WebDAV_EmptyFiles(t *testing.T) {
+	sc := getServerConfig(t)
+	defer os.RemoveAll(sc.DataDir)
+	sc.WebDAVPath = "/dav"
+	e := sc.newEcho()
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, davRequest("PUT", "/dav/empty.txt", ""))
+	assert.Equal(t, 201, rec.Code, "Unexpected status code")
+	file, ok := sc.index.get("empty.txt")
+	if assert.True(t, ok, "File not in the index") {
+		assert.Equal(t, int64(0), file.FileSize)
+	}
+
+	sc.Version = FSStoreV1
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, davRequest("PUT", "/dav/empty_v1.txt", ""))
+	assert.Equal(t, 400, rec.Code, "Empty file accepted by V1")
+	_, ok = sc.index.get("empty_v1.txt")
+	assert.False(t, ok, "Empty file stored in V1")
+
+	// the peeked content is still written
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, davRequest("PUT", "/dav/a.txt", "0123"))
+	assert.Equal(t, 201, rec.Code, "Unexpected status code")
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, davRequest("GET", "/dav/a.txt", ""))
+	assert.Equal(t, "0123", rec.Body.String(), "File data is not the same")
+}
+
+// Test_WebDAV_Collections tests creating, moving, copying and deleting over WebDAV
+func Test_WebDAV_Collections(t *testing.T) {
+	sc := getServerConfig(t)
+	defer os.RemoveAll(sc.DataDir)
+	sc.WebDAVPath = "/dav"
+	e := sc.newEcho()
+	serve := func(req *http.Request) int {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	assert.Equal(t, 201, serve(davRequest("MKCOL", "/dav/docs", "")), "Unexpected status code")
+	assert.Equal(t, 405, serve(davRequest("MKCOL", "/dav/docs", "")), "Existing collection created again")
+	assert.Equal(t, 409, serve(davRequest("MKCOL", "/dav/missing/docs", "")), "Collection created without parent")
+	assert.Equal(t, 207, serve(davRequest("PROPFIND", "/dav/docs/", "", "Depth", "0")), "Empty collection not found")
+
+	assert.Equal(t, 201, serve(davRequest("PUT", "/dav/docs/a.txt", "data")), "Unexpected status code")
+	assert.Equal(t, 201, serve(davRequest("COPY", "/dav/docs/a.txt", "", "Destination", "/dav/docs/b.txt")))
+	assert.Equal(t, 201, serve(davRequest("MOVE", "/dav/docs", "", "Destination", "/dav/archive")))
+	assert.Equal(t, []string{"archive/a.txt", "archive/b.txt"}, sc.index.names(""), "Files not moved")
+
+	// Moving a file onto an existing file requires overwriting
+	assert.Equal(t, 412, serve(davRequest("MOVE", "/dav/archive/a.txt", "",
+		"Destination", "/dav/archive/b.txt", "Overwrite", "F")))
+	assert.Equal(t, 204, serve(davRequest("MOVE", "/dav/archive/a.txt", "",
+		"Destination", "/dav/archive/b.txt", "Overwrite", "T")))
+	assert.Equal(t, []string{"archive/b.txt"}, sc.index.names(""), "File not replaced")
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, davRequest("LOCK", "/dav/archive/b.txt",
+		`<?xml version="1.0"?><D:lockinfo xmlns:D="DAV:"><D:lockscope><D:exclusive/></D:lockscope>`+
+			`<D:locktype><D:write/></D:locktype></D:lockinfo>`))
+	assert.Equal(t, 200, rec.Code, "Unexpected status code")
+	token := rec.Header().Get("Lock-Token")
+	assert.Equal(t, 423, serve(davRequest("DELETE", "/dav/archive/b.txt", "")), "Locked file was deleted")
+	assert.Equal(t, 204, serve(davRequest("UNLOCK", "/dav/archive/b.txt", "", "Lock-Token", token)))
+
+	assert.Equal(t, 204, serve(davRequest("DELETE", "/dav/archive", "")), "Unexpected status code")
+	assert.Empty(t, sc.index.names(""), "Files not deleted")
+	assert.Equal(t, 404, serve(davRequest("PROPFIND", "/dav/archive", "", "Depth", "0")))
+}
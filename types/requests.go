@@ -0,0 +1,23 @@
+package types
+
+// UploadSessionRequest is the request for creating an upload session
+type UploadSessionRequest struct {
+	FileName  string `json:"fileName"`
+	FileSize  int64  `json:"fileSize"`
+	ChunkSize int64  `json:"chunkSize"`
+	Overwrite bool   `json:"overwrite"`
+
+	ContentType string            `json:"contentType,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+// SignRequest is the request for signing a URL, a GET without a file name
+// signs the file list under the prefix
+type SignRequest struct {
+	Method    string `json:"method"`
+	FileName  string `json:"fileName,omitempty"`
+	Prefix    string `json:"prefix,omitempty"`
+	ExpiresIn int64  `json:"expiresIn"`
+	MaxSize   int64  `json:"maxSize,omitempty"`
+	Overwrite bool   `json:"overwrite,omitempty"`
+}
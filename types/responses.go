@@ -4,9 +4,17 @@ import "time"
 
 // FileResponse is the response for a file information
 type FileResponse struct {
-	FileName  string    `json:"fileName"`
-	FileSize  int64     `json:"fileSize"`
-	CreatedAt time.Time `json:"createdAt"`
+	FileName    string            `json:"fileName"`
+	FileSize    int64             `json:"fileSize"`
+	CreatedAt   time.Time         `json:"createdAt"`
+	ModifiedAt  time.Time         `json:"modifiedAt"`
+	ContentType string            `json:"contentType,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	ETag        string            `json:"etag,omitempty"`
+
+	// S3ETag is the md5 ETag of S3 responses, empty for files indexed from
+	// their headers as it's only known after writing
+	S3ETag string `json:"s3ETag,omitempty"`
 }
 
 // GeneralResponse is a general response for a request
@@ -14,3 +22,88 @@ type GenericResponse struct {
 	Success bool   `json:"success"`
 	Message string `json:"message"`
 }
+
+// ByteRange is a range of bytes from Start (inclusive) to End (exclusive)
+type ByteRange struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+}
+
+// UploadSessionResponse is the response for an upload session
+type UploadSessionResponse struct {
+	UploadID  string      `json:"uploadId"`
+	FileName  string      `json:"fileName"`
+	FileSize  int64       `json:"fileSize"`
+	ChunkSize int64       `json:"chunkSize"`
+	Overwrite bool        `json:"overwrite"`
+	CreatedAt time.Time   `json:"createdAt"`
+	Received  []ByteRange `json:"received"`
+
+	ContentType string            `json:"contentType,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+
+	// Owner is the name of the API key which created the session
+	Owner string `json:"owner,omitempty"`
+}
+
+// FsckIssue is a problem found with a file while checking the data directory
+type FsckIssue struct {
+	Path        string `json:"path"`
+	FileName    string `json:"fileName,omitempty"`
+	Problem     string `json:"problem"`
+	Quarantined bool   `json:"quarantined"`
+
+	// QuarantinePath is the path of the file in the lost+found directory
+	QuarantinePath string `json:"quarantinePath,omitempty"`
+}
+
+// FsckReport is the result of checking the data directory
+type FsckReport struct {
+	CheckedAt time.Time   `json:"checkedAt"`
+	Checked   int         `json:"checked"`
+	Issues    []FsckIssue `json:"issues"`
+}
+
+// FileListResponse is the response for a page of the file list
+type FileListResponse struct {
+	Files          []FileResponse `json:"files"`
+	CommonPrefixes []string       `json:"commonPrefixes,omitempty"`
+	NextCursor     string         `json:"nextCursor,omitempty"`
+}
+
+// DeleteResponse is the response for deleting the files under a prefix
+type DeleteResponse struct {
+	Success bool     `json:"success"`
+	Message string   `json:"message"`
+	Deleted []string `json:"deleted"`
+}
+
+// SignResponse is the response for a signed URL, the URL is relative to the server
+type SignResponse struct {
+	Success   bool      `json:"success"`
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// InfoResponse is the response for the server information
+type InfoResponse struct {
+	Version        string `json:"version"`
+	Revision       string `json:"revision"`
+	MaxFileSize    int64  `json:"maxFileSize"`
+	MaxListSize    int    `json:"maxListSize"`
+	FormatVersions []int  `json:"formatVersions"`
+	FormatVersion  int    `json:"formatVersion"`
+}
+
+// ReadinessCheck is the result of a single readiness check
+type ReadinessCheck struct {
+	Name    string `json:"name"`
+	OK      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+}
+
+// ReadinessResponse is the response for the readiness of the server
+type ReadinessResponse struct {
+	Success bool             `json:"success"`
+	Checks  []ReadinessCheck `json:"checks"`
+}